@@ -0,0 +1,225 @@
+package solver
+
+import (
+	"context"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+const (
+	defaultAPITimeout           = 30 * time.Second
+	defaultSlowAPICallThreshold = 5 * time.Second
+	defaultAPIRetries           = 2
+)
+
+// instrumentedAPI wraps the bunny.net client used for one challenge. It
+// bounds every call with a deadline, retries transient failures, records
+// the outcome in bunnyAPIHealth and warns about calls slower than
+// slowThreshold.
+//
+// Listing zones can be slow on large accounts, so it has its own timeout
+// separate from reads and writes of a single zone.
+type instrumentedAPI struct {
+	api           DNSZoneAPI
+	ch            *v1alpha1.ChallengeRequest
+	listTimeout   time.Duration
+	mutateTimeout time.Duration
+	slowThreshold time.Duration
+	retries       int
+	backoff       apiBackoff
+	breaker       *circuitBreaker
+	// operation is the context of the Present or CleanUp the client is
+	// used for. Calls fail once it is done.
+	operation context.Context
+}
+
+// instrument wraps api for the challenge ch with the settings in cfg.
+// ListTimeout and MutateTimeout fall back to APITimeout, and that to its
+// default.
+func (c *bunnySolver) instrument(api DNSZoneAPI, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig) instrumentedAPI {
+	global := cfg.APITimeout.Duration
+	if global <= 0 {
+		global = defaultAPITimeout
+	}
+	t := instrumentedAPI{api: api, ch: ch, listTimeout: global, mutateTimeout: global, slowThreshold: defaultSlowAPICallThreshold}
+	if cfg.ListTimeout.Duration > 0 {
+		t.listTimeout = cfg.ListTimeout.Duration
+	}
+	if cfg.MutateTimeout.Duration > 0 {
+		t.mutateTimeout = cfg.MutateTimeout.Duration
+	}
+	if cfg.SlowAPICallThreshold != nil {
+		t.slowThreshold = cfg.SlowAPICallThreshold.Duration
+	}
+	t.retries = defaultAPIRetries
+	if cfg.APIRetries != nil {
+		t.retries = *cfg.APIRetries
+	}
+	t.backoff = apiBackoff{transient: c.apiRetryBackoff, maintenance: c.maintenanceBackoff}
+	t.breaker = c.breaker
+	return t
+}
+
+// unwrap returns the underlying client, which unlike the wrapper is the
+// same for every challenge using one credential Secret.
+func unwrap(api DNSZoneAPI) DNSZoneAPI {
+	if t, ok := api.(instrumentedAPI); ok {
+		api = t.api
+	}
+	if cc, ok := api.(*credentialChain); ok {
+		return cc.current()
+	}
+	return api
+}
+
+// call runs f with retries, see retry, unless the circuit breaker is open.
+func (t instrumentedAPI) call(ctx context.Context, op string, timeout time.Duration, policy retryPolicy, f func(context.Context) error) error {
+	if t.operation != nil {
+		if err := t.operation.Err(); err != nil {
+			return err
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = withCancelOn(ctx, t.operation.Done())
+		defer cancel()
+	}
+	if err := t.breaker.allow(); err != nil {
+		return err
+	}
+	err := retry(ctx, t.retries, t.backoff, policy, t.logger(), func() error {
+		return t.attempt(ctx, op, timeout, f)
+	})
+	if ctx.Err() != nil {
+		// The caller or the operation gave up, which says nothing about
+		// bunny.net; only the call's own timeout counts as an outage.
+		t.breaker.abandon()
+		return err
+	}
+	t.breaker.record(err)
+	return err
+}
+
+func (t instrumentedAPI) logger() fieldLogger {
+	if t.ch != nil {
+		return challengeLogger(t.ch)
+	}
+	return rootLogger
+}
+
+// attempt runs f once under the operation's timeout. A bunny.net error is
+// returned with its parsed detail attached, see apiErrorDetails.
+func (t instrumentedAPI) attempt(ctx context.Context, op string, timeout time.Duration, f func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	err := withAPIErrorDetail(f(ctx))
+	elapsed := time.Since(start)
+	bunnyAPIHealth.record(op, err)
+	if t.slowThreshold > 0 && elapsed >= t.slowThreshold {
+		slowAPICalls.WithLabelValues(op).Inc()
+		t.logger().warning("slow bunny.net API call", field("apiOperation", op), field("duration", elapsed))
+	}
+	return err
+}
+
+func (t instrumentedAPI) Get(ctx context.Context, id int64) (zone *bunny.DNSZone, err error) {
+	err = t.call(ctx, "get", t.mutateTimeout, idempotent, func(ctx context.Context) error {
+		zone, err = t.api.Get(ctx, id)
+		return err
+	})
+	return zone, err
+}
+
+func (t instrumentedAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (zones *bunny.DNSZones, err error) {
+	err = t.call(ctx, "list", t.listTimeout, idempotent, func(ctx context.Context) error {
+		zones, err = t.api.List(ctx, opts)
+		return err
+	})
+	return zones, err
+}
+
+// AddDNSRecord is not idempotent: repeating an add that reached bunny.net
+// but failed on the way back writes a second record. Before repeating it,
+// the zone is checked for the record the failed call may have added.
+func (t instrumentedAPI) AddDNSRecord(ctx context.Context, dnsZoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (record *bunny.DNSRecord, err error) {
+	policy := retryPolicy{landed: func() (bool, error) {
+		var zone *bunny.DNSZone
+		err := t.attempt(ctx, "get", t.mutateTimeout, func(ctx context.Context) (err error) {
+			zone, err = t.api.Get(ctx, dnsZoneID)
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+		if added := addedRecord(zone, opts); added != nil {
+			record = added
+			return true, nil
+		}
+		return false, nil
+	}}
+	err = t.call(ctx, "add", t.mutateTimeout, policy, func(ctx context.Context) error {
+		record, err = t.api.AddDNSRecord(ctx, dnsZoneID, opts)
+		return err
+	})
+	return record, err
+}
+
+// addedRecord returns the record in zone that an add with opts created, if
+// any. bunny.net may store a name sent with the zone appended without it,
+// so either form counts; the marker's write time in the tag tells the
+// record apart from others of the name.
+func addedRecord(zone *bunny.DNSZone, opts *bunny.AddOrUpdateDNSRecordOptions) *bunny.DNSRecord {
+	if zone == nil {
+		return nil
+	}
+	var domain string
+	if zone.Domain != nil {
+		domain = *zone.Domain
+	}
+	for i, r := range zone.Records {
+		if r.Type == nil || r.Name == nil || r.Value == nil || r.Tag == nil || opts.Tag == nil {
+			continue
+		}
+		if *r.Type == *opts.Type && isSentRecordName(*r.Name, *opts.Name, domain) &&
+			normalizeTXTValue(*r.Value) == normalizeTXTValue(*opts.Value) && *r.Tag == *opts.Tag {
+			return &zone.Records[i]
+		}
+	}
+	return nil
+}
+
+// isSentRecordName reports whether a record name bunny.net returned from
+// zone is the name sent, or the name sent without the zone appended.
+func isSentRecordName(recordName, sent, zone string) bool {
+	got, ok := recordNameLabels(recordName, zone)
+	if !ok {
+		return false
+	}
+	want, zoneLabels := nameLabels(sent), nameLabels(zone)
+	if sameLabels(got, want) {
+		return true
+	}
+	n := len(want) - len(zoneLabels)
+	return n > 0 && sameLabels(want[n:], zoneLabels) && sameLabels(got, want[:n])
+}
+
+func (t instrumentedAPI) UpdateDNSRecord(ctx context.Context, dnsZoneID int64, dnsRecordID int64, opts *bunny.AddOrUpdateDNSRecordOptions) error {
+	return t.call(ctx, "update", t.mutateTimeout, idempotent, func(ctx context.Context) error {
+		return t.api.UpdateDNSRecord(ctx, dnsZoneID, dnsRecordID, opts)
+	})
+}
+
+// DeleteDNSRecord treats a record that is gone when a delete is repeated
+// as deleted, since the earlier attempt may have removed it.
+func (t instrumentedAPI) DeleteDNSRecord(ctx context.Context, dnsZoneID int64, dnsRecordID int64) error {
+	attempts := 0
+	return t.call(ctx, "delete", t.mutateTimeout, idempotent, func(ctx context.Context) error {
+		attempts++
+		err := t.api.DeleteDNSRecord(ctx, dnsZoneID, dnsRecordID)
+		if attempts > 1 && isNotFound(err) {
+			return nil
+		}
+		return err
+	})
+}
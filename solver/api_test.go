@@ -0,0 +1,125 @@
+package solver
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// deadlineAPI records how far away the deadline of each call's context was.
+type deadlineAPI struct {
+	*fakeDNSZoneAPI
+	timeouts map[string]time.Duration
+}
+
+func (d *deadlineAPI) record(ctx context.Context, op string) {
+	if deadline, ok := ctx.Deadline(); ok {
+		d.timeouts[op] = time.Until(deadline).Round(time.Second)
+	}
+}
+
+func (d *deadlineAPI) Get(ctx context.Context, id int64) (*bunny.DNSZone, error) {
+	d.record(ctx, "get")
+	return d.fakeDNSZoneAPI.Get(ctx, id)
+}
+
+func (d *deadlineAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	d.record(ctx, "list")
+	return d.fakeDNSZoneAPI.List(ctx, opts)
+}
+
+func (d *deadlineAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	d.record(ctx, "add")
+	return d.fakeDNSZoneAPI.AddDNSRecord(ctx, zoneID, opts)
+}
+
+func (d *deadlineAPI) DeleteDNSRecord(ctx context.Context, zoneID int64, recordID int64) error {
+	d.record(ctx, "delete")
+	return d.fakeDNSZoneAPI.DeleteDNSRecord(ctx, zoneID, recordID)
+}
+
+func TestAPITimeouts(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		wantList   time.Duration
+		wantMutate time.Duration
+	}{
+		{"defaults", "", defaultAPITimeout, defaultAPITimeout},
+		{"global", `,"apiTimeout":"20s"`, 20 * time.Second, 20 * time.Second},
+		{"list override", `,"apiTimeout":"20s","listTimeout":"2m"`, 2 * time.Minute, 20 * time.Second},
+		{"mutate override", `,"mutateTimeout":"5s"`, defaultAPITimeout, 5 * time.Second},
+		{"both overrides", `,"apiTimeout":"20s","listTimeout":"1m","mutateTimeout":"10s"`, time.Minute, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &deadlineAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), timeouts: map[string]time.Duration{}}
+			solver := newTestSolver(api)
+			ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config)
+			if err := solver.Present(ch); err != nil {
+				t.Fatal(err)
+			}
+			if err := solver.CleanUp(ch); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]time.Duration{"list": tt.wantList, "get": tt.wantMutate, "add": tt.wantMutate, "delete": tt.wantMutate}
+			for op, d := range want {
+				if got := api.timeouts[op]; got != d {
+					t.Errorf("%s timeout is %s, want %s", op, got, d)
+				}
+			}
+		})
+	}
+}
+
+// slowAPI delays zone reads.
+type slowAPI struct {
+	*fakeDNSZoneAPI
+	delay time.Duration
+}
+
+func (s *slowAPI) Get(ctx context.Context, id int64) (*bunny.DNSZone, error) {
+	time.Sleep(s.delay)
+	return s.fakeDNSZoneAPI.Get(ctx, id)
+}
+
+func TestSlowAPICallWarning(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	before := counterValue(t, slowAPICalls.WithLabelValues("get"))
+	api := &slowAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), delay: 20 * time.Millisecond}
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"slowAPICallThreshold":"10ms"`)
+	if err := newTestSolver(api).Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := counterValue(t, slowAPICalls.WithLabelValues("get")) - before; got != 1 {
+		t.Errorf("slow get calls increased by %v, want 1", got)
+	}
+	if got := counterValue(t, slowAPICalls.WithLabelValues("list")); got != 0 {
+		t.Errorf("got %v slow list calls, want 0", got)
+	}
+	if !strings.Contains(buf.String(), "[test-uid] warning: slow bunny.net API call") || !strings.Contains(buf.String(), "apiOperation=get") {
+		t.Errorf("log %q does not warn about the slow get", buf.String())
+	}
+}
+
+func TestInstrumentedClientsShareZoneCache(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	for _, key := range []string{"key-1", "key-2"} {
+		if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", key, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if api.listCalls != 1 {
+		t.Errorf("List called %d times, want 1", api.listCalls)
+	}
+}
@@ -0,0 +1,124 @@
+package solver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// apiErrorDetail is what a failed bunny.net API call reported: the HTTP
+// status and the ErrorKey, Field and Message of the JSON error body, as
+// far as the response had them.
+type apiErrorDetail struct {
+	Status  int
+	Key     string
+	Field   string
+	Message string
+}
+
+// apiError attaches the detail parsed from a bunny.net error to it. It
+// reads and unwraps as the original error.
+type apiError struct {
+	err    error
+	detail apiErrorDetail
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+// withAPIErrorDetail returns err with its bunny.net error detail attached,
+// or err itself if it is not a bunny.net API error.
+func withAPIErrorDetail(err error) error {
+	var attached *apiError
+	if err == nil || errors.As(err, &attached) {
+		return err
+	}
+	if d, ok := parseAPIError(err); ok {
+		return &apiError{err: err, detail: d}
+	}
+	return err
+}
+
+// apiErrorDetails returns the bunny.net error detail of err, which callers
+// branch on instead of matching the error's text. It is false if err is
+// not a bunny.net API error.
+func apiErrorDetails(err error) (apiErrorDetail, bool) {
+	var attached *apiError
+	if errors.As(err, &attached) {
+		return attached.detail, true
+	}
+	return parseAPIError(err)
+}
+
+// parseAPIError reads the detail of the bunny-go error in err's chain.
+// bunny-go parses JSON error bodies itself only when they are labelled as
+// JSON, so the raw body of an HTTPError is parsed here too.
+func parseAPIError(err error) (apiErrorDetail, bool) {
+	var authErr *bunny.AuthenticationError
+	var apiErr *bunny.APIError
+	var httpErr *bunny.HTTPError
+	switch {
+	case errors.As(err, &authErr):
+		return apiErrorDetail{Status: http.StatusUnauthorized, Message: authErr.Message}, true
+	case errors.As(err, &apiErr):
+		return apiErrorDetail{Status: apiErr.StatusCode, Key: apiErr.ErrorKey, Field: apiErr.Field, Message: apiErr.Message}, true
+	case errors.As(err, &httpErr):
+		d := apiErrorDetail{Status: httpErr.StatusCode}
+		var body struct {
+			ErrorKey string
+			Field    string
+			Message  string
+		}
+		if json.Unmarshal(httpErr.RespBody, &body) == nil {
+			d.Key, d.Field, d.Message = body.ErrorKey, body.Field, body.Message
+		}
+		return d, true
+	}
+	return apiErrorDetail{}, false
+}
+
+// hasBody reports whether the error body said more than the status code.
+func (d apiErrorDetail) hasBody() bool {
+	return d.Key != "" || d.Field != "" || d.Message != ""
+}
+
+// forbidden reports whether bunny.net rejected the access key or refused
+// it the call.
+func (d apiErrorDetail) forbidden() bool {
+	return d.Status == http.StatusUnauthorized || d.Status == http.StatusForbidden
+}
+
+// notFound reports whether the zone or record the call named does not
+// exist. bunny.net answers some of those with 400 and an ErrorKey ending
+// in not_found rather than with 404.
+func (d apiErrorDetail) notFound() bool {
+	return d.Status == http.StatusNotFound || strings.HasSuffix(d.Key, ".not_found") || d.Key == "not_found"
+}
+
+// apiErrorFields returns the bunny.net error detail of err as log fields,
+// leaving out what the response did not have.
+func apiErrorFields(err error) []logField {
+	d, ok := apiErrorDetails(err)
+	if !ok {
+		return nil
+	}
+	fields := []logField{field("statusCode", d.Status)}
+	if d.Key != "" {
+		fields = append(fields, field("errorKey", d.Key))
+	}
+	if d.Field != "" {
+		fields = append(fields, field("errorField", d.Field))
+	}
+	if d.Message != "" {
+		fields = append(fields, field("errorMessage", d.Message))
+	}
+	return fields
+}
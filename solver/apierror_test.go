@@ -0,0 +1,96 @@
+package solver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestAPIErrorDetails(t *testing.T) {
+	url := "https://api.bunny.net/dnszone/1/records"
+	tests := []struct {
+		name                string
+		err                 error
+		want                apiErrorDetail
+		forbidden, notFound bool
+	}{
+		{"api error", fmt.Errorf("failed to add TXT record: %w", &bunny.APIError{
+			HTTPError: bunny.HTTPError{RequestURL: url, StatusCode: 400},
+			ErrorKey:  "dnszone.record.invalid", Field: "Value", Message: "The value is invalid.",
+		}), apiErrorDetail{Status: 400, Key: "dnszone.record.invalid", Field: "Value", Message: "The value is invalid."}, false, false},
+		{"unlabelled JSON body", &bunny.HTTPError{RequestURL: url, StatusCode: 429,
+			RespBody: []byte(`{"ErrorKey":"ratelimit.exceeded","Message":"Too many requests, slow down."}`)},
+			apiErrorDetail{Status: 429, Key: "ratelimit.exceeded", Message: "Too many requests, slow down."}, false, false},
+		{"not found key", &bunny.APIError{HTTPError: bunny.HTTPError{RequestURL: url, StatusCode: 400},
+			ErrorKey: "dnszone.record.not_found", Message: "The requested DNS record was not found"},
+			apiErrorDetail{Status: 400, Key: "dnszone.record.not_found", Message: "The requested DNS record was not found"}, false, true},
+		{"not found", &bunny.HTTPError{RequestURL: url, StatusCode: 404},
+			apiErrorDetail{Status: 404}, false, true},
+		{"forbidden", &bunny.HTTPError{RequestURL: url, StatusCode: 403, RespBody: []byte(`{"Message":"Access denied"}`)},
+			apiErrorDetail{Status: 403, Message: "Access denied"}, true, false},
+		{"authentication", &bunny.AuthenticationError{Message: "Unauthorized"},
+			apiErrorDetail{Status: 401, Message: "Unauthorized"}, true, false},
+		{"HTML body", &bunny.HTTPError{RequestURL: url, StatusCode: 502, RespBody: []byte("<html>")},
+			apiErrorDetail{Status: 502}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, ok := apiErrorDetails(tt.err)
+			if !ok || d != tt.want {
+				t.Fatalf("got %+v, %v, want %+v", d, ok, tt.want)
+			}
+			if d.forbidden() != tt.forbidden || d.notFound() != tt.notFound {
+				t.Errorf("got forbidden %v, not found %v, want %v, %v",
+					d.forbidden(), d.notFound(), tt.forbidden, tt.notFound)
+			}
+		})
+	}
+	if _, ok := apiErrorDetails(errors.New("challenge FQDN is not within zone")); ok {
+		t.Error("got detail for an error that is not from bunny.net")
+	}
+}
+
+// forbiddenAddAPI refuses every record add with a JSON body bunny-go did
+// not parse.
+type forbiddenAddAPI struct {
+	*fakeDNSZoneAPI
+}
+
+func (f *forbiddenAddAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	return nil, &bunny.HTTPError{
+		RequestURL: "https://api.bunny.net/dnszone/1/records",
+		StatusCode: 403,
+		RespBody:   []byte(`{"ErrorKey":"dnszone.permission_denied","Message":"The API key may not modify this zone."}`),
+	}
+}
+
+func TestPresentAttachesAPIErrorDetail(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := &forbiddenAddAPI{newFakeDNSZoneAPI("example.com")}
+	err := newTestSolver(api).Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", ""))
+	if err == nil {
+		t.Fatal("Present succeeded")
+	}
+	var attached *apiError
+	if !errors.As(err, &attached) || !attached.detail.forbidden() || attached.detail.Key != "dnszone.permission_denied" {
+		t.Errorf("error %q does not carry the parsed detail", err)
+	}
+	if want := "bunny.net API error 403: The API key may not modify this zone."; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not contain %q", err, want)
+	}
+	for _, want := range []string{"statusCode=403", "errorKey=dnszone.permission_denied"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log %q does not contain %s", buf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+// recordBackend writes and deletes challenge TXT records at one DNS
+// provider. bunny.net is the only built-in backend; the solver can hold
+// several, for example to write challenges to the old provider as well
+// while migrating a domain to bunny.net.
+type recordBackend interface {
+	// name identifies the backend in errors.
+	name() string
+	// present writes the TXT record recordName, relative to the
+	// challenge's zone, with the given value. It must be idempotent and
+	// stop once ctx is done.
+	present(ctx context.Context, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, recordName, value string) error
+	// cleanUp deletes the TXT record recordName holding the challenge
+	// key. A missing record or zone is not an error.
+	cleanUp(ctx context.Context, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, recordName string) error
+}
+
+// bunnyBackend is the recordBackend for bunny.net DNS.
+type bunnyBackend struct {
+	c *bunnySolver
+}
+
+func (b bunnyBackend) name() string {
+	return "bunny.net"
+}
+
+// recordBackends returns the backends to write challenge records to.
+func (c *bunnySolver) recordBackends() []recordBackend {
+	if len(c.backends) == 0 {
+		return []recordBackend{bunnyBackend{c}}
+	}
+	return c.backends
+}
+
+// backendError names the backend that failed when there is more than one,
+// so that the failing provider is obvious.
+func backendError(backends []recordBackend, backend recordBackend, err error) error {
+	if len(backends) < 2 {
+		return err
+	}
+	return fmt.Errorf("%s: %w", backend.name(), err)
+}
@@ -0,0 +1,80 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// recordingBackend remembers the records it was asked to write and delete.
+type recordingBackend struct {
+	presented []string
+	cleaned   []string
+	err       error
+}
+
+func (r *recordingBackend) name() string { return "recording" }
+
+func (r *recordingBackend) present(ctx context.Context, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, recordName, value string) error {
+	r.presented = append(r.presented, recordName+"="+value)
+	return r.err
+}
+
+func (r *recordingBackend) cleanUp(ctx context.Context, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, recordName string) error {
+	r.cleaned = append(r.cleaned, recordName)
+	return r.err
+}
+
+func TestPresentWritesToEveryBackend(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	other := &recordingBackend{}
+	solver.backends = []recordBackend{bunnyBackend{solver}, other}
+	ch := newChallenge("_acme-challenge.www.example.com.", "example.com.", "key", "")
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.txtValues(1); len(got) != 1 || got[0] != "key" {
+		t.Errorf("bunny.net TXT values %v, want [key]", got)
+	}
+	if len(other.presented) != 1 || other.presented[0] != "_acme-challenge.www=key" {
+		t.Errorf("other backend presented %v, want [_acme-challenge.www=key]", other.presented)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.txtValues(1); len(got) != 0 {
+		t.Errorf("bunny.net TXT values after clean up %v, want none", got)
+	}
+	if len(other.cleaned) != 1 || other.cleaned[0] != "_acme-challenge.www" {
+		t.Errorf("other backend cleaned up %v, want [_acme-challenge.www]", other.cleaned)
+	}
+}
+
+func TestBackendErrorsNameTheBackend(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	failing := &recordingBackend{err: errors.New("provider down")}
+	solver.backends = []recordBackend{failing, bunnyBackend{solver}}
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")
+	err := solver.Present(ch)
+	if err == nil || !strings.HasPrefix(err.Error(), "recording: provider down") {
+		t.Errorf("got error %v, want it to name the failing backend", err)
+	}
+	if api.addCalls != 0 {
+		t.Errorf("AddDNSRecord called %d times after an earlier backend failed", api.addCalls)
+	}
+
+	// Clean up still reaches the backends after the failing one.
+	api.zones[0].Records = []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "key")}
+	if err := solver.CleanUp(ch); err == nil {
+		t.Error("CleanUp succeeded despite a failing backend")
+	}
+	if api.deleteCalls != 1 {
+		t.Errorf("DeleteDNSRecord called %d times, want 1", api.deleteCalls)
+	}
+}
@@ -0,0 +1,161 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	bunny "github.com/simplesurance/bunny-go"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// presentBatch presents several challenges at once. Presented one by one,
+// a certificate with many names in one zone costs a credential read, a
+// zone lookup and a read of the whole zone per name; presented together,
+// each zone is resolved and read once and only the writes remain per
+// challenge. cert-manager's webhook.Solver has no batch call yet, so
+// nothing calls this outside of tests; a batch Present only needs to hand
+// its challenges over once the interface offers one.
+//
+// Challenges are grouped by namespace, zone and solver config, which
+// decide the credentials and zone lookup. With additional record backends
+// every challenge is presented on its own. The first failing group stops
+// the batch, as cert-manager retries the challenges that are not ready.
+func (c *bunnySolver) presentBatch(chs []*v1alpha1.ChallengeRequest) error {
+	if len(c.backends) > 0 {
+		for _, ch := range chs {
+			if err := c.present(ch); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var groups [][]presentRequest
+	index := map[presentGroupKey]int{}
+	for _, ch := range chs {
+		req, err := c.preparePresent(ch)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ch.ResolvedFQDN, err)
+		}
+		key := newPresentGroupKey(req.ch)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], req)
+	}
+	if c.presentSlots != nil {
+		c.presentSlots <- struct{}{}
+		defer func() { <-c.presentSlots }()
+	}
+	for _, reqs := range groups {
+		if err := c.presentGroup(reqs); err != nil {
+			return err
+		}
+		for _, req := range reqs {
+			c.notifier.notify("Present", req.ch, req.recordName)
+		}
+	}
+	return nil
+}
+
+// presentGroupKey identifies challenges that share credentials and zones.
+type presentGroupKey struct {
+	namespace string
+	zone      string
+	config    string
+}
+
+func newPresentGroupKey(ch *v1alpha1.ChallengeRequest) presentGroupKey {
+	key := presentGroupKey{namespace: ch.ResourceNamespace, zone: ch.ResolvedZone}
+	if ch.Config != nil {
+		key.config = string(ch.Config.Raw)
+	}
+	return key
+}
+
+// presentGroup writes the records of challenges with the same group key,
+// resolving their zones and reading each zone's records once.
+func (c *bunnySolver) presentGroup(reqs []presentRequest) error {
+	first := reqs[0]
+	return c.withOperationTimeout(first.cfg, "present", func(ctx context.Context) error {
+		return traced(ctx, "Present batch", func(ctx context.Context) error {
+			bunnyClient, err := c.newAPIClient(ctx, first.ch, first.cfg)
+			if err != nil {
+				return err
+			}
+			window := c.newZoneRetryWindow(bunnyClient, first.ch.ResolvedZone, first.cfg)
+			return c.retryNewZone(ctx, first.ch, window, func() error {
+				return c.inResolvedZones(ctx, bunnyClient, first.ch, first.cfg, func(zoneIDs []int64) error {
+					return c.presentGroupInZones(ctx, bunnyClient, reqs, zoneIDs)
+				})
+			})
+		}, attribute.String("zone", first.ch.ResolvedZone), attribute.Int("challenges", len(reqs)))
+	})
+}
+
+// presentGroupInZones writes the records of a group to each of zoneIDs,
+// reading each zone once.
+func (c *bunnySolver) presentGroupInZones(ctx context.Context, bunnyClient DNSZoneAPI, reqs []presentRequest, zoneIDs []int64) error {
+	first := reqs[0]
+	for _, zoneID := range zoneIDs {
+		var records []bunny.DNSRecord
+		err := traced(ctx, "check records", func(context.Context) (err error) {
+			records, err = c.zoneRecords(bunnyClient, zoneID)
+			return err
+		}, attribute.Int64("zone.id", zoneID))
+		if err != nil {
+			return err
+		}
+		var expected []expectedRecord
+		for _, req := range reqs {
+			records, err = c.writeChallengeRecord(ctx, bunnyClient, req.ch, req.cfg, req.recordName, req.value, zoneID, records)
+			if err != nil {
+				return fmt.Errorf("%s: %w", req.ch.ResolvedFQDN, err)
+			}
+			expected = append(expected, expectedRecord{name: req.recordName, value: req.ch.Key, format: req.cfg.RecordNameFormat})
+		}
+		if first.cfg.VerifyBatch {
+			err := traced(ctx, "verify records", func(context.Context) error {
+				return c.verifyBatch(bunnyClient, challengeLogger(first.ch), first.ch.ResolvedZone, zoneID, expected)
+			}, attribute.Int64("zone.id", zoneID))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expectedRecord is a challenge record a batch wrote, as verifyBatch
+// looks for it.
+type expectedRecord struct {
+	name   string
+	value  string
+	format string
+}
+
+// verifyBatch reads the zone once and confirms that it holds every
+// expected record, instead of reading each record back on its own. It
+// fails with one error naming every record that is missing.
+func (c *bunnySolver) verifyBatch(client DNSZoneAPI, logger fieldLogger, zone string, zoneID int64, expected []expectedRecord) error {
+	records, err := c.zoneRecords(client, zoneID)
+	if err != nil {
+		return fmt.Errorf("failed to verify TXT records: %w", err)
+	}
+	var missing []string
+	for _, e := range expected {
+		if len(matchTXTRecords(records, e.name, e.value, zone, e.format)) == 0 {
+			missing = append(missing, fmt.Sprintf("%s=%q", e.name, e.value))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%d of %d challenge TXT records missing from zone %d after presenting them: %s",
+			len(missing), len(expected), zoneID, strings.Join(missing, ", "))
+	}
+	logger.info("all challenge TXT records present", field("zone", zoneID), field("records", len(expected)))
+	return nil
+}
@@ -0,0 +1,152 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestPresentBatchReadsZoneOnce(t *testing.T) {
+	api := newFakeDNSZoneAPI("other.com", "example.com")
+	solver := newTestSolver(api)
+	var chs []*v1alpha1.ChallengeRequest
+	var want []string
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		chs = append(chs, newChallenge(fmt.Sprintf("_acme-challenge.host%d.example.com.", i), "example.com.", key, ""))
+		want = append(want, key)
+	}
+
+	if err := solver.presentBatch(chs); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.txtValues(2); !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+	if api.listCalls != 1 || api.getCalls != 1 || api.addCalls != 5 {
+		t.Errorf("batch made %d List, %d Get and %d add calls, want 1, 1 and 5", api.listCalls, api.getCalls, api.addCalls)
+	}
+
+	// Presenting again finds every record in the single zone read.
+	if err := solver.presentBatch(chs); err != nil {
+		t.Fatal(err)
+	}
+	if api.getCalls != 2 || api.addCalls != 5 {
+		t.Errorf("second batch made %d Get and %d add calls in total, want 2 and 5", api.getCalls, api.addCalls)
+	}
+}
+
+func TestPresentBatchSharedRecordName(t *testing.T) {
+	// A certificate for a domain and its wildcard has two challenges for
+	// the same record name; the second must see the first's record.
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	chs := []*v1alpha1.ChallengeRequest{
+		newChallenge("_acme-challenge.example.com.", "example.com.", "apex-key", ""),
+		newChallenge("_acme-challenge.example.com.", "example.com.", "wildcard-key", ""),
+		newChallenge("_acme-challenge.example.com.", "example.com.", "apex-key", ""),
+	}
+
+	if err := solver.presentBatch(chs); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := api.txtValues(1), []string{"apex-key", "wildcard-key"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+}
+
+func TestPresentBatchGroupsByZone(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com", "example.org")
+	solver := newTestSolver(api)
+	chs := []*v1alpha1.ChallengeRequest{
+		newChallenge("_acme-challenge.a.example.com.", "example.com.", "com-a", ""),
+		newChallenge("_acme-challenge.a.example.org.", "example.org.", "org-a", ""),
+		newChallenge("_acme-challenge.b.example.com.", "example.com.", "com-b", ""),
+	}
+
+	if err := solver.presentBatch(chs); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := api.txtValues(1), []string{"com-a", "com-b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("example.com records = %q, want %q", got, want)
+	}
+	if got, want := api.txtValues(2), []string{"org-a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("example.org records = %q, want %q", got, want)
+	}
+	if api.getCalls != 2 {
+		t.Errorf("batch read zones %d times, want once per zone", api.getCalls)
+	}
+}
+
+func TestPresentBatchRejectsInvalidChallengeUpFront(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	chs := []*v1alpha1.ChallengeRequest{
+		newChallenge("_acme-challenge.a.example.com.", "example.com.", "key", ""),
+		newChallenge("_acme-challenge.example.org.", "example.com.", "key", ""),
+	}
+
+	if err := solver.presentBatch(chs); err == nil {
+		t.Fatal("presentBatch accepted a challenge outside its zone")
+	}
+	if api.listCalls != 0 || api.addCalls != 0 {
+		t.Errorf("batch called the API (%d List, %d add calls) before rejecting it", api.listCalls, api.addCalls)
+	}
+}
+
+// droppingAPI acknowledges adds of the values in drop without storing them.
+type droppingAPI struct {
+	*fakeDNSZoneAPI
+	drop map[string]bool
+}
+
+func (d *droppingAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	if d.drop[*opts.Value] {
+		d.addCalls++
+		d.nextID++
+		id := d.nextID
+		return &bunny.DNSRecord{ID: &id, Type: opts.Type, Name: opts.Name, Value: opts.Value, TTL: opts.TTL, Tag: opts.Tag}, nil
+	}
+	return d.fakeDNSZoneAPI.AddDNSRecord(ctx, zoneID, opts)
+}
+
+func TestPresentBatchVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		drop    map[string]bool
+		wantErr string
+	}{
+		{"all present", nil, ""},
+		{"one missing", map[string]bool{"key-2": true}, `1 of 4 challenge TXT records missing from zone 1 after presenting them: _acme-challenge.host2="key-2"`},
+		{"two missing", map[string]bool{"key-0": true, "key-3": true},
+			`2 of 4 challenge TXT records missing from zone 1 after presenting them: _acme-challenge.host0="key-0", _acme-challenge.host3="key-3"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &droppingAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), drop: tt.drop}
+			solver := newTestSolver(api)
+			var chs []*v1alpha1.ChallengeRequest
+			for i := 0; i < 4; i++ {
+				chs = append(chs, newChallenge(fmt.Sprintf("_acme-challenge.host%d.example.com.", i), "example.com.",
+					fmt.Sprintf("key-%d", i), `,"verifyBatch":true`))
+			}
+			err := solver.presentBatch(chs)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			// One read before writing and one to verify.
+			if api.getCalls != 2 {
+				t.Errorf("batch read the zone %d times, want 2", api.getCalls)
+			}
+		})
+	}
+}
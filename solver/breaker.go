@@ -0,0 +1,156 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerWindow   = time.Minute
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// Circuit breaker states, as exported by the circuit_breaker_state metric.
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitOpenError is returned without calling bunny.net while the circuit
+// breaker is open. until is zero while a probe call is in flight.
+type circuitOpenError struct {
+	failures int
+	until    time.Time
+}
+
+func (e *circuitOpenError) Error() string {
+	if e.until.IsZero() {
+		return fmt.Sprintf("bunny.net circuit open after %d consecutive API failures, waiting for a probe call", e.failures)
+	}
+	return fmt.Sprintf("bunny.net circuit open after %d consecutive API failures, not calling it until %s",
+		e.failures, e.until.UTC().Format(time.RFC3339))
+}
+
+// circuitBreaker stops calling bunny.net for a cooldown once threshold calls
+// in a row failed within window. After the cooldown a single probe call is
+// let through, and its outcome closes or reopens the circuit. A nil
+// breaker lets every call through.
+type circuitBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu           sync.Mutex
+	state        int
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+}
+
+func newCircuitBreaker(threshold int, window, cooldown time.Duration) *circuitBreaker {
+	b := &circuitBreaker{threshold: threshold, window: window, cooldown: cooldown, now: time.Now}
+	circuitBreakerState.Set(breakerClosed)
+	return b
+}
+
+// allow reports whether a call may be made, and returns a
+// circuitOpenError if not.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		until := b.openedAt.Add(b.cooldown)
+		if b.now().Before(until) {
+			return &circuitOpenError{failures: b.failures, until: until}
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+	case breakerHalfOpen:
+		if b.probing {
+			return &circuitOpenError{failures: b.failures}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of an allowed call.
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if !isOutage(err) {
+		if b.state != breakerClosed {
+			rootLogger.info("bunny.net API recovered, closing circuit")
+		}
+		b.failures = 0
+		b.probing = false
+		b.setState(breakerClosed)
+		return
+	}
+	if b.state == breakerHalfOpen {
+		b.failures++
+		b.probing = false
+		b.open(now)
+		return
+	}
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.state == breakerClosed && b.failures >= b.threshold {
+		b.open(now)
+	}
+}
+
+// abandon ends an allowed call whose outcome says nothing about bunny.net,
+// such as one cut short by its operation's timeout, without counting it.
+// A probe call abandoned lets the next call probe instead.
+func (b *circuitBreaker) abandon() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.openedAt = now
+	b.setState(breakerOpen)
+	rootLogger.warning("bunny.net API keeps failing, opening circuit", field("failures", b.failures), field("cooldown", b.cooldown))
+}
+
+func (b *circuitBreaker) setState(state int) {
+	b.state = state
+	circuitBreakerState.Set(float64(state))
+}
+
+// isOutage reports whether err suggests bunny.net is unavailable, as
+// opposed to rejecting a particular request.
+func isOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return statusCode(err) >= http.StatusInternalServerError ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr)
+}
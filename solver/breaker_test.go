@@ -0,0 +1,143 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+var errUnavailable = &bunny.HTTPError{StatusCode: http.StatusInternalServerError}
+
+// newTestBreaker returns a breaker opening after 3 failures within a
+// minute for 30s, and a function advancing its clock.
+func newTestBreaker() (*circuitBreaker, func(time.Duration)) {
+	b := newCircuitBreaker(3, time.Minute, 30*time.Second)
+	now := time.Unix(1700000000, 0)
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+func failCalls(t *testing.T, b *circuitBreaker, n int, err error) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if allowErr := b.allow(); allowErr != nil {
+			t.Fatalf("call %d rejected: %v", i+1, allowErr)
+		}
+		b.record(err)
+	}
+}
+
+func TestCircuitBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	b, _ := newTestBreaker()
+	failCalls(t, b, 3, errUnavailable)
+	err := b.allow()
+	var open *circuitOpenError
+	if !errors.As(err, &open) || !strings.Contains(err.Error(), "bunny.net circuit open") {
+		t.Fatalf("got %v, want a circuit open error", err)
+	}
+	if got := testutil.ToFloat64(circuitBreakerState); got != breakerOpen {
+		t.Errorf("circuit_breaker_state is %v, want %d", got, breakerOpen)
+	}
+}
+
+func TestCircuitBreakerIgnoresSpreadOutAndClientErrors(t *testing.T) {
+	b, advance := newTestBreaker()
+	failCalls(t, b, 2, errUnavailable)
+	advance(2 * time.Minute)
+	failCalls(t, b, 2, errUnavailable)
+	failCalls(t, b, 5, &bunny.HTTPError{StatusCode: http.StatusNotFound})
+	failCalls(t, b, 2, errUnavailable)
+	if err := b.allow(); err != nil {
+		t.Errorf("breaker opened: %v", err)
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	b, advance := newTestBreaker()
+	failCalls(t, b, 3, errUnavailable)
+	advance(31 * time.Second)
+
+	// Only one probe is let through, and its failure reopens the circuit.
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	if err := b.allow(); err == nil {
+		t.Fatal("second call allowed while probing")
+	}
+	b.record(errUnavailable)
+	if err := b.allow(); err == nil {
+		t.Fatal("call allowed after the probe failed")
+	}
+
+	advance(31 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	b.record(nil)
+	if err := b.allow(); err != nil {
+		t.Errorf("call rejected after the probe succeeded: %v", err)
+	}
+	if got := testutil.ToFloat64(circuitBreakerState); got != breakerClosed {
+		t.Errorf("circuit_breaker_state is %v, want %d", got, breakerClosed)
+	}
+}
+
+// outageAPI fails every List call with errUnavailable and counts them.
+type outageAPI struct {
+	*fakeDNSZoneAPI
+	listCalls int
+}
+
+func (o *outageAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	o.listCalls++
+	return nil, errUnavailable
+}
+
+func TestInstrumentedAPIFailsFastWhenCircuitOpen(t *testing.T) {
+	api := &outageAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com")}
+	solver := newTestSolver(api)
+	solver.breaker, _ = newTestBreaker()
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"disableZoneCache":true,"apiRetries":0,"newZoneRetryWindow":"0s"`)
+	for i := 0; i < 5; i++ {
+		solver.Present(ch)
+	}
+	if api.listCalls != 3 {
+		t.Errorf("List called %d times, want the breaker to stop calls after 3", api.listCalls)
+	}
+	if err := solver.Present(ch); err == nil || !strings.Contains(err.Error(), "circuit open") {
+		t.Errorf("got error %v, want a circuit open error", err)
+	}
+}
+
+// hangingAPI blocks every Get until its context is done and then fails
+// the way net/http reports a cancelled request.
+type hangingAPI struct {
+	*fakeDNSZoneAPI
+}
+
+func (h *hangingAPI) Get(ctx context.Context, id int64) (*bunny.DNSZone, error) {
+	<-ctx.Done()
+	return nil, &url.Error{Op: "Get", URL: "https://api.bunny.net/dnszone/1", Err: ctx.Err()}
+}
+
+func TestOperationTimeoutDoesNotOpenCircuit(t *testing.T) {
+	api := &hangingAPI{newFakeDNSZoneAPI("example.com")}
+	solver := newTestSolver(api)
+	solver.breaker, _ = newTestBreaker()
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"operationTimeout":"20ms","apiRetries":0`)
+	for i := 0; i < 5; i++ {
+		if err := solver.Present(ch); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got error %v, want a deadline exceeded error", err)
+		}
+	}
+	if err := solver.breaker.allow(); err != nil {
+		t.Errorf("breaker opened by operation timeouts: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package solver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+// validateChallengeRequest checks the names cert-manager resolved for a
+// challenge before anything is derived from them, so that an empty or
+// malformed request fails with an error naming the field.
+func validateChallengeRequest(ch *v1alpha1.ChallengeRequest) error {
+	if err := validateDomainName("resolvedFQDN", ch.ResolvedFQDN); err != nil {
+		return err
+	}
+	return validateDomainName("resolvedZone", ch.ResolvedZone)
+}
+
+// validateDomainName checks that name, optionally ending in a dot, is a
+// domain name made of non-empty labels of valid characters.
+func validateDomainName(field, name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid challenge request: %s is empty", field)
+	}
+	trimmed := strings.TrimSuffix(name, ".")
+	if trimmed == "" {
+		return fmt.Errorf("invalid challenge request: %s %q is the root zone", field, name)
+	}
+	for _, label := range strings.Split(trimmed, ".") {
+		if label == "" {
+			return fmt.Errorf("invalid challenge request: %s %q contains an empty label", field, name)
+		}
+		if label == wildcardLabel {
+			continue
+		}
+		for _, r := range label {
+			if !isLabelChar(r) {
+				return fmt.Errorf("invalid challenge request: %s %q contains invalid character %q", field, name, r)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package solver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMalformedChallengeRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		fqdn string
+		zone string
+		want string
+	}{
+		{"empty fqdn", "", "example.com.", "resolvedFQDN is empty"},
+		{"empty zone", "_acme-challenge.example.com.", "", "resolvedZone is empty"},
+		{"root zone", "_acme-challenge.example.com.", ".", `resolvedZone "." is the root zone`},
+		{"empty label", "_acme-challenge..example.com.", "example.com.", `resolvedFQDN "_acme-challenge..example.com." contains an empty label`},
+		{"leading dot", "_acme-challenge.example.com.", ".example.com.", `resolvedZone ".example.com." contains an empty label`},
+		{"whitespace", "_acme-challenge.example.com.", "example .com.", `resolvedZone "example .com." contains invalid character ' '`},
+		{"partial wildcard label", "_acme-challenge.w*.example.com.", "example.com.", `resolvedFQDN "_acme-challenge.w*.example.com." contains invalid character '*'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			solver := newTestSolver(api)
+			ch := newChallenge(tt.fqdn, tt.zone, "key", "")
+			for op, solve := range map[string]func() error{
+				"Present": func() error { return solver.Present(ch) },
+				"CleanUp": func() error { return solver.CleanUp(ch) },
+			} {
+				if err := solve(); err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Errorf("%s: got error %v, want one containing %q", op, err, tt.want)
+				}
+			}
+			if api.listCalls != 0 {
+				t.Errorf("zones were listed %d times for a malformed request", api.listCalls)
+			}
+		})
+	}
+}
+
+func TestValidateChallengeRequestAcceptsResolvedNames(t *testing.T) {
+	for _, ch := range []struct{ fqdn, zone string }{
+		{"_acme-challenge.example.com.", "example.com."},
+		{"_acme-challenge.www.Example.com", "Example.com"},
+		{"_acme-challenge.xn--bcher-kva.example.", "xn--bcher-kva.example."},
+	} {
+		if err := validateChallengeRequest(newChallenge(ch.fqdn, ch.zone, "key", "")); err != nil {
+			t.Errorf("%s in %s: %v", ch.fqdn, ch.zone, err)
+		}
+	}
+}
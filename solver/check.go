@@ -0,0 +1,100 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	bunny "github.com/simplesurance/bunny-go"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// checkFlag runs the webhook as a preflight check instead of serving.
+const checkFlag = "--check"
+
+// checkChallengeKey stands in for the key of the challenge --check
+// resolves, which has none, in the form strict key checks accept.
+var checkChallengeKey = strings.Repeat("A", 43)
+
+// runCheck resolves the zone and record name a challenge for the given FQDN
+// would use and prints them, without writing to bunny.net unless a
+// self-test is asked for with --self-test. The access key
+// is taken from BUNNY_ACCESS_KEY rather than a Secret, so the check can run
+// outside the cluster.
+func runCheck(c *bunnySolver, args []string, accessKey string, out io.Writer) error {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(out)
+	fqdn := flags.String("fqdn", "", "challenge record FQDN, e.g. _acme-challenge.www.example.com")
+	zone := flags.String("zone", "", "zone the challenge is solved in, e.g. example.com")
+	config := flags.String("config", "", "Issuer solver config as JSON, applied on top of CONFIG_FILE")
+	selfTest := flags.Bool("self-test", false, "also add, read back and delete a temporary TXT record in the zone")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *fqdn == "" || *zone == "" {
+		return errors.New("--fqdn and --zone are required")
+	}
+	if accessKey == "" {
+		return errors.New("BUNNY_ACCESS_KEY must be set to the bunny.net access key")
+	}
+	ch := &v1alpha1.ChallengeRequest{
+		Action:       v1alpha1.ChallengeActionPresent,
+		ResolvedFQDN: absoluteName(*fqdn),
+		ResolvedZone: absoluteName(*zone),
+		Key:          checkChallengeKey,
+	}
+	if *config != "" {
+		ch.Config = &extapi.JSON{Raw: []byte(*config)}
+	}
+	// Work the names out as Present does, so that the check shows the
+	// record it would write.
+	req, err := c.preparePresent(ch)
+	if err != nil {
+		return err
+	}
+	ch, cfg := req.ch, req.cfg
+	client := c.instrument(c.cliClient(accessKey), ch, cfg)
+	zoneIDs, err := c.resolveZoneIds(context.Background(), client, ch.ResolvedZone, cfg)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(zoneIDs))
+	for i, id := range zoneIDs {
+		ids[i] = fmt.Sprint(id)
+	}
+	fmt.Fprintf(out, "fqdn:        %s\n", ch.ResolvedFQDN)
+	fmt.Fprintf(out, "zone:        %s\n", ch.ResolvedZone)
+	fmt.Fprintf(out, "zone ids:    %s\n", strings.Join(ids, ", "))
+	fmt.Fprintf(out, "record name: %s\n", req.recordName)
+	if !*selfTest {
+		fmt.Fprintln(out, "nothing was written to bunny.net")
+		return nil
+	}
+	name, err := c.selfTest(client, ch.ResolvedZone, cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "self-test:   added, read back and deleted %s\n", name)
+	return nil
+}
+
+// cliClient returns the bunny.net client for the command line modes, which
+// take the access key from the environment.
+func (c *bunnySolver) cliClient(accessKey string) DNSZoneAPI {
+	if c.newClient != nil {
+		return c.newClient(accessKey)
+	}
+	return bunny.NewClient(accessKey, bunny.WithUserAgent(userAgent())).DNSZone
+}
+
+// absoluteName adds the trailing dot cert-manager puts on resolved names.
+func absoluteName(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
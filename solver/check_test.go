@@ -0,0 +1,89 @@
+package solver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunCheck(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	var out strings.Builder
+	err := runCheck(solver, []string{"--fqdn", "_acme-challenge.www.example.com", "--zone", "example.com"}, "test-key", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"fqdn:        _acme-challenge.www.example.com.",
+		"zone:        example.com.",
+		"zone ids:    1",
+		"record name: _acme-challenge.www",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output %q does not contain %q", out.String(), want)
+		}
+	}
+	if api.addCalls != 0 || api.updateCalls != 0 || api.deleteCalls != 0 {
+		t.Errorf("check wrote to the API: %d adds, %d updates, %d deletes", api.addCalls, api.updateCalls, api.deleteCalls)
+	}
+}
+
+func TestRunCheckUsesIssuerConfig(t *testing.T) {
+	solver := newTestSolver(newFakeDNSZoneAPI("example.com"))
+	var out strings.Builder
+	args := []string{"--fqdn", "_acme-challenge.www.example.com.", "--zone", "example.com.",
+		"--config", `{"recordNameStrategy":"fixed","recordName":"_acme-challenge.shared"}`}
+	if err := runCheck(solver, args, "test-key", &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "record name: _acme-challenge.shared") {
+		t.Errorf("output %q does not show the fixed record name", out.String())
+	}
+}
+
+func TestRunCheckErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		accessKey string
+	}{
+		{"missing fqdn", []string{"--zone", "example.com"}, "test-key"},
+		{"missing access key", []string{"--fqdn", "_acme-challenge.example.com", "--zone", "example.com"}, ""},
+		{"fqdn outside zone", []string{"--fqdn", "_acme-challenge.example.org", "--zone", "example.com"}, "test-key"},
+		{"unknown zone", []string{"--fqdn", "_acme-challenge.example.org", "--zone", "example.org"}, "test-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			solver := newTestSolver(newFakeDNSZoneAPI("example.com"))
+			solver.emptyZoneListBackoff = 1
+			if err := runCheck(solver, tt.args, tt.accessKey, &strings.Builder{}); err == nil {
+				t.Error("check succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestRunCheckResolvesLikePresent(t *testing.T) {
+	api := newFakeDNSZoneAPI("xn--bcher-kva.example")
+	solver := newTestSolver(api)
+	solver.strictChallengeKeys = true
+	var out strings.Builder
+	args := []string{"--fqdn", "_acme-challenge.www.bücher.example", "--zone", "bücher.example"}
+	if err := runCheck(solver, args, "test-key", &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"zone:        xn--bcher-kva.example.",
+		"zone ids:    1",
+		"record name: _acme-challenge.www",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output %q does not contain %q", out.String(), want)
+		}
+	}
+	deep := []string{"--fqdn", "_acme-challenge.a.b.example.com", "--zone", "example.com", "--config", `{"maxRecordNameLabels":2}`}
+	solver = newTestSolver(newFakeDNSZoneAPI("example.com"))
+	if err := runCheck(solver, deep, "test-key", &strings.Builder{}); err == nil {
+		t.Error("check accepted a record name Present refuses as too deep")
+	}
+}
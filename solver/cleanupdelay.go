@@ -0,0 +1,35 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+// delayCleanUp waits for the Issuer's cleanUpDelay before CleanUp deletes
+// anything. The wait ends early, with an error, when the webhook stops so
+// that cert-manager retries the clean up later.
+func (c *bunnySolver) delayCleanUp(ch *v1alpha1.ChallengeRequest, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	ctx, cancel := withCancelOn(context.Background(), c.stop)
+	defer cancel()
+	challengeLogger(ch).info("waiting before deleting TXT record", field("delay", delay))
+	if err := sleepContext(ctx, delay); err != nil {
+		return fmt.Errorf("clean up delay interrupted: %w", err)
+	}
+	return nil
+}
+
+// checkCleanUpDelay rejects a cleanUpDelay that does not fit within the
+// operationTimeout, which bounds a whole CleanUp including its delay.
+func checkCleanUpDelay(cfg bunnyConfig) error {
+	delay, timeout := cfg.CleanUpDelay.Duration, cfg.OperationTimeout.Duration
+	if timeout > 0 && delay >= timeout {
+		return fmt.Errorf("cleanUpDelay (%s) must be shorter than operationTimeout (%s)", delay, timeout)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package solver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestCleanUpDelayIsHonored(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	api.zones[0].Records = []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "key")}
+	solver := newTestSolver(api)
+	start := time.Now()
+	if err := solver.CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"cleanUpDelay":"50ms"`)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("CleanUp returned after %s, want at least the 50ms delay", elapsed)
+	}
+	if api.deleteCalls != 1 {
+		t.Errorf("DeleteDNSRecord called %d times, want 1", api.deleteCalls)
+	}
+}
+
+func TestCleanUpDelayIsCancelledOnStop(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	api.zones[0].Records = []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "key")}
+	solver := newTestSolver(api)
+	stop := make(chan struct{})
+	solver.stop = stop
+	time.AfterFunc(10*time.Millisecond, func() { close(stop) })
+	start := time.Now()
+	err := solver.CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"cleanUpDelay":"1h"`))
+	if err == nil {
+		t.Fatal("CleanUp succeeded, want the interrupted delay to fail it")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("CleanUp took %s after stop", elapsed)
+	}
+	if api.deleteCalls != 0 {
+		t.Errorf("DeleteDNSRecord called %d times, want none after stop", api.deleteCalls)
+	}
+}
+
+func TestCleanUpDelayCountsTowardsOperationTimeout(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	api.zones[0].Records = []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "key")}
+	solver := newTestSolver(api)
+	err := solver.CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"cleanUpDelay":"1h","operationTimeout":"2m"`))
+	if err == nil || !strings.Contains(err.Error(), "cleanUpDelay (1h0m0s) must be shorter than operationTimeout (2m0s)") {
+		t.Fatalf("CleanUp() error = %v, want the delay rejected", err)
+	}
+	if api.deleteCalls != 0 {
+		t.Errorf("DeleteDNSRecord called %d times, want none", api.deleteCalls)
+	}
+	start := time.Now()
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"cleanUpDelay":"50ms","operationTimeout":"1s"`)
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("CleanUp returned after %s, want at least the 50ms delay", elapsed)
+	}
+	if api.deleteCalls != 1 {
+		t.Errorf("DeleteDNSRecord called %d times, want 1", api.deleteCalls)
+	}
+}
@@ -0,0 +1,38 @@
+package solver
+
+import (
+	"sync"
+)
+
+// clientCache keeps one bunny.net client per credential Secret. An entry is
+// rebuilt as soon as the Secret's resourceVersion changes, so a rotated
+// access key takes effect on the next operation without a restart.
+type clientCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedClient
+}
+
+type cachedClient struct {
+	resourceVersion string
+	client          DNSZoneAPI
+}
+
+// get returns the cached client for secret at resourceVersion, calling build
+// to create one if there is none or the Secret has changed since.
+func (cc *clientCache) get(secret, resourceVersion string, build func() DNSZoneAPI) DNSZoneAPI {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	entry, ok := cc.entries[secret]
+	if ok && entry.resourceVersion == resourceVersion {
+		return entry.client
+	}
+	if ok {
+		rootLogger.info("credential secret changed, refreshing bunny.net client", field("secret", secret))
+	}
+	if cc.entries == nil {
+		cc.entries = map[string]cachedClient{}
+	}
+	entry = cachedClient{resourceVersion: resourceVersion, client: build()}
+	cc.entries[secret] = entry
+	return entry.client
+}
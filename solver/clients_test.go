@@ -0,0 +1,50 @@
+package solver
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewAPIClientRefreshesOnSecretChange(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	var keys []string
+	solver.newClient = func(accessKey string) DNSZoneAPI {
+		keys = append(keys, accessKey)
+		return api
+	}
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")
+	cfg, err := solver.loadConfig(ch.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := solver.newAPIClient(context.Background(), ch, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(keys) != 1 || keys[0] != "test-key" {
+		t.Fatalf("built clients for keys %v, want one for test-key", keys)
+	}
+
+	secrets := solver.client.CoreV1().Secrets("default")
+	secret, err := secrets.Get(context.Background(), "bunny-credentials", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Data["accessKey"] = []byte("rotated-key")
+	secret.ResourceVersion = "2"
+	if _, err := secrets.Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := solver.newAPIClient(context.Background(), ch, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[1] != "rotated-key" {
+		t.Errorf("built clients for keys %v, want rotated-key to be used after the change", keys)
+	}
+}
@@ -0,0 +1,179 @@
+package solver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	bunny "github.com/simplesurance/bunny-go"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// credentialChain is a bunny.net client that moves on to the Issuer's
+// fallback access keys when its current key cannot be read or is rejected,
+// so a new key can be rolled out before the old one is revoked. Fallback
+// Secrets are only read once they are needed.
+type credentialChain struct {
+	// ctx is the operation's context, which bounds reading the Secrets.
+	ctx  context.Context
+	c    *bunnySolver
+	ch   *v1alpha1.ChallengeRequest
+	cfg  bunnyConfig
+	refs []corev1.SecretKeySelector
+
+	mu     sync.Mutex
+	pos    int
+	secret string
+	api    DNSZoneAPI
+}
+
+// newCredentialChain returns a chain over the Issuer's apiSecretRef and its
+// fallbacks, using the first one that can be read.
+func (c *bunnySolver) newCredentialChain(ctx context.Context, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig) (*credentialChain, error) {
+	refs := append([]corev1.SecretKeySelector{cfg.AccessKeySecretRef}, cfg.FallbackAccessKeySecretRefs...)
+	cc := &credentialChain{ctx: ctx, c: c, ch: ch, cfg: cfg, refs: refs}
+	if err := cc.load(); err != nil {
+		return nil, err
+	}
+	return cc, nil
+}
+
+// load builds the client for the first credential from pos on that can be
+// read. It returns the first error if none can.
+func (cc *credentialChain) load() error {
+	var firstErr error
+	for ; cc.pos < len(cc.refs); cc.pos++ {
+		ref := cc.refs[cc.pos]
+		secret, api, err := cc.c.credentialClient(cc.ctx, ref, cc.ch.ResourceNamespace, cc.cfg)
+		if err == nil {
+			if cc.pos > 0 {
+				challengeLogger(cc.ch).warning("using fallback access key", field("secret", secret))
+			}
+			cc.secret, cc.api = secret, api
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if cc.pos < len(cc.refs)-1 {
+			challengeLogger(cc.ch).warning("failed to read access key, trying the next one",
+				field("secret", cc.ch.ResourceNamespace+"/"+ref.Name+"/"+ref.Key), field("error", err))
+		}
+	}
+	return firstErr
+}
+
+func (cc *credentialChain) current() DNSZoneAPI {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.api
+}
+
+// do runs f with the current client, moving on to the next credential as
+// long as bunny.net rejects the key.
+func (cc *credentialChain) do(f func(DNSZoneAPI) error) error {
+	for {
+		api := cc.current()
+		err := f(api)
+		if !isUnauthorized(err) {
+			return err
+		}
+		cc.mu.Lock()
+		if cc.api != api {
+			// Another call already moved on.
+			cc.mu.Unlock()
+			continue
+		}
+		if cc.pos >= len(cc.refs)-1 {
+			cc.mu.Unlock()
+			return err
+		}
+		challengeLogger(cc.ch).warning("bunny.net rejected access key, trying the next one", field("secret", cc.secret))
+		cc.pos++
+		loadErr := cc.load()
+		cc.mu.Unlock()
+		if loadErr != nil {
+			return err
+		}
+	}
+}
+
+// isUnauthorized reports whether bunny.net rejected the access key or
+// refused it the call.
+func isUnauthorized(err error) bool {
+	d, ok := apiErrorDetails(err)
+	return ok && d.forbidden()
+}
+
+func (cc *credentialChain) Get(ctx context.Context, id int64) (zone *bunny.DNSZone, err error) {
+	err = cc.do(func(api DNSZoneAPI) error {
+		zone, err = api.Get(ctx, id)
+		return err
+	})
+	return zone, err
+}
+
+func (cc *credentialChain) List(ctx context.Context, opts *bunny.PaginationOptions) (zones *bunny.DNSZones, err error) {
+	err = cc.do(func(api DNSZoneAPI) error {
+		zones, err = api.List(ctx, opts)
+		return err
+	})
+	return zones, err
+}
+
+func (cc *credentialChain) AddDNSRecord(ctx context.Context, dnsZoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (record *bunny.DNSRecord, err error) {
+	err = cc.do(func(api DNSZoneAPI) error {
+		record, err = api.AddDNSRecord(ctx, dnsZoneID, opts)
+		return err
+	})
+	return record, err
+}
+
+func (cc *credentialChain) UpdateDNSRecord(ctx context.Context, dnsZoneID int64, dnsRecordID int64, opts *bunny.AddOrUpdateDNSRecordOptions) error {
+	return cc.do(func(api DNSZoneAPI) error {
+		return api.UpdateDNSRecord(ctx, dnsZoneID, dnsRecordID, opts)
+	})
+}
+
+func (cc *credentialChain) DeleteDNSRecord(ctx context.Context, dnsZoneID int64, dnsRecordID int64) error {
+	return cc.do(func(api DNSZoneAPI) error {
+		return api.DeleteDNSRecord(ctx, dnsZoneID, dnsRecordID)
+	})
+}
+
+// credentialTracker records in bunnyCredentialHealth whether bunny.net
+// accepts the access key of secret.
+type credentialTracker struct {
+	api    DNSZoneAPI
+	secret string
+}
+
+func (t credentialTracker) Get(ctx context.Context, id int64) (*bunny.DNSZone, error) {
+	zone, err := t.api.Get(ctx, id)
+	bunnyCredentialHealth.record(t.secret, err)
+	return zone, err
+}
+
+func (t credentialTracker) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	zones, err := t.api.List(ctx, opts)
+	bunnyCredentialHealth.record(t.secret, err)
+	return zones, err
+}
+
+func (t credentialTracker) AddDNSRecord(ctx context.Context, dnsZoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	record, err := t.api.AddDNSRecord(ctx, dnsZoneID, opts)
+	bunnyCredentialHealth.record(t.secret, err)
+	return record, err
+}
+
+func (t credentialTracker) UpdateDNSRecord(ctx context.Context, dnsZoneID int64, dnsRecordID int64, opts *bunny.AddOrUpdateDNSRecordOptions) error {
+	err := t.api.UpdateDNSRecord(ctx, dnsZoneID, dnsRecordID, opts)
+	bunnyCredentialHealth.record(t.secret, err)
+	return err
+}
+
+func (t credentialTracker) DeleteDNSRecord(ctx context.Context, dnsZoneID int64, dnsRecordID int64) error {
+	err := t.api.DeleteDNSRecord(ctx, dnsZoneID, dnsRecordID)
+	bunnyCredentialHealth.record(t.secret, err)
+	return err
+}
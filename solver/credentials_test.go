@@ -0,0 +1,138 @@
+package solver
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	bunny "github.com/simplesurance/bunny-go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// rejectedAPI fails every call the way bunny.net answers a revoked key,
+// or with err if it is set.
+type rejectedAPI struct {
+	calls int
+	err   error
+}
+
+func (r *rejectedAPI) reject() error {
+	r.calls++
+	if r.err != nil {
+		return r.err
+	}
+	return &bunny.AuthenticationError{Message: "Unauthorized"}
+}
+
+func (r *rejectedAPI) Get(context.Context, int64) (*bunny.DNSZone, error) { return nil, r.reject() }
+
+func (r *rejectedAPI) List(context.Context, *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	return nil, r.reject()
+}
+
+func (r *rejectedAPI) AddDNSRecord(context.Context, int64, *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	return nil, r.reject()
+}
+
+func (r *rejectedAPI) UpdateDNSRecord(context.Context, int64, int64, *bunny.AddOrUpdateDNSRecordOptions) error {
+	return r.reject()
+}
+
+func (r *rejectedAPI) DeleteDNSRecord(context.Context, int64, int64) error { return r.reject() }
+
+// newCredentialsSolver returns a solver whose Secrets hold the given access
+// keys, each under "accessKey", and whose clients come from apis by key.
+func newCredentialsSolver(keys map[string]string, apis map[string]DNSZoneAPI) *bunnySolver {
+	c := newTestSolver(nil)
+	var secrets []runtime.Object
+	for name, key := range keys {
+		secrets = append(secrets, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Data:       map[string][]byte{"accessKey": []byte(key)},
+		})
+	}
+	c.client = fake.NewSimpleClientset(secrets...)
+	c.newClient = func(key string) DNSZoneAPI { return apis[key] }
+	return c
+}
+
+const fallbackConfig = `,"fallbackApiSecretRefs":[{"name":"bunny-fallback","key":"accessKey"}]`
+
+func TestPresentPrimaryCredential(t *testing.T) {
+	primary := newFakeDNSZoneAPI("example.com")
+	fallback := newFakeDNSZoneAPI("example.com")
+	c := newCredentialsSolver(
+		map[string]string{"bunny-credentials": "primary-key", "bunny-fallback": "fallback-key"},
+		map[string]DNSZoneAPI{"primary-key": primary, "fallback-key": fallback},
+	)
+
+	if err := c.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", fallbackConfig)); err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+	if got := primary.txtValues(1); len(got) != 1 {
+		t.Errorf("primary records = %v, want one", got)
+	}
+	if fallback.listCalls != 0 || fallback.addCalls != 0 {
+		t.Errorf("fallback used: %d list and %d add calls", fallback.listCalls, fallback.addCalls)
+	}
+}
+
+func TestPresentFallbackCredential(t *testing.T) {
+	tests := []struct {
+		name string
+		keys map[string]string
+	}{
+		{"primary secret missing", map[string]string{"bunny-fallback": "fallback-key"}},
+		{"primary key rejected", map[string]string{"bunny-credentials": "revoked-key", "bunny-fallback": "fallback-key"}},
+		{"primary key refused", map[string]string{"bunny-credentials": "refused-key", "bunny-fallback": "fallback-key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fallback := newFakeDNSZoneAPI("example.com")
+			c := newCredentialsSolver(tt.keys, map[string]DNSZoneAPI{
+				"revoked-key":  &rejectedAPI{},
+				"refused-key":  &rejectedAPI{err: &bunny.HTTPError{StatusCode: http.StatusForbidden}},
+				"fallback-key": fallback,
+			})
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			if err := c.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", fallbackConfig)); err != nil {
+				t.Fatalf("Present() error = %v", err)
+			}
+			if got := fallback.txtValues(1); len(got) != 1 {
+				t.Errorf("fallback records = %v, want one", got)
+			}
+			if !strings.Contains(buf.String(), "default/bunny-fallback/accessKey") {
+				t.Errorf("log does not name the fallback credential:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestPresentAllCredentialsFail(t *testing.T) {
+	revoked := &rejectedAPI{}
+	c := newCredentialsSolver(
+		map[string]string{"bunny-credentials": "revoked-key"},
+		map[string]DNSZoneAPI{"revoked-key": revoked},
+	)
+
+	err := c.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", fallbackConfig))
+	if err == nil {
+		t.Fatal("Present() error = nil, want an error")
+	}
+	if !isUnauthorized(err) {
+		t.Errorf("Present() error = %v, want the primary key's rejection", err)
+	}
+	if revoked.calls == 0 {
+		t.Error("primary credential was not tried")
+	}
+}
@@ -0,0 +1,65 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// withOperationTimeout runs f, the backend part of a Present or CleanUp,
+// under the Issuer's operationTimeout. When the timeout passes f's context
+// is cancelled, so the API calls f still makes fail instead of writing
+// after the operation was given up and its waits end early. It returns
+// once f has, so that the caller's concurrency slot is not released while
+// f is still working.
+func (c *bunnySolver) withOperationTimeout(cfg bunnyConfig, op string, f func(context.Context) error) error {
+	timeout := cfg.OperationTimeout.Duration
+	if timeout <= 0 {
+		return f(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- f(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		<-done
+		return fmt.Errorf("%s did not finish within the operationTimeout of %s: %w", op, timeout, ctx.Err())
+	}
+}
+
+// withCancelOn returns a context derived from ctx that is also cancelled
+// when done is closed.
+func withCancelOn(ctx context.Context, done <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// sleepContext waits for d, or until ctx is done, in which case it returns
+// ctx's error.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isContextError reports whether err comes from a cancelled or expired
+// context rather than from the call itself.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
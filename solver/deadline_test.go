@@ -0,0 +1,99 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// stallingAPI makes every Get take delay, or until its context is done.
+type stallingAPI struct {
+	*lockedAPI
+	delay time.Duration
+}
+
+func (s *stallingAPI) Get(ctx context.Context, id int64) (*bunny.DNSZone, error) {
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return s.lockedAPI.Get(ctx, id)
+}
+
+func TestOperationTimeout(t *testing.T) {
+	for _, op := range []string{"present", "clean up"} {
+		t.Run(op, func(t *testing.T) {
+			fake := newFakeDNSZoneAPI("example.com")
+			fake.zones[0].Records = []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "key")}
+			api := &stallingAPI{lockedAPI: &lockedAPI{fakeDNSZoneAPI: fake}, delay: time.Second}
+			solver := newTestSolver(api)
+			ch := newChallenge("_acme-challenge.example.com.", "example.com.", "other-key", `,"operationTimeout":"50ms"`)
+			solve := solver.Present
+			if op == "clean up" {
+				ch = newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"operationTimeout":"50ms"`)
+				solve = solver.CleanUp
+			}
+			start := time.Now()
+			err := solve(ch)
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("%s took %s, want it to give up after the 50ms operation timeout", op, elapsed)
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got error %v, want a deadline exceeded error", err)
+			}
+			// Nothing is written once the operation was given up.
+			time.Sleep(100 * time.Millisecond)
+			api.mu.Lock()
+			defer api.mu.Unlock()
+			if fake.addCalls != 0 || fake.deleteCalls != 0 {
+				t.Errorf("%d adds and %d deletes after the timeout, want none", fake.addCalls, fake.deleteCalls)
+			}
+		})
+	}
+}
+
+func TestOperationTimeoutNotReached(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"operationTimeout":"10s"`)); err != nil {
+		t.Fatal(err)
+	}
+	if api.addCalls != 1 {
+		t.Errorf("AddDNSRecord called %d times, want 1", api.addCalls)
+	}
+}
+
+func TestOperationTimeoutWaitsForBackend(t *testing.T) {
+	var finished bool
+	err := (&bunnySolver{}).withOperationTimeout(bunnyConfig{OperationTimeout: duration{20 * time.Millisecond}}, "present", func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		finished = true
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want a deadline exceeded error", err)
+	}
+	if !finished {
+		t.Error("returned before the backend work did, releasing its concurrency slot early")
+	}
+}
+
+func TestOperationTimeoutEndsRecordWait(t *testing.T) {
+	api := &laggingAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), hiddenGets: 1000, hidden: map[int64]int{}}
+	solver := newTestSolver(api)
+	solver.recordPollInterval = 5 * time.Second
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key",
+		`,"waitForRecord":true,"recordVisibilityTimeout":"1h","operationTimeout":"50ms"`)
+	start := time.Now()
+	if err := solver.Present(ch); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want a deadline exceeded error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Present took %s, want the record wait to end with the 50ms operation timeout", elapsed)
+	}
+}
@@ -0,0 +1,91 @@
+package solver
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+const delegationLookupTimeout = 10 * time.Second
+
+// nsLookupFunc returns the nameservers a domain is delegated to.
+type nsLookupFunc func(ctx context.Context, name string) ([]string, error)
+
+// lookupNS asks the system resolver for the NS records of name.
+func lookupNS(ctx context.Context, name string) ([]string, error) {
+	records, err := net.DefaultResolver.LookupNS(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, ns := range records {
+		hosts = append(hosts, ns.Host)
+	}
+	return hosts, nil
+}
+
+// checkDelegation warns when the challenge's zone is not delegated to the
+// nameservers that serve it at bunny.net, usually because the registrar
+// still points elsewhere. The record is written regardless, but the CA will
+// not see it, so this only logs.
+func (c *bunnySolver) checkDelegation(ctx context.Context, client DNSZoneAPI, logger fieldLogger, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, zoneID int64) {
+	expected := cfg.ExpectedNameservers
+	if len(expected) == 0 {
+		zone, err := client.Get(ctx, zoneID)
+		if err != nil {
+			logger.warning("could not check the zone's delegation", field("error", err))
+			return
+		}
+		expected = zoneNameservers(zone)
+	}
+	lookup := c.lookupNS
+	if lookup == nil {
+		lookup = lookupNS
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, delegationLookupTimeout)
+	defer cancel()
+	delegated, err := lookup(lookupCtx, ch.ResolvedZone)
+	if err != nil {
+		logger.warning("could not check the zone's delegation", field("error", err))
+		return
+	}
+	if !sameNameservers(delegated, expected) {
+		logger.warning("zone is not delegated to its bunny.net nameservers, the challenge will likely fail until the registrar is updated",
+			field("delegated", strings.Join(normalizeNameservers(delegated), ",")),
+			field("expected", strings.Join(normalizeNameservers(expected), ",")))
+	}
+}
+
+// sameNameservers reports whether a and b name the same set of hosts.
+func sameNameservers(a, b []string) bool {
+	na, nb := normalizeNameservers(a), normalizeNameservers(b)
+	if len(na) != len(nb) {
+		return false
+	}
+	for i := range na {
+		if na[i] != nb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeNameservers returns the distinct host names, lower case and
+// without trailing dot, sorted.
+func normalizeNameservers(hosts []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, h := range hosts {
+		h = strings.ToLower(strings.TrimSuffix(h, "."))
+		if h != "" && !seen[h] {
+			seen[h] = true
+			out = append(out, h)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,91 @@
+package solver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresentChecksDelegation(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		delegated []string
+		lookupErr error
+		wantWarn  string
+	}{
+		{"delegated to bunny.net", `,"checkDelegation":true`, []string{"KIKI.bunny.net.", "coco.bunny.net."}, nil, ""},
+		{"still delegated elsewhere", `,"checkDelegation":true`, []string{"ns1.registrar.example.", "ns2.registrar.example."}, nil,
+			"zone is not delegated to its bunny.net nameservers"},
+		{"expected nameservers given", `,"expectedNameservers":["ns1.registrar.example"]`, []string{"ns1.registrar.example."}, nil, ""},
+		{"lookup fails", `,"checkDelegation":true`, nil, errors.New("no such host"), "could not check the zone's delegation"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			kiki, coco := "kiki.bunny.net", "coco.bunny.net"
+			api.zones[0].Nameserver1, api.zones[0].Nameserver2 = &kiki, &coco
+			solver := newTestSolver(api)
+			var looked string
+			solver.lookupNS = func(ctx context.Context, name string) ([]string, error) {
+				looked = name
+				return tt.delegated, tt.lookupErr
+			}
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config)); err != nil {
+				t.Fatalf("Present() error = %v, the check must only warn", err)
+			}
+			if looked != "example.com." {
+				t.Errorf("looked up NS of %q, want the zone", looked)
+			}
+			if got := api.txtValues(1); len(got) != 1 {
+				t.Errorf("TXT values %v, want the record written regardless", got)
+			}
+			logged := buf.String()
+			if tt.wantWarn == "" && strings.Contains(logged, "warning:") {
+				t.Errorf("unexpected warning:\n%s", logged)
+			}
+			if tt.wantWarn != "" && !strings.Contains(logged, tt.wantWarn) {
+				t.Errorf("log does not warn %q:\n%s", tt.wantWarn, logged)
+			}
+		})
+	}
+}
+
+func TestPresentSkipsDelegationCheckByDefault(t *testing.T) {
+	solver := newTestSolver(newFakeDNSZoneAPI("example.com"))
+	solver.lookupNS = func(ctx context.Context, name string) ([]string, error) {
+		t.Error("delegation looked up without checkDelegation")
+		return nil, nil
+	}
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDelegationLookupBoundToOperation(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	var remaining time.Duration
+	solver.lookupNS = func(ctx context.Context, name string) ([]string, error) {
+		if deadline, ok := ctx.Deadline(); ok {
+			remaining = time.Until(deadline)
+		}
+		return nil, nil
+	}
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"checkDelegation":true,"operationTimeout":"2s"`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if remaining <= 0 || remaining > 2*time.Second {
+		t.Errorf("NS lookup had %v left, want at most the 2s operation timeout", remaining)
+	}
+}
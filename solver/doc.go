@@ -0,0 +1,29 @@
+// Package solver implements a cert-manager ACME DNS-01 webhook solver that
+// publishes challenge records in bunny.net DNS zones.
+//
+// The webhook binary is a thin wrapper around Main. Other programs can
+// embed the solver by building it with New and passing it to cert-manager's
+// cmd.RunWebhookServer, or by calling Present and CleanUp directly:
+//
+//	settings := solver.DefaultSettings()
+//	settings.GroupName = "acme.example.com"
+//	s, err := solver.New(settings, solver.Options{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	cmd.RunWebhookServer(settings.GroupName, s)
+//
+// A Solver keeps its caches, clients, concurrency limits and circuit
+// breaker to itself, so it can be served next to solvers for other DNS
+// providers, or next to other bunny.net Solvers with their own
+// Options.Name, by passing them all to one cmd.RunWebhookServer call.
+// Some state is process-wide and shared by every Solver, though: the
+// logger and its levels, the Prometheus metrics including the
+// circuit_breaker_state gauge, the bunny.net API and access key health
+// reported by the status endpoint, and the tuning of the bunny.net HTTP
+// transport. Two Solvers in one process therefore report each other's
+// health and breaker state. Logging, metrics and the transport are only
+// set up by Main.
+//
+// The per-Issuer solver config is documented in the README.
+package solver
@@ -0,0 +1,160 @@
+//go:build e2e
+
+package solver
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/test/acme/dns"
+	miekgdns "github.com/miekg/dns"
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+const (
+	e2eZone      = "example.com"
+	e2eAccessKey = "e2e-access-key"
+)
+
+// fakeBunnyServer implements the bunny.net DNS zone HTTP endpoints the
+// solver uses, backed by a single in-memory zone.
+type fakeBunnyServer struct {
+	mu      sync.Mutex
+	records []bunny.DNSRecord
+	nextID  int64
+}
+
+func (s *fakeBunnyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(bunny.AccessKeyHeaderKey) != e2eAccessKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zoneID, domain := int64(1), e2eZone
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 1 && parts[0] == "dnszone":
+		page, total, hasMore := int32(1), int32(1), false
+		writeJSON(w, bunny.DNSZones{
+			Items:        []*bunny.DNSZone{{ID: &zoneID, Domain: &domain}},
+			CurrentPage:  &page,
+			TotalItems:   &total,
+			HasMoreItems: &hasMore,
+		})
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "1":
+		writeJSON(w, bunny.DNSZone{ID: &zoneID, Domain: &domain, Records: s.records})
+	case r.Method == http.MethodPut && len(parts) == 3 && parts[2] == "records":
+		var opts bunny.AddOrUpdateDNSRecordOptions
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.nextID++
+		id := s.nextID
+		record := bunny.DNSRecord{ID: &id, Type: opts.Type, TTL: opts.TTL, Name: opts.Name, Value: opts.Value, Tag: opts.Tag}
+		s.records = append(s.records, record)
+		writeJSON(w, record)
+	case r.Method == http.MethodDelete && len(parts) == 4 && parts[2] == "records":
+		id, _ := strconv.ParseInt(parts[3], 10, 64)
+		for i, record := range s.records {
+			if *record.ID == id {
+				s.records = append(s.records[:i], s.records[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// ServeDNS answers TXT queries from the zone's current records, so the
+// conformance suite can observe what the solver wrote.
+func (s *fakeBunnyServer) ServeDNS(w miekgdns.ResponseWriter, req *miekgdns.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := new(miekgdns.Msg)
+	m.SetReply(req)
+	m.Rcode = miekgdns.RcodeNameError
+	for _, q := range req.Question {
+		name := normalizeRecordName(q.Name, e2eZone)
+		for _, record := range s.records {
+			if *record.Type != txtRecordType || normalizeRecordName(*record.Name, e2eZone) != name {
+				continue
+			}
+			m.Rcode = miekgdns.RcodeSuccess
+			m.Answer = append(m.Answer, &miekgdns.TXT{
+				Hdr: miekgdns.RR_Header{Name: q.Name, Rrtype: miekgdns.TypeTXT, Class: miekgdns.ClassINET, Ttl: uint32(*record.TTL)},
+				Txt: []string{*record.Value},
+			})
+		}
+	}
+	w.WriteMsg(m)
+}
+
+// redirectTransport sends every request to target instead of its original
+// host. bunny-go offers no way to change its base URL.
+type redirectTransport struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return t.next.RoundTrip(req)
+}
+
+func TestEndToEnd(t *testing.T) {
+	fake := &fakeBunnyServer{nextID: 100}
+
+	api := httptest.NewServer(fake)
+	defer api.Close()
+	target, _ := url.Parse(api.URL)
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = &redirectTransport{target: target, next: defaultTransport}
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsServer := &miekgdns.Server{PacketConn: conn, Handler: fake}
+	go dnsServer.ActivateAndServe()
+	defer dnsServer.Shutdown()
+
+	fixture := dns.NewFixture(&bunnySolver{},
+		dns.SetResolvedZone(e2eZone+"."),
+		dns.SetManifestPath("testdata/e2e"),
+		dns.SetDNSServer(conn.LocalAddr().String()),
+		dns.SetUseAuthoritative(false),
+		dns.SetStrict(true),
+		dns.SetPollInterval(100*time.Millisecond),
+		dns.SetPropagationLimit(10*time.Second),
+	)
+	fixture.RunConformance(t)
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.records) != 0 {
+		t.Errorf("%d records left in the zone after the conformance run", len(fake.records))
+	}
+}
@@ -0,0 +1,86 @@
+package solver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// maxDisplayErrorLength keeps errors short enough for cert-manager to show
+// them in full in the Challenge status.
+const maxDisplayErrorLength = 300
+
+// displayedError is an error shortened for display in cert-manager. It
+// unwraps to the full error.
+type displayedError struct {
+	msg string
+	err error
+}
+
+func (e *displayedError) Error() string {
+	return e.msg
+}
+
+func (e *displayedError) Unwrap() error {
+	return e.err
+}
+
+// displayError logs err in full and returns it in a form suited for the
+// Challenge status, which cert-manager truncates: bunny-go's error dumps,
+// which lead with the request URL, are replaced by the API's message and
+// status code, and rejected credentials are named first.
+func displayError(logger fieldLogger, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	logger.error(op+" failed", append([]logField{field("error", err)}, apiErrorFields(err)...)...)
+	msg := err.Error()
+	var authErr *bunny.AuthenticationError
+	var apiErr *bunny.APIError
+	var httpErr *bunny.HTTPError
+	switch {
+	case errors.As(err, &authErr):
+		msg = "bunny.net rejected the access key, check the apiSecretRef Secret: " + strings.Replace(msg, authErr.Error(), "unauthorized", 1)
+	case errors.As(err, &apiErr):
+		msg = strings.Replace(msg, apiErr.Error(), conciseAPIError(apiErr), 1)
+	case errors.As(err, &httpErr):
+		msg = strings.Replace(msg, httpErr.Error(), conciseHTTPError(httpErr), 1)
+	}
+	if len(msg) > maxDisplayErrorLength {
+		// Cut on a rune boundary, as IDN zone names and bunny.net's
+		// messages need not be ASCII.
+		cut := maxDisplayErrorLength - 3
+		for cut > 0 && !utf8.RuneStart(msg[cut]) {
+			cut--
+		}
+		msg = msg[:cut] + "..."
+	}
+	return &displayedError{msg: msg, err: err}
+}
+
+func conciseAPIError(e *bunny.APIError) string {
+	detail := e.Message
+	if detail == "" {
+		detail = e.ErrorKey
+	}
+	if e.Field != "" {
+		detail += " (field " + e.Field + ")"
+	}
+	if detail == "" {
+		return conciseHTTPError(&e.HTTPError)
+	}
+	return fmt.Sprintf("bunny.net API error %d: %s", e.StatusCode, detail)
+}
+
+// conciseHTTPError also reads the detail of error bodies bunny-go left
+// unparsed, see parseAPIError.
+func conciseHTTPError(e *bunny.HTTPError) string {
+	if d, _ := parseAPIError(e); d.hasBody() {
+		return conciseAPIError(&bunny.APIError{HTTPError: *e, ErrorKey: d.Key, Field: d.Field, Message: d.Message})
+	}
+	return fmt.Sprintf("bunny.net API error %d: %s", e.StatusCode, strings.ToLower(http.StatusText(e.StatusCode)))
+}
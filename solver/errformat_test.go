@@ -0,0 +1,66 @@
+package solver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestDisplayError(t *testing.T) {
+	url := "https://api.bunny.net/dnszone/1/records"
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"plain", errors.New("challenge FQDN is not within zone"), "challenge FQDN is not within zone"},
+		{"http error", fmt.Errorf("failed to add TXT record: %w", &bunny.HTTPError{RequestURL: url, StatusCode: 502, RespBody: []byte("<html>")}),
+			"failed to add TXT record: bunny.net API error 502: bad gateway"},
+		{"api error", fmt.Errorf("failed to add TXT record: %w", &bunny.APIError{
+			HTTPError: bunny.HTTPError{RequestURL: url, StatusCode: 400},
+			ErrorKey:  "dnszone.record.invalid", Field: "Value", Message: "The value is invalid.",
+		}), "failed to add TXT record: bunny.net API error 400: The value is invalid. (field Value)"},
+		{"api error without message", &bunny.APIError{HTTPError: bunny.HTTPError{RequestURL: url, StatusCode: 409}},
+			"bunny.net API error 409: conflict"},
+		{"authentication", fmt.Errorf("error getting zone records: %w", &bunny.AuthenticationError{Message: "Unauthorized"}),
+			"bunny.net rejected the access key, check the apiSecretRef Secret: error getting zone records: unauthorized"},
+		{"maintenance", &maintenanceError{&bunny.HTTPError{RequestURL: url, StatusCode: 503}},
+			"bunny.net API is in maintenance or temporarily unavailable (HTTP 503), try again later: bunny.net API error 503: service unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := displayError(rootLogger, "present", tt.err)
+			if err.Error() != tt.want {
+				t.Errorf("got %q, want %q", err, tt.want)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Error("displayed error does not unwrap to the original")
+			}
+		})
+	}
+}
+
+func TestDisplayErrorTruncates(t *testing.T) {
+	err := displayError(rootLogger, "present", errors.New(strings.Repeat("x", 1000)))
+	if n := len(err.Error()); n != maxDisplayErrorLength {
+		t.Errorf("got %d characters, want %d", n, maxDisplayErrorLength)
+	}
+	if displayError(rootLogger, "present", nil) != nil {
+		t.Error("nil error was not kept nil")
+	}
+}
+
+func TestDisplayErrorTruncatesOnRuneBoundary(t *testing.T) {
+	// Only two-byte runes put the odd byte limit inside one.
+	msg := displayError(rootLogger, "present", errors.New(strings.Repeat("ü", 500))).Error()
+	if !utf8.ValidString(msg) {
+		t.Errorf("truncated error %q is not valid UTF-8", msg)
+	}
+	if len(msg) > maxDisplayErrorLength || !strings.HasSuffix(msg, "...") {
+		t.Errorf("got %d bytes ending in %q, want at most %d ending in ...", len(msg), msg[len(msg)-3:], maxDisplayErrorLength)
+	}
+}
@@ -0,0 +1,121 @@
+package solver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"strings"
+)
+
+// exportFlag runs the webhook as a read-only inventory of challenge records
+// instead of serving.
+const exportFlag = "--export-records"
+
+// exportedRecord is one challenge TXT record in the --export-records
+// output.
+type exportedRecord struct {
+	Zone    string `json:"zone"`
+	ZoneID  int64  `json:"zoneID"`
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	TTL     int32  `json:"ttl"`
+	Managed bool   `json:"managed"`
+}
+
+// runExport writes every TXT record named _acme-challenge or below it, in
+// one zone or in all zones of the account, to out as a JSON array. Names
+// are given relative to the zone, as normalizeRecordName derives them. Like
+// --check it takes the access key from BUNNY_ACCESS_KEY and writes nothing
+// to bunny.net.
+func runExport(c *bunnySolver, args []string, accessKey string, out io.Writer) error {
+	flags := flag.NewFlagSet("export-records", flag.ContinueOnError)
+	flags.SetOutput(out)
+	zone := flags.String("zone", "", "only export records of this zone, e.g. example.com")
+	managedOnly := flags.Bool("managed-only", false, "only export records carrying the webhook's marker")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if accessKey == "" {
+		return errors.New("BUNNY_ACCESS_KEY must be set to the bunny.net access key")
+	}
+	client := c.instrument(c.cliClient(accessKey), nil, bunnyConfig{})
+	zones, err := c.exportZones(client, *zone)
+	if err != nil {
+		return err
+	}
+	records := []exportedRecord{}
+	for _, z := range zones {
+		zoneRecords, err := c.zoneRecords(client, z.id)
+		if err != nil {
+			return err
+		}
+		for _, r := range zoneRecords {
+			if r.ID == nil || r.Type == nil || *r.Type != txtRecordType || r.Name == nil {
+				continue
+			}
+			name := normalizeRecordName(*r.Name, z.domain)
+			if !isChallengeName(name) {
+				continue
+			}
+			if *managedOnly && !isManagedRecord(r) {
+				continue
+			}
+			record := exportedRecord{Zone: z.domain, ZoneID: z.id, ID: *r.ID, Name: name, Managed: isManagedRecord(r)}
+			if r.Value != nil {
+				record.Value = *r.Value
+			}
+			if r.TTL != nil {
+				record.TTL = *r.TTL
+			}
+			records = append(records, record)
+		}
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+type exportZone struct {
+	id     int64
+	domain string
+}
+
+// exportZones returns the zone named zone, or every zone of the account if
+// zone is empty.
+func (c *bunnySolver) exportZones(client DNSZoneAPI, zone string) ([]exportZone, error) {
+	if zone != "" {
+		ids, err := c.listZoneIds(context.Background(), client, absoluteName(zone), bunnyConfig{AllMatchingZones: true})
+		if err != nil {
+			return nil, err
+		}
+		var zones []exportZone
+		for _, id := range ids {
+			zones = append(zones, exportZone{id: id, domain: strings.TrimSuffix(zone, ".")})
+		}
+		return zones, nil
+	}
+	var zones []exportZone
+	it := listAllZones(client, c.zoneScanStartPage)
+	for {
+		page, ok, err := it.next(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return zones, nil
+		}
+		for _, z := range page {
+			zones = append(zones, exportZone{id: *z.ID, domain: *z.Domain})
+		}
+	}
+}
+
+// isChallengeName reports whether a record name relative to its zone is an
+// ACME challenge name.
+func isChallengeName(name string) bool {
+	name = strings.ToLower(name)
+	return name == "_acme-challenge" || strings.HasPrefix(name, "_acme-challenge.")
+}
@@ -0,0 +1,88 @@
+package solver
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func newExportAccount() *fakeDNSZoneAPI {
+	api := newFakeDNSZoneAPI("example.com", "example.org")
+	aType, aValue, aName := 0, "192.0.2.1", "_acme-challenge"
+	api.zones[0].Records = []bunny.DNSRecord{
+		txtRecord(10, "_acme-challenge", "key"),
+		unmanagedTXTRecord(11, "_acme-challenge.www", "manual"),
+		txtRecord(12, "www", "not-a-challenge"),
+		{ID: int64Ptr(13), Type: &aType, Name: &aName, Value: &aValue},
+	}
+	api.zones[0].Records[0].TTL = int32Ptr(300)
+	api.zones[1].Records = []bunny.DNSRecord{txtRecord(20, "_acme-challenge", "other-key")}
+	return api
+}
+
+func int64Ptr(n int64) *int64 { return &n }
+
+func exportRecords(t *testing.T, api DNSZoneAPI, args ...string) []exportedRecord {
+	t.Helper()
+	var out strings.Builder
+	if err := runExport(newTestSolver(api), args, "test-key", &out); err != nil {
+		t.Fatal(err)
+	}
+	var records []exportedRecord
+	if err := json.Unmarshal([]byte(out.String()), &records); err != nil {
+		t.Fatalf("output %q is not a JSON array of records: %v", out.String(), err)
+	}
+	return records
+}
+
+func TestRunExport(t *testing.T) {
+	api := newExportAccount()
+	records := exportRecords(t, api)
+	want := []exportedRecord{
+		{Zone: "example.com", ZoneID: 1, ID: 10, Name: "_acme-challenge", Value: "key", TTL: 300, Managed: true},
+		{Zone: "example.com", ZoneID: 1, ID: 11, Name: "_acme-challenge.www", Value: "manual"},
+		{Zone: "example.org", ZoneID: 2, ID: 20, Name: "_acme-challenge", Value: "other-key", Managed: true},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got records %+v, want %+v", records, want)
+	}
+	for i := range want {
+		if records[i] != want[i] {
+			t.Errorf("record %d is %+v, want %+v", i, records[i], want[i])
+		}
+	}
+	if api.addCalls != 0 || api.updateCalls != 0 || api.deleteCalls != 0 {
+		t.Errorf("export wrote to the API")
+	}
+}
+
+func TestRunExportFilters(t *testing.T) {
+	records := exportRecords(t, newExportAccount(), "--zone", "example.com", "--managed-only")
+	if len(records) != 1 || records[0].ID != 10 {
+		t.Errorf("got records %+v, want only the managed record of example.com", records)
+	}
+}
+
+func TestRunExportEmpty(t *testing.T) {
+	var out strings.Builder
+	if err := runExport(newTestSolver(newFakeDNSZoneAPI("example.com")), nil, "test-key", &out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(out.String()) != "[]" {
+		t.Errorf("got output %q, want an empty JSON array", out.String())
+	}
+}
+
+func TestRunExportNormalizesNames(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	api.zones[0].Records = []bunny.DNSRecord{
+		txtRecord(10, "_ACME-Challenge.WWW", "key"),
+		txtRecord(11, "_acme-challenge.api.example.com.", "other-key"),
+	}
+	records := exportRecords(t, api)
+	if len(records) != 2 || records[0].Name != "_acme-challenge.www" || records[1].Name != "_acme-challenge.api" {
+		t.Errorf("got records %+v, want names relative to the zone in lower case", records)
+	}
+}
@@ -0,0 +1,122 @@
+package solver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// apiHealth remembers the outcome of the most recent bunny.net API calls so
+// that operators can see whether the webhook can currently reach bunny.net
+// without triggering a call themselves.
+type apiHealth struct {
+	mu              sync.Mutex
+	lastSuccess     time.Time
+	lastError       string
+	lastErrorTime   time.Time
+	lastErrorOpName string
+}
+
+var bunnyAPIHealth apiHealth
+
+// record updates the health with the result of the API operation op.
+func (h *apiHealth) record(op string, err error) {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		h.lastSuccess = now
+		lastSuccessfulAPICall.Set(float64(now.Unix()))
+		return
+	}
+	h.lastError = err.Error()
+	h.lastErrorTime = now
+	h.lastErrorOpName = op
+}
+
+// apiHealthStatus is the JSON served by the status endpoint. Times are
+// omitted until the first call of their kind.
+type apiHealthStatus struct {
+	LastSuccess        *time.Time `json:"lastSuccess,omitempty"`
+	LastError          string     `json:"lastError,omitempty"`
+	LastErrorTime      *time.Time `json:"lastErrorTime,omitempty"`
+	LastErrorOperation string     `json:"lastErrorOperation,omitempty"`
+}
+
+func (h *apiHealth) status() apiHealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := apiHealthStatus{LastError: h.lastError, LastErrorOperation: h.lastErrorOpName}
+	if !h.lastSuccess.IsZero() {
+		t := h.lastSuccess
+		s.LastSuccess = &t
+	}
+	if !h.lastErrorTime.IsZero() {
+		t := h.lastErrorTime
+		s.LastErrorTime = &t
+	}
+	return s
+}
+
+func (h *apiHealth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.status())
+}
+
+// credentialHealth remembers, per credential Secret, whether bunny.net last
+// accepted or rejected its access key. Configs only arrive with challenges,
+// so this is how operators running many Issuers find the one with a bad
+// key without issuing a certificate for each.
+type credentialHealth struct {
+	mu      sync.Mutex
+	entries map[string]credentialStatus
+}
+
+// credentialStatus is the JSON served for one credential Secret.
+type credentialStatus struct {
+	Valid   bool      `json:"valid"`
+	Checked time.Time `json:"checked"`
+	Error   string    `json:"error,omitempty"`
+}
+
+var bunnyCredentialHealth credentialHealth
+
+// record updates the status of secret, namespace/name/key, with the result
+// of an API call made with its access key. Errors other than a rejected key
+// say nothing about the key and are ignored.
+func (h *credentialHealth) record(secret string, err error) {
+	status := credentialStatus{Valid: err == nil, Checked: time.Now()}
+	if err != nil {
+		if !isUnauthorized(err) {
+			return
+		}
+		status.Error = err.Error()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.entries == nil {
+		h.entries = map[string]credentialStatus{}
+	}
+	h.entries[secret] = status
+	valid := 0.0
+	if status.Valid {
+		valid = 1
+	}
+	credentialValid.WithLabelValues(secret).Set(valid)
+}
+
+func (h *credentialHealth) status() map[string]credentialStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := make(map[string]credentialStatus, len(h.entries))
+	for secret, status := range h.entries {
+		s[secret] = status
+	}
+	return s
+}
+
+func (h *credentialHealth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.status())
+}
@@ -0,0 +1,88 @@
+package solver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestAPIHealth(t *testing.T) {
+	var h apiHealth
+	if s := h.status(); s.LastSuccess != nil || s.LastErrorTime != nil {
+		t.Fatalf("got %+v before any call, want an empty status", s)
+	}
+
+	h.record("list", nil)
+	h.record("add", errors.New("bad gateway"))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/status/bunny", nil))
+	var s apiHealthStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatalf("invalid status %q: %v", rec.Body.String(), err)
+	}
+	if s.LastSuccess == nil || time.Since(*s.LastSuccess) > time.Minute {
+		t.Errorf("got last success %v, want a recent time", s.LastSuccess)
+	}
+	if s.LastError != "bad gateway" || s.LastErrorOperation != "add" || s.LastErrorTime == nil {
+		t.Errorf("got status %+v, want the add error", s)
+	}
+}
+
+func TestPresentRecordsAPIHealth(t *testing.T) {
+	before := time.Now()
+	api := newFakeDNSZoneAPI("example.com")
+	if err := newTestSolver(api).Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	if s := bunnyAPIHealth.status(); s.LastSuccess == nil || s.LastSuccess.Before(before) {
+		t.Errorf("got last success %v, want a time after %v", s.LastSuccess, before)
+	}
+}
+
+func TestCredentialHealth(t *testing.T) {
+	var h credentialHealth
+	h.record("default/good/accessKey", nil)
+	h.record("default/bad/accessKey", &bunny.AuthenticationError{Message: "Unauthorized"})
+	h.record("default/flaky/accessKey", errors.New("bad gateway"))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/status/credentials", nil))
+	var s map[string]credentialStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatalf("invalid status %q: %v", rec.Body.String(), err)
+	}
+	if len(s) != 2 {
+		t.Errorf("got %d credentials, want the good and the bad one: %+v", len(s), s)
+	}
+	if !s["default/good/accessKey"].Valid {
+		t.Errorf("good credential reported as %+v", s["default/good/accessKey"])
+	}
+	if bad := s["default/bad/accessKey"]; bad.Valid || bad.Error != "Unauthorized" {
+		t.Errorf("bad credential reported as %+v", bad)
+	}
+	if got := testutil.ToFloat64(credentialValid.WithLabelValues("default/bad/accessKey")); got != 0 {
+		t.Errorf("credential_valid for the bad credential = %v, want 0", got)
+	}
+}
+
+func TestPresentRecordsCredentialHealth(t *testing.T) {
+	c := newCredentialsSolver(
+		map[string]string{"bunny-credentials": "revoked-key"},
+		map[string]DNSZoneAPI{"revoked-key": &rejectedAPI{}},
+	)
+	if err := c.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err == nil {
+		t.Fatal("Present() error = nil with a rejected key")
+	}
+	if s := bunnyCredentialHealth.status()["default/bunny-credentials/accessKey"]; s.Valid || s.Checked.IsZero() {
+		t.Errorf("got credential status %+v, want the rejection", s)
+	}
+	if got := testutil.ToFloat64(credentialValid.WithLabelValues("default/bunny-credentials/accessKey")); got != 0 {
+		t.Errorf("credential_valid = %v, want 0", got)
+	}
+}
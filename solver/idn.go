@@ -0,0 +1,53 @@
+package solver
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"golang.org/x/net/idna"
+)
+
+// toASCIIName converts the internationalized labels of a domain name to
+// their punycode (xn--) form, which is how bunny.net and DNS know them.
+// cert-manager may pass either form depending on how the certificate was
+// written. ASCII labels are kept as they are, since the IDNA rules reject
+// the underscore of _acme-challenge, and so are labels that fail to
+// convert; validateChallengeRequest reports those.
+func toASCIIName(name string) string {
+	if isASCII(name) {
+		return name
+	}
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		if ascii, err := idna.Lookup.ToASCII(label); err == nil {
+			labels[i] = ascii
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// withASCIINames returns ch with its resolved FQDN and zone in punycode
+// form, so that zone lookup and record names work the same for either
+// form. ch itself is not modified.
+func withASCIINames(ch *v1alpha1.ChallengeRequest) *v1alpha1.ChallengeRequest {
+	fqdn, zone := toASCIIName(ch.ResolvedFQDN), toASCIIName(ch.ResolvedZone)
+	if fqdn == ch.ResolvedFQDN && zone == ch.ResolvedZone {
+		return ch
+	}
+	converted := *ch
+	converted.ResolvedFQDN, converted.ResolvedZone = fqdn, zone
+	return &converted
+}
@@ -0,0 +1,65 @@
+package solver
+
+import (
+	"testing"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestToASCIIName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"bücher.example.", "xn--bcher-kva.example."},
+		{"_acme-challenge.münchen.bücher.example.", "_acme-challenge.xn--mnchen-3ya.xn--bcher-kva.example."},
+		{"xn--bcher-kva.example.", "xn--bcher-kva.example."},
+		{"_acme-challenge.Example.com.", "_acme-challenge.Example.com."},
+	}
+	for _, tt := range tests {
+		if got := toASCIIName(tt.name); got != tt.want {
+			t.Errorf("toASCIIName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPresentAndCleanUpIDN(t *testing.T) {
+	tests := []struct {
+		name       string
+		stored     string
+		fqdn, zone string
+		wantRecord string
+	}{
+		{"unicode challenge, punycode zone", "xn--bcher-kva.example", "_acme-challenge.bücher.example.", "bücher.example.", "_acme-challenge"},
+		{"punycode challenge, unicode zone", "bücher.example", "_acme-challenge.xn--bcher-kva.example.", "xn--bcher-kva.example.", "_acme-challenge"},
+		{"unicode subdomain", "xn--bcher-kva.example", "_acme-challenge.münchen.bücher.example.", "xn--bcher-kva.example.", "_acme-challenge.xn--mnchen-3ya"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI(tt.stored)
+			solver := newTestSolver(api)
+			if err := solver.Present(newChallenge(tt.fqdn, tt.zone, "key", "")); err != nil {
+				t.Fatalf("Present() error = %v", err)
+			}
+			records := api.zones[0].Records
+			if len(records) != 1 || *records[0].Name != tt.wantRecord {
+				t.Fatalf("records %v, want one named %s", recordNames(records), tt.wantRecord)
+			}
+			// cert-manager may send the other form when cleaning up.
+			fqdn, zone := toASCIIName(tt.fqdn), toASCIIName(tt.zone)
+			if err := solver.CleanUp(newChallenge(fqdn, zone, "key", "")); err != nil {
+				t.Fatalf("CleanUp() error = %v", err)
+			}
+			if got := api.txtValues(1); len(got) != 0 {
+				t.Errorf("TXT values %v left after clean up", got)
+			}
+		})
+	}
+}
+
+func recordNames(records []bunny.DNSRecord) []string {
+	var names []string
+	for _, r := range records {
+		names = append(names, *r.Name)
+	}
+	return names
+}
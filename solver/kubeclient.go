@@ -0,0 +1,67 @@
+package solver
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/rest"
+)
+
+// readCABundle reads a PEM file of CA certificates to trust for the
+// Kubernetes API on top of the ones in the rest config, and checks that it
+// holds at least one parseable certificate.
+func readCABundle(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	certs := 0
+	for rest := b; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		certs++
+	}
+	if certs == 0 {
+		return nil, fmt.Errorf("%s: no PEM certificates found", path)
+	}
+	return b, nil
+}
+
+// withCABundle returns a copy of cfg that trusts the CAs in bundle as well
+// as the ones cfg already trusts, such as the in-cluster service account
+// CA. cfg is returned unchanged when bundle is empty.
+func withCABundle(cfg *rest.Config, bundle []byte) (*rest.Config, error) {
+	if len(bundle) == 0 {
+		return cfg, nil
+	}
+	cfg = rest.CopyConfig(cfg)
+	existing := cfg.TLSClientConfig.CAData
+	if len(existing) == 0 && cfg.TLSClientConfig.CAFile != "" {
+		b, err := os.ReadFile(cfg.TLSClientConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Kubernetes API CA: %v", err)
+		}
+		existing = b
+	}
+	var data bytes.Buffer
+	data.Write(existing)
+	if len(existing) > 0 && !bytes.HasSuffix(existing, []byte("\n")) {
+		data.WriteByte('\n')
+	}
+	data.Write(bundle)
+	cfg.TLSClientConfig.CAData = data.Bytes()
+	cfg.TLSClientConfig.CAFile = ""
+	return cfg, nil
+}
@@ -0,0 +1,117 @@
+package solver
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// testCACert returns a self-signed CA certificate in PEM form.
+func testCACert(t *testing.T, name string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func writeTestFile(t *testing.T, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadCABundle(t *testing.T) {
+	ca := testCACert(t, "proxy")
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr bool
+	}{
+		{"certificate", ca, false},
+		{"two certificates", append(append([]byte(nil), ca...), testCACert(t, "other")...), false},
+		{"empty", nil, true},
+		{"not PEM", []byte("not a certificate"), true},
+		{"key only", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("x")}), true},
+		{"broken certificate", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("x")}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readCABundle(writeTestFile(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadCABundleFromEnv(t *testing.T) {
+	t.Setenv("KUBE_API_CA_FILE", filepath.Join(t.TempDir(), "missing.pem"))
+	if cfg := SettingsFromEnv(); len(cfg.parseErrors) != 1 || !strings.Contains(cfg.parseErrors[0], "KUBE_API_CA_FILE") {
+		t.Errorf("got parse errors %v, want one for KUBE_API_CA_FILE", cfg.parseErrors)
+	}
+}
+
+func TestWithCABundle(t *testing.T) {
+	clusterCA, extraCA := testCACert(t, "cluster"), testCACert(t, "proxy")
+	for _, fromFile := range []bool{false, true} {
+		cfg := &rest.Config{Host: "https://kubernetes.default.svc"}
+		if fromFile {
+			cfg.TLSClientConfig.CAFile = writeTestFile(t, clusterCA)
+		} else {
+			cfg.TLSClientConfig.CAData = clusterCA
+		}
+		got, err := withCABundle(cfg, extraCA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(got.TLSClientConfig.CAData) {
+			t.Fatalf("CA file %v: combined CA data does not parse", fromFile)
+		}
+		if !bytes.Contains(got.TLSClientConfig.CAData, clusterCA) || !bytes.Contains(got.TLSClientConfig.CAData, extraCA) {
+			t.Errorf("CA file %v: combined CA data misses the cluster or the extra CA", fromFile)
+		}
+		if got.TLSClientConfig.CAFile != "" {
+			t.Errorf("CA file %v: CAFile %q left set", fromFile, got.TLSClientConfig.CAFile)
+		}
+		if fromFile && len(cfg.TLSClientConfig.CAData) != 0 || !fromFile && !bytes.Equal(cfg.TLSClientConfig.CAData, clusterCA) {
+			t.Errorf("CA file %v: the passed config was modified", fromFile)
+		}
+	}
+}
+
+func TestWithoutCABundle(t *testing.T) {
+	cfg := &rest.Config{Host: "https://kubernetes.default.svc"}
+	if got, err := withCABundle(cfg, nil); err != nil || got != cfg {
+		t.Errorf("got config %p, error %v, want the config unchanged", got, err)
+	}
+}
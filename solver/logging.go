@@ -0,0 +1,251 @@
+package solver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+// Log formats selectable with LOG_FORMAT.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Log levels, selectable as the minimum level with LOG_LEVEL.
+const (
+	levelDebug   = "debug"
+	levelInfo    = "info"
+	levelWarning = "warning"
+	levelError   = "error"
+)
+
+// levelRanks orders the log levels by severity.
+var levelRanks = map[string]int{levelDebug: 0, levelInfo: 1, levelWarning: 2, levelError: 3}
+
+// Categories of routine messages whose level depends on how much detail an
+// installation wants. LOG_MESSAGE_LEVELS overrides their levels.
+const (
+	// msgRecordPresent is Present finding its record already written,
+	// which cert-manager causes on every retry and renewal.
+	msgRecordPresent = "recordPresent"
+	// msgZoneScan summarizes the zone list pages read to resolve a zone.
+	msgZoneScan = "zoneScan"
+	// msgRecordWritten is a TXT record being added, updated or deleted.
+	msgRecordWritten = "recordWritten"
+	// msgAudit is the one entry per zone summing up what a Present wrote
+	// or a CleanUp deleted.
+	msgAudit = "audit"
+)
+
+// defaultMessageLevels is the level of each message category unless
+// LOG_MESSAGE_LEVELS says otherwise. It is the one place where categories
+// are mapped to levels.
+var defaultMessageLevels = map[string]string{
+	msgRecordPresent: levelDebug,
+	msgZoneScan:      levelDebug,
+	msgRecordWritten: levelInfo,
+	msgAudit:         levelInfo,
+}
+
+// logField is a key/value pair attached to a log entry.
+type logField struct {
+	key   string
+	value interface{}
+}
+
+func field(key string, value interface{}) logField {
+	return logField{key, value}
+}
+
+// fieldLogger writes log entries carrying a fixed set of fields through the
+// standard library logger, either as text or as one JSON object per line.
+type fieldLogger struct {
+	fields []logField
+}
+
+var (
+	rootLogger    fieldLogger
+	logJSON       bool
+	logNow        = time.Now
+	minLogLevel   = levelInfo
+	messageLevels = defaultMessageLevels
+)
+
+// configureLogging switches the output format of all loggers.
+func configureLogging(format string) {
+	logJSON = format == logFormatJSON
+	if logJSON {
+		// Entries carry their own timestamp.
+		log.SetFlags(0)
+	} else {
+		log.SetFlags(log.LstdFlags)
+	}
+}
+
+// configureLogLevels sets the minimum level of entries that are written and
+// overrides the levels of message categories.
+func configureLogLevels(level string, overrides map[string]string) {
+	minLogLevel = level
+	levels := make(map[string]string, len(defaultMessageLevels))
+	for category, l := range defaultMessageLevels {
+		levels[category] = l
+	}
+	for category, l := range overrides {
+		levels[category] = l
+	}
+	messageLevels = levels
+}
+
+// parseMessageLevels parses LOG_MESSAGE_LEVELS, a comma-separated list of
+// category=level pairs such as "recordPresent=info".
+func parseMessageLevels(s string) (map[string]string, error) {
+	levels := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		category, level, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not category=level", pair)
+		}
+		category, level = strings.TrimSpace(category), strings.TrimSpace(level)
+		if _, ok := defaultMessageLevels[category]; !ok {
+			return nil, fmt.Errorf("unknown message category %q", category)
+		}
+		if _, ok := levelRanks[level]; !ok {
+			return nil, fmt.Errorf("unknown level %q for %s", level, category)
+		}
+		levels[category] = level
+	}
+	return levels, nil
+}
+
+// challengeLogger returns a logger tagging entries with what identifies the
+// challenge: its correlation ID, the namespace of the resource it was
+// issued for and, when cert-manager sets them, the operation and DNS name.
+//
+// ChallengeRequest does not carry the Issuer or Certificate, nor whether
+// the name is a wildcard, since cert-manager passes wildcard names without
+// the "*." label.
+func challengeLogger(ch *v1alpha1.ChallengeRequest) fieldLogger {
+	l := rootLogger.with(field("correlationID", string(ch.UID)))
+	if ch.Action != "" {
+		l = l.with(field("operation", string(ch.Action)))
+	}
+	if ch.DNSName != "" {
+		l = l.with(field("dnsName", ch.DNSName))
+	}
+	return l.with(field("namespace", ch.ResourceNamespace))
+}
+
+// with returns a logger that adds fields to every entry.
+func (l fieldLogger) with(fields ...logField) fieldLogger {
+	return fieldLogger{fields: append(append([]logField(nil), l.fields...), fields...)}
+}
+
+func (l fieldLogger) debug(msg string, fields ...logField) {
+	l.write(levelDebug, msg, fields)
+}
+
+// message writes an entry of the given category at its configured level.
+func (l fieldLogger) message(category, msg string, fields ...logField) {
+	l.write(messageLevels[category], msg, fields)
+}
+
+func (l fieldLogger) info(msg string, fields ...logField) {
+	l.write(levelInfo, msg, fields)
+}
+
+func (l fieldLogger) warning(msg string, fields ...logField) {
+	l.write(levelWarning, msg, fields)
+}
+
+func (l fieldLogger) error(msg string, fields ...logField) {
+	l.write(levelError, msg, fields)
+}
+
+func (l fieldLogger) write(level, msg string, fields []logField) {
+	if levelRanks[level] < levelRanks[minLogLevel] {
+		return
+	}
+	all := append(append([]logField(nil), l.fields...), fields...)
+	if logJSON {
+		log.Print(formatJSON(logNow(), level, msg, all))
+	} else {
+		log.Print(formatText(level, msg, all))
+	}
+}
+
+// formatText renders an entry as "[correlationID] level: msg key=value".
+// The level is omitted for info entries.
+func formatText(level, msg string, fields []logField) string {
+	var b strings.Builder
+	for _, f := range fields {
+		if f.key == "correlationID" {
+			fmt.Fprintf(&b, "[%v] ", f.value)
+		}
+	}
+	if level != levelInfo {
+		b.WriteString(level + ": ")
+	}
+	b.WriteString(msg)
+	for _, f := range fields {
+		if f.key == "correlationID" {
+			continue
+		}
+		v := fmt.Sprint(fieldValue(f.value))
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", f.key, v)
+	}
+	return b.String()
+}
+
+// formatJSON renders an entry as a JSON object with the timestamp, level
+// and message followed by the fields in order.
+func formatJSON(t time.Time, level, msg string, fields []logField) string {
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	encodeJSON(&b, t.UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	encodeJSON(&b, level)
+	b.WriteString(`,"msg":`)
+	encodeJSON(&b, msg)
+	for _, f := range fields {
+		b.WriteByte(',')
+		encodeJSON(&b, f.key)
+		b.WriteByte(':')
+		encodeJSON(&b, fieldValue(f.value))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func encodeJSON(b *bytes.Buffer, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		out, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(out)
+}
+
+// fieldValue converts values that have no useful JSON form, such as errors
+// and durations, to strings.
+func fieldValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
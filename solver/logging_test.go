@@ -0,0 +1,207 @@
+package solver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestFormatText(t *testing.T) {
+	got := formatText(levelWarning, "zone no longer exists", []logField{
+		field("correlationID", "uid-1"),
+		field("zone", "example.com."),
+		field("error", errors.New("not found")),
+		field("empty", ""),
+	})
+	want := `[uid-1] warning: zone no longer exists zone=example.com. error="not found" empty=""`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	ts := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	got := formatJSON(ts, levelInfo, "added TXT record", []logField{
+		field("correlationID", "uid-1"),
+		field("zone", int64(42)),
+		field("elapsed", 1500*time.Millisecond),
+		field("error", errors.New("boom")),
+	})
+	want := `{"time":"2023-02-01T12:00:00Z","level":"info","msg":"added TXT record","correlationID":"uid-1","zone":42,"elapsed":"1.5s","error":"boom"}`
+	if got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestJSONLogging(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	configureLogging(logFormatJSON)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		configureLogging(logFormatText)
+	}()
+
+	api := newFakeDNSZoneAPI("example.com")
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")
+	ch.Action = "Present"
+	ch.DNSName = "example.com"
+	if err := newTestSolver(api).Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		if entry["msg"] == "added TXT record" {
+			found = true
+			if entry["correlationID"] != "test-uid" || entry["operation"] != "Present" || entry["dnsName"] != "example.com" ||
+				entry["namespace"] != "default" || entry["record"] != "_acme-challenge" {
+				t.Errorf("entry %v is missing challenge fields", entry)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no added TXT record entry in %s", buf.String())
+	}
+}
+
+func TestMessageLevels(t *testing.T) {
+	tests := []struct {
+		name      string
+		level     string
+		overrides map[string]string
+		want      string
+	}{
+		{"debug by default", levelInfo, nil, ""},
+		{"shown at debug level", levelDebug, nil, "debug: TXT record is present, skipping"},
+		{"promoted to info", levelInfo, map[string]string{msgRecordPresent: levelInfo}, "] TXT record is present, skipping"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			configureLogLevels(tt.level, tt.overrides)
+			defer func() {
+				log.SetOutput(os.Stderr)
+				configureLogLevels(levelInfo, nil)
+			}()
+
+			api := newFakeDNSZoneAPI("example.com")
+			api.zones[0].Records = []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "key")}
+			if err := newTestSolver(api).Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+				t.Fatal(err)
+			}
+			got := buf.String()
+			if tt.want == "" {
+				if strings.Contains(got, "TXT record is present") {
+					t.Errorf("record present message logged at level %s:\n%s", tt.level, got)
+				}
+				return
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("log does not contain %q:\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMinLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	configureLogLevels(levelWarning, nil)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		configureLogLevels(levelInfo, nil)
+	}()
+
+	rootLogger.info("routine")
+	rootLogger.warning("unusual")
+	if got := buf.String(); strings.Contains(got, "routine") || !strings.Contains(got, "unusual") {
+		t.Errorf("at level warning got log:\n%s", got)
+	}
+}
+
+func TestAuditMessages(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		want      []string
+	}{
+		{"info by default", nil, []string{
+			"[test-uid] presented challenge record",
+			"zone=1 record=_acme-challenge ttl=120 recordID=1001",
+			"[test-uid] cleaned up challenge record",
+			"zone=1 record=_acme-challenge recordIDs=[1001]",
+		}},
+		{"quieted", map[string]string{msgAudit: levelDebug}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			configureLogLevels(levelInfo, tt.overrides)
+			defer func() {
+				log.SetOutput(os.Stderr)
+				configureLogLevels(levelInfo, nil)
+			}()
+
+			solver := newTestSolver(newFakeDNSZoneAPI("example.com"))
+			ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")
+			if err := solver.Present(ch); err != nil {
+				t.Fatal(err)
+			}
+			if err := solver.CleanUp(ch); err != nil {
+				t.Fatal(err)
+			}
+			got := buf.String()
+			if tt.want == nil && (strings.Contains(got, "presented challenge record") || strings.Contains(got, "cleaned up challenge record")) {
+				t.Errorf("audit messages logged although quieted:\n%s", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("log does not contain %q:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCleanUpAuditsOnlyDeletedRecords(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := newFakeDNSZoneAPI("example.com")
+	noID := txtRecord(0, "_acme-challenge", "key")
+	noID.ID = nil
+	api.zones[0].Records = []bunny.DNSRecord{unmanagedTXTRecord(10, "_acme-challenge", "key"), noID}
+	solver := newTestSolver(api)
+	if err := solver.CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	if strings.Contains(got, "cleaned up challenge record") {
+		t.Errorf("audit entry logged although no record was deleted:\n%s", got)
+	}
+	for _, want := range []string{
+		"warning: not deleting TXT records without the webhook's marker namespace=default zone=1 record=_acme-challenge recordIDs=[10]",
+		"warning: not deleting TXT record bunny.net returned without an ID",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log does not contain %q:\n%s", want, got)
+		}
+	}
+	if api.deleteCalls != 0 {
+		t.Errorf("DeleteDNSRecord called %d times, want 0", api.deleteCalls)
+	}
+}
@@ -0,0 +1,1510 @@
+package solver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/cmd"
+
+	bunny "github.com/simplesurance/bunny-go"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DNSZoneAPI is the part of the bunny.net DNS zone API used by the solver.
+// It is implemented by *bunny.DNSZoneService.
+type DNSZoneAPI interface {
+	Get(ctx context.Context, id int64) (*bunny.DNSZone, error)
+	List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error)
+	AddDNSRecord(ctx context.Context, dnsZoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error)
+	UpdateDNSRecord(ctx context.Context, dnsZoneID int64, dnsRecordID int64, opts *bunny.AddOrUpdateDNSRecordOptions) error
+	DeleteDNSRecord(ctx context.Context, dnsZoneID int64, dnsRecordID int64) error
+}
+
+type bunnySolver struct {
+	client kubernetes.Interface
+	// newClient overrides how the bunny.net API client is constructed.
+	newClient func(accessKey string) DNSZoneAPI
+	clients   clientCache
+	zones     zoneCache
+	zoneReads zoneReadBatcher
+	// emptyZoneListBackoff is the initial delay before re-listing an
+	// empty zone list.
+	emptyZoneListBackoff time.Duration
+	// newZoneRetryInterval is the delay between attempts to present in a
+	// zone that may have just been created.
+	newZoneRetryInterval time.Duration
+	// name is the solver name Issuers refer to, defaultSolverName when
+	// empty.
+	name string
+	// strictChallengeKeys only allows TXT values shaped like ACME keys.
+	strictChallengeKeys bool
+	// skipCleanUp makes CleanUp leave records in place.
+	skipCleanUp bool
+	// minTTL and maxTTL clamp the record TTL an Issuer asks for.
+	minTTL int32
+	maxTTL int32
+	// lookupTXT overrides the DNS lookup used for propagation checks.
+	lookupTXT txtLookupFunc
+	// resolveTXT overrides the recursive lookup used for propagation
+	// checks against PropagationResolvers.
+	resolveTXT txtResolveFunc
+	// lookupNS overrides the DNS lookup used for delegation checks.
+	lookupNS nsLookupFunc
+	// zoneScanStartPage makes zone scans skip the pages before it. It is
+	// a diagnostics knob for accounts whose zone is known to be listed
+	// late; zones on skipped pages are never found.
+	zoneScanStartPage int32
+	// apiRetryBackoff and maintenanceBackoff override the delays before
+	// retrying a failed API call.
+	apiRetryBackoff    time.Duration
+	maintenanceBackoff time.Duration
+	// configDefaults is the JSON of the solver config defaults file that
+	// Issuer configs are applied on top of.
+	configDefaults []byte
+	// presentSlots bounds how many Present calls run at once, from zone
+	// resolution to propagation. It is nil when there is no limit.
+	presentSlots chan struct{}
+	// cleanUpSlots bounds how many CleanUp calls delete records at once,
+	// separately from presentSlots. It is nil when there is no limit.
+	cleanUpSlots chan struct{}
+	// stop is closed when the webhook shuts down, which cuts a CleanUp
+	// delay short.
+	stop <-chan struct{}
+	// kubeCABundle is trusted for the Kubernetes API in addition to the
+	// CAs of the rest config passed to Initialize.
+	kubeCABundle []byte
+	// backends are the DNS providers challenge records are written to.
+	// Only bunny.net is used when it is empty.
+	backends []recordBackend
+	// secretRetryBackoff overrides the initial delay before retrying a
+	// failed Secret read.
+	secretRetryBackoff time.Duration
+	// breaker fails bunny.net calls fast during an outage. It is nil when
+	// disabled.
+	breaker *circuitBreaker
+	// recordPollInterval overrides the delay between polls for a record
+	// written by Present to become visible through the API.
+	recordPollInterval time.Duration
+	// notifier reports successful operations to NOTIFY_URL. It is nil
+	// when no URL is configured.
+	notifier *notifier
+	// largeZoneRecords is the record count above which a zone read logs a
+	// warning about its cost; zero disables the warning. largeZones holds
+	// the IDs of zones warned about, so each is warned about once.
+	largeZoneRecords int32
+	largeZones       sync.Map
+	// createdRecords are the records recently added by Present, which
+	// CleanUp waits for when the API does not list them yet.
+	createdRecords createdRecords
+	// selfTestZone and selfTestSecret configure the startup self-test,
+	// which does not run when they are empty.
+	selfTestZone   string
+	selfTestSecret string
+	// secretCacheNamespaces are the namespaces whose Secrets are read
+	// through secrets, started by Initialize. secrets is nil when no
+	// namespace is cached.
+	secretCacheNamespaces []string
+	secrets               *secretCache
+}
+
+type bunnyConfig struct {
+	AccessKeySecretRef corev1.SecretKeySelector `json:"apiSecretRef"`
+	// FallbackAccessKeySecretRefs are tried in order when the access key
+	// from AccessKeySecretRef cannot be read or is rejected by bunny.net.
+	FallbackAccessKeySecretRefs []corev1.SecretKeySelector `json:"fallbackApiSecretRefs"`
+
+	// WaitForPropagation makes Present block until the TXT record is
+	// served by the zone's bunny.net nameservers, all of them unless
+	// PropagationQuorum is "majority" or "any".
+	WaitForPropagation bool   `json:"waitForPropagation"`
+	PropagationQuorum  string `json:"propagationQuorum"`
+	// WaitForRecord makes Present poll the zone until the API returns the
+	// record it wrote, for up to RecordVisibilityTimeout.
+	WaitForRecord           bool     `json:"waitForRecord"`
+	RecordVisibilityTimeout duration `json:"recordVisibilityTimeout"`
+	// ZoneReadWindow makes presents in one zone that start within the
+	// window share a single read of the zone's records.
+	ZoneReadWindow duration `json:"zoneReadWindow"`
+	// CheckDelegation makes Present warn when the zone is not delegated to
+	// ExpectedNameservers, or to the zone's bunny.net nameservers when
+	// none are given. Setting ExpectedNameservers turns the check on.
+	CheckDelegation     bool     `json:"checkDelegation"`
+	ExpectedNameservers []string `json:"expectedNameservers"`
+	// RequireActiveZone makes Present fail when bunny.net reports that the
+	// zone is not serving yet, rather than writing a record nobody sees.
+	RequireActiveZone bool `json:"requireActiveZone"`
+	// CheckPublicSuffix makes Present warn when the challenge's zone does
+	// not end with a suffix on the public suffix list, as a zone such as
+	// example.internal cannot be a bunny.net zone. Off by default, since
+	// setups with private zones exist.
+	CheckPublicSuffix bool `json:"checkPublicSuffix"`
+	// PropagationTimeout bounds the wait for propagation.
+	PropagationTimeout duration `json:"propagationTimeout"`
+	// PropagationResolvers are recursive resolvers that must also serve
+	// the record before Present returns. They are only asked once the
+	// zone's nameservers serve it. A resolver that cached the record's
+	// absence for longer than PropagationNegativeCacheGrace is not waited
+	// for.
+	PropagationResolvers          []string  `json:"propagationResolvers"`
+	PropagationNegativeCacheGrace *duration `json:"propagationNegativeCacheGrace"`
+	// AllMatchingZones presents and cleans up the record in every zone
+	// named ResolvedZone instead of only the first one found.
+	AllMatchingZones bool `json:"allMatchingZones"`
+	// EmptyZoneListRetries is how often an empty zone list is re-fetched
+	// before the zone is reported as not found.
+	EmptyZoneListRetries *int `json:"emptyZoneListRetries"`
+	// NewZoneRetryWindow is how long Present retries a zone that is not
+	// listable or writable yet because it was only just created. Zero
+	// disables the retries.
+	NewZoneRetryWindow *duration `json:"newZoneRetryWindow"`
+	// UpdateStaleRecords makes Present overwrite an existing record with
+	// the challenge's name but another value rather than adding a second
+	// one. It must stay off when a certificate covers both a domain and
+	// its wildcard, as both challenges share one record name.
+	UpdateStaleRecords bool `json:"updateStaleRecords"`
+	// DeleteStaleRecords makes Present delete records with the challenge's
+	// name but another value, left over from earlier challenges, so that
+	// resolvers do not serve conflicting values. With UpdateStaleRecords
+	// one of them is kept to be updated. Like UpdateStaleRecords it must
+	// stay off for certificates covering a domain and its wildcard.
+	DeleteStaleRecords bool `json:"deleteStaleRecords"`
+	// RecreateRecords makes Present delete a record that already holds
+	// the challenge key and write it anew instead of trusting that it is
+	// served, for setups where the existing record is not reliable.
+	RecreateRecords bool `json:"recreateRecords"`
+	// StaleRecordMaxAge makes Present delete the webhook's records with
+	// the challenge's name but another value once they are older than
+	// this, at most maxExpiredRecordDeletes at a time.
+	StaleRecordMaxAge duration `json:"staleRecordMaxAge"`
+	// RecordNameStrategy selects how the record name is derived: "fqdn"
+	// from the challenge's resolved FQDN, the default, or "fixed" to
+	// always use RecordName, relative to the zone.
+	RecordNameStrategy string `json:"recordNameStrategy"`
+	RecordName         string `json:"recordName"`
+	// MaxChallengeRecordsPerZone is how many challenge TXT records, named
+	// _acme-challenge or below it, a zone may hold before Present refuses
+	// to add another. Zero, the default, disables the limit.
+	MaxChallengeRecordsPerZone int `json:"maxChallengeRecordsPerZone"`
+	// MaxRecordNameLabels is how many labels the record name may have
+	// below the zone. Deeper names usually come from a broken delegation
+	// or zone setup, so Present refuses them. Zero disables the limit.
+	MaxRecordNameLabels *int `json:"maxRecordNameLabels"`
+	// RecordNameFormat selects the form of the record name sent to
+	// bunny.net: "auto", the default, sends it relative to the zone and
+	// drops a zone it already ends with, "relative" sends it unchanged and
+	// "fqdn" appends the zone.
+	RecordNameFormat string `json:"recordNameFormat"`
+	// RecordValueStrategy selects how the record value is derived from
+	// the key: "normalized", the default, strips surrounding whitespace,
+	// quotes and escapes, "verbatim" writes it unchanged.
+	RecordValueStrategy string `json:"recordValueStrategy"`
+	// TTL is the challenge record's TTL, in seconds or as a duration.
+	TTL *ttlValue `json:"ttl"`
+	// TTLJitter moves the TTL of every record by a random amount of up
+	// to this many seconds either way.
+	TTLJitter *ttlValue `json:"ttlJitter"`
+	// ZoneMinTTL is the minimum TTL the zone accepts, if it enforces one.
+	// ZoneMinTTLAction says what happens to a TTL below it: "clamp", the
+	// default, raises it to the minimum, "error" fails the Present.
+	ZoneMinTTL       *ttlValue `json:"zoneMinTTL"`
+	ZoneMinTTLAction string    `json:"zoneMinTTLAction"`
+	// SkipFailedZonePages keeps a zone scan going past a page that fails
+	// to load, fetching it again only if the zone is not found elsewhere.
+	SkipFailedZonePages bool `json:"skipFailedZonePages"`
+	// VerifyBatch makes a batch Present read each zone once more after
+	// writing its records and fail unless every one of them is there.
+	VerifyBatch bool `json:"verifyBatch"`
+	// DisableZoneCache resolves the zone through the API on every call,
+	// for when a zone was recreated and the cache still holds its old ID.
+	DisableZoneCache bool `json:"disableZoneCache"`
+	// DeleteUnmanagedRecords lets CleanUp delete matching records that do
+	// not carry the webhook's marker, such as records created by hand or
+	// by versions of the webhook that did not set it.
+	DeleteUnmanagedRecords bool `json:"deleteUnmanagedRecords"`
+	// MaxRecordsPerName is how many TXT records matching a challenge
+	// CleanUp deletes at most. Far more than a handful means something is
+	// writing records in a loop, so CleanUp refuses rather than deleting
+	// them all, unless AllowMassDelete is set. Zero disables the limit.
+	MaxRecordsPerName *int `json:"maxRecordsPerName"`
+	AllowMassDelete   bool `json:"allowMassDelete"`
+	// CleanUpVisibilityWindow is how long after Present added a record
+	// CleanUp keeps re-reading the zone while the API does not list the
+	// record yet. Zero disables the wait.
+	CleanUpVisibilityWindow *duration `json:"cleanUpVisibilityWindow"`
+	// RecordWeight is the bunny.net weight challenge records are written
+	// with, for zones that use weighted records for the same name. The
+	// bunny.net default applies when it is unset.
+	RecordWeight *int32 `json:"recordWeight"`
+	// APITimeout bounds each bunny.net API call. ListTimeout applies to
+	// listing zones and MutateTimeout to reading and writing records of
+	// a zone; both default to APITimeout.
+	APITimeout    duration `json:"apiTimeout"`
+	ListTimeout   duration `json:"listTimeout"`
+	MutateTimeout duration `json:"mutateTimeout"`
+	// SlowAPICallThreshold is how long a bunny.net API call may take
+	// before a warning is logged. Zero disables the warnings.
+	SlowAPICallThreshold *duration `json:"slowAPICallThreshold"`
+	// APIRetries is how often a bunny.net API call failing with a
+	// gateway error or 503 is retried.
+	APIRetries *int `json:"apiRetries"`
+	// OperationTimeout bounds a whole Present or CleanUp, from zone lookup
+	// to propagation, on top of the timeouts of single API calls.
+	OperationTimeout duration `json:"operationTimeout"`
+	// CleanUpDelay is how long CleanUp waits before deleting the TXT
+	// record, for resolvers that validate again shortly after success. It
+	// counts towards OperationTimeout.
+	CleanUpDelay duration `json:"cleanUpDelay"`
+	// SecretTimeout bounds each attempt to read the access key Secret.
+	SecretTimeout duration `json:"secretTimeout"`
+	// SecretRetries is how often a Secret read failing with a transient
+	// Kubernetes API error is retried.
+	SecretRetries *int `json:"secretRetries"`
+}
+
+const (
+	defaultEmptyZoneListRetries = 3
+	defaultEmptyZoneListBackoff = 2 * time.Second
+)
+
+// duration is a time.Duration that is decoded from a string such as "90s".
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// GroupName is the API group the webhook serves, read from GROUP_NAME.
+var GroupName = os.Getenv("GROUP_NAME")
+
+// Main runs the webhook binary: it reads the Settings from the
+// environment, handles the --version, --check and --export modes, and
+// otherwise serves the solver until the process is stopped.
+func Main() {
+	if len(os.Args) > 1 && os.Args[1] == versionFlag {
+		printVersion(os.Stdout)
+		return
+	}
+	startup := SettingsFromEnv()
+	configureLogging(startup.LogFormat)
+	configureLogLevels(startup.LogLevel, startup.LogMessageLevels)
+	if err := validateStartupConfig(startup); err != nil {
+		rootLogger.error(err.Error())
+		os.Exit(1)
+	}
+	configureAPITransport(startup)
+	if len(os.Args) > 1 && (os.Args[1] == checkFlag || os.Args[1] == exportFlag) {
+		run := runCheck
+		if os.Args[1] == exportFlag {
+			run = runExport
+		}
+		if err := run(newBunnySolver(startup), os.Args[2:], os.Getenv("BUNNY_ACCESS_KEY"), os.Stdout); err != nil {
+			rootLogger.error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	rootLogger.info("starting webhook", field("version", Version), field("commit", Commit), field("buildDate", BuildDate))
+	if startup.SkipCleanUp {
+		rootLogger.warning("SKIP_CLEANUP is set, challenge records are left behind; only use it in disposable clusters")
+	}
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		rootLogger.error("failed to set up tracing: " + err.Error())
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+	if startup.MetricsAddress != "" {
+		if err := startMetricsServer(startup.MetricsAddress, startup.MetricsBindPolicy); err != nil {
+			rootLogger.error(err.Error())
+			os.Exit(1)
+		}
+	}
+	cmd.RunWebhookServer(startup.GroupName,
+		newBunnySolver(startup),
+	)
+}
+
+func newBunnySolver(startup Settings) *bunnySolver {
+	c := &bunnySolver{
+		strictChallengeKeys:   startup.StrictChallengeKeys,
+		skipCleanUp:           startup.SkipCleanUp,
+		minTTL:                startup.MinTTL,
+		maxTTL:                startup.MaxTTL,
+		zoneScanStartPage:     startup.ZoneScanStartPage,
+		configDefaults:        startup.ConfigDefaults,
+		kubeCABundle:          startup.KubeAPICABundle,
+		largeZoneRecords:      startup.LargeZoneRecords,
+		selfTestZone:          startup.SelfTestZone,
+		selfTestSecret:        startup.SelfTestSecret,
+		secretCacheNamespaces: startup.SecretCacheNamespaces,
+	}
+	if startup.MaxConcurrentPresents > 0 {
+		c.presentSlots = make(chan struct{}, startup.MaxConcurrentPresents)
+	}
+	if startup.MaxConcurrentCleanUps > 0 {
+		c.cleanUpSlots = make(chan struct{}, startup.MaxConcurrentCleanUps)
+	}
+	if startup.BreakerFailures > 0 {
+		c.breaker = newCircuitBreaker(int(startup.BreakerFailures), startup.BreakerWindow, startup.BreakerCooldown)
+	}
+	if startup.NotifyURL != "" {
+		c.notifier = newNotifier(startup.NotifyURL, startup.NotifyTimeout)
+	}
+	return c
+}
+
+// defaultSolverName is the solver name Issuers refer to unless an
+// embedding program chooses another one.
+const defaultSolverName = "bunny"
+
+func (c *bunnySolver) Name() string {
+	if c.name != "" {
+		return c.name
+	}
+	return defaultSolverName
+}
+
+func (c *bunnySolver) Present(ch *v1alpha1.ChallengeRequest) error {
+	return displayError(challengeLogger(ch), "present", c.present(ch))
+}
+
+func (c *bunnySolver) present(ch *v1alpha1.ChallengeRequest) error {
+	req, err := c.preparePresent(ch)
+	if err != nil {
+		return err
+	}
+	if c.presentSlots != nil {
+		c.presentSlots <- struct{}{}
+		defer func() { <-c.presentSlots }()
+	}
+	ch, cfg, recordName := req.ch, req.cfg, req.recordName
+	err = c.withOperationTimeout(cfg, "present", func(ctx context.Context) error {
+		return traced(ctx, "Present", func(ctx context.Context) error {
+			backends := c.recordBackends()
+			for _, backend := range backends {
+				if err := backend.present(ctx, ch, cfg, recordName, req.value); err != nil {
+					return backendError(backends, backend, err)
+				}
+			}
+			return nil
+		}, challengeAttributes(ch, recordName)...)
+	})
+	if err != nil {
+		return err
+	}
+	c.notifier.notify("Present", ch, recordName)
+	return nil
+}
+
+// presentRequest is a challenge ready to be written: its config is loaded
+// and the name and value of its record are decided.
+type presentRequest struct {
+	ch         *v1alpha1.ChallengeRequest
+	cfg        bunnyConfig
+	recordName string
+	value      string
+}
+
+// preparePresent validates ch and works out the record to write for it,
+// without calling any API.
+func (c *bunnySolver) preparePresent(ch *v1alpha1.ChallengeRequest) (presentRequest, error) {
+	ch = withASCIINames(ch)
+	if err := validateChallengeRequest(ch); err != nil {
+		return presentRequest{}, err
+	}
+	cfg, err := c.loadConfig(ch.Config)
+	if err != nil {
+		return presentRequest{}, err
+	}
+	if c.strictChallengeKeys && !acmeChallengeKey.MatchString(ch.Key) {
+		return presentRequest{}, fmt.Errorf("refusing to write TXT value %q: not an ACME DNS-01 challenge key", ch.Key)
+	}
+	strategy, err := newRecordStrategy(cfg)
+	if err != nil {
+		return presentRequest{}, err
+	}
+	recordName, err := strategy.recordName(ch)
+	if err != nil {
+		return presentRequest{}, err
+	}
+	if err := checkRecordNameDepth(recordName, ch.ResolvedZone, maxRecordNameLabels(cfg)); err != nil {
+		return presentRequest{}, err
+	}
+	if err := validateZoneMinTTL(cfg); err != nil {
+		return presentRequest{}, err
+	}
+	if err := validatePropagationQuorum(cfg); err != nil {
+		return presentRequest{}, err
+	}
+	if cfg.CheckPublicSuffix {
+		warnUnknownPublicSuffix(challengeLogger(ch), ch.ResolvedZone)
+	}
+	return presentRequest{ch: ch, cfg: cfg, recordName: recordName, value: strategy.recordValue(ch)}, nil
+}
+
+// present writes the challenge record to the bunny.net zones matching the
+// challenge's zone.
+func (b bunnyBackend) present(ctx context.Context, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, recordName, value string) error {
+	c := b.c
+	bunnyClient, err := c.newAPIClient(ctx, ch, cfg)
+	if err != nil {
+		return err
+	}
+	window := c.newZoneRetryWindow(bunnyClient, ch.ResolvedZone, cfg)
+	return c.retryNewZone(ctx, ch, window, func() error {
+		return c.inResolvedZones(ctx, bunnyClient, ch, cfg, func(zoneIDs []int64) error {
+			for _, zoneID := range zoneIDs {
+				if err := c.presentInZone(ctx, bunnyClient, ch, cfg, recordName, value, zoneID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func (c *bunnySolver) presentInZone(ctx context.Context, bunnyClient DNSZoneAPI, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, recordName, value string, zoneID int64) error {
+	zoneAttr := attribute.Int64("zone.id", zoneID)
+	var records []bunny.DNSRecord
+	err := traced(ctx, "check records", func(context.Context) (err error) {
+		records, err = c.batchedZoneRecords(ctx, bunnyClient, cfg, zoneID)
+		return err
+	}, zoneAttr)
+	if err != nil {
+		return err
+	}
+	_, err = c.writeChallengeRecord(ctx, bunnyClient, ch, cfg, recordName, value, zoneID, records)
+	return err
+}
+
+// writeChallengeRecord writes the challenge record to the zone, whose
+// current records are given, unless it is already there. It returns the
+// zone's records as they are afterwards, so that several challenges can
+// be written from a single read of the zone.
+func (c *bunnySolver) writeChallengeRecord(ctx context.Context, bunnyClient DNSZoneAPI, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, recordName, value string, zoneID int64, records []bunny.DNSRecord) ([]bunny.DNSRecord, error) {
+	zoneAttr := attribute.Int64("zone.id", zoneID)
+	logger := challengeLogger(ch).with(field("zone", zoneID), field("record", recordName))
+	var err error
+	if cfg.RequireActiveZone {
+		if err := checkZoneActive(bunnyClient, zoneID); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.CheckDelegation || len(cfg.ExpectedNameservers) > 0 {
+		c.checkDelegation(ctx, bunnyClient, logger, ch, cfg, zoneID)
+	}
+	if cfg.DeleteStaleRecords {
+		records, err = c.deleteStaleRecords(bunnyClient, logger, ch, cfg, records, recordName, zoneID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.StaleRecordMaxAge.Duration > 0 {
+		records, err = c.deleteExpiredRecords(bunnyClient, logger, ch, cfg, records, recordName, zoneID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RecreateRecords {
+		records, err = c.deleteMatchingRecords(bunnyClient, logger, ch, cfg, records, recordName, zoneID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(matchTXTRecords(records, recordName, ch.Key, ch.ResolvedZone, cfg.RecordNameFormat)) > 0 {
+		logger.message(msgRecordPresent, "TXT record is present, skipping")
+		return records, nil
+	}
+	ttl, err := c.challengeTTL(cfg, ch.ResolvedZone)
+	if err != nil {
+		logger.warning("refusing to add TXT record", field("error", err))
+		return nil, err
+	}
+	record := txtRecordOptions(cfg, wireRecordName(cfg.RecordNameFormat, recordName, ch.ResolvedZone), value, ttl, recordMarkerTag(time.Now()))
+	var stale []bunny.DNSRecord
+	if cfg.UpdateStaleRecords {
+		named := namedTXTRecords(records, recordName, ch.ResolvedZone, cfg.RecordNameFormat)
+		stale = updatableRecords(named, cfg)
+		if len(stale) < len(named) {
+			logger.warning("not updating TXT records without the webhook's marker", field("records", len(named)-len(stale)))
+		}
+	}
+	if !cfg.UpdateStaleRecords || len(stale) == 0 {
+		if err := checkChallengeRecordLimit(records, ch.ResolvedZone, zoneID, cfg.MaxChallengeRecordsPerZone); err != nil {
+			logger.warning("refusing to add TXT record", field("error", err))
+			return nil, err
+		}
+	}
+	var recordID *int64
+	err = traced(ctx, "write record", func(context.Context) error {
+		if cfg.UpdateStaleRecords && len(stale) > 0 {
+			if err := bunnyClient.UpdateDNSRecord(context.Background(), zoneID, *stale[0].ID, record); err != nil {
+				return fmt.Errorf("failed to update TXT record: %w", explainTTLRejection(err, ttl, ch.ResolvedZone))
+			}
+			logger.message(msgRecordWritten, "updated stale TXT record to the current challenge key", field("recordID", *stale[0].ID))
+			recordID = stale[0].ID
+			return nil
+		}
+		created, err := bunnyClient.AddDNSRecord(context.Background(), zoneID, record)
+		if err != nil {
+			return fmt.Errorf("failed to add TXT record: %w", explainTTLRejection(err, ttl, ch.ResolvedZone))
+		}
+		if recordID = returnedRecordID(created); recordID != nil {
+			logger.message(msgRecordWritten, "added TXT record", field("recordID", *recordID), field("value", ch.Key))
+		} else {
+			// Verification and clean up find the record by name and value.
+			logger.warning("added TXT record but bunny.net returned no record ID, looking it up by name and value instead", field("value", ch.Key))
+		}
+		warnAlteredRecord(logger, record, created)
+		return nil
+	}, zoneAttr)
+	if err != nil {
+		return nil, err
+	}
+	records = withWrittenRecord(records, recordID, record)
+	var createdID int64
+	if recordID != nil {
+		createdID = *recordID
+	}
+	c.createdRecords.add(newCreatedRecordKey(zoneID, recordName, ch.Key), createdID)
+	if cfg.WaitForRecord {
+		err := traced(ctx, "wait for record", func(context.Context) error {
+			return c.awaitRecordVisible(ctx, bunnyClient, logger, ch, cfg, recordName, zoneID, recordID)
+		}, zoneAttr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.WaitForPropagation {
+		err := traced(ctx, "wait for propagation", func(context.Context) error {
+			return c.awaitPropagation(ctx, bunnyClient, ch, cfg, zoneID, recordName+"."+ch.ResolvedZone)
+		}, zoneAttr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	audit := []logField{field("ttl", ttl)}
+	if recordID != nil {
+		audit = append(audit, field("recordID", *recordID))
+	}
+	logger.message(msgAudit, "presented challenge record", audit...)
+	return records, nil
+}
+
+// returnedRecordID returns the ID of the record an add returned, or nil
+// when bunny.net returned no record or one without an ID. An ID of 0 is
+// not a real record ID and counts as missing.
+func returnedRecordID(created *bunny.DNSRecord) *int64 {
+	if created == nil || created.ID == nil || *created.ID == 0 {
+		return nil
+	}
+	return created.ID
+}
+
+// withWrittenRecord returns records with the record written as id, whether
+// it replaced an existing record or was added.
+func withWrittenRecord(records []bunny.DNSRecord, id *int64, written *bunny.AddOrUpdateDNSRecordOptions) []bunny.DNSRecord {
+	record := bunny.DNSRecord{ID: id, Type: written.Type, Name: written.Name, Value: written.Value, TTL: written.TTL, Tag: written.Tag}
+	for i, r := range records {
+		if id != nil && r.ID != nil && *r.ID == *id {
+			updated := append([]bunny.DNSRecord(nil), records...)
+			updated[i] = record
+			return updated
+		}
+	}
+	return append(records, record)
+}
+
+// awaitPropagation waits until the challenge's TXT record, written at
+// fqdn, is served by the zone's nameservers, then by the configured
+// recursive resolvers, and records how long it took. fqdn differs from the
+// challenge's FQDN with the fixed record name strategy.
+func (c *bunnySolver) awaitPropagation(ctx context.Context, client DNSZoneAPI, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, zoneID int64, fqdn string) error {
+	zone, err := client.Get(context.Background(), zoneID)
+	if err != nil {
+		return fmt.Errorf("error getting zone nameservers: %v", err)
+	}
+	timeout := cfg.PropagationTimeout.Duration
+	if timeout <= 0 {
+		timeout = defaultPropagationTimeout
+	}
+	lookup := c.lookupTXT
+	if lookup == nil {
+		lookup = lookupTXT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	nameservers := zoneNameservers(zone)
+	elapsed, err := waitForPropagation(ctx, lookup, nameservers, fqdn, ch.Key, defaultPropagationInterval,
+		quorumSize(cfg.PropagationQuorum, len(nameservers)))
+	if err == nil && len(cfg.PropagationResolvers) > 0 {
+		resolve := c.resolveTXT
+		if resolve == nil {
+			resolve = resolveTXT
+		}
+		grace := defaultNegativeCacheGrace
+		if cfg.PropagationNegativeCacheGrace != nil {
+			grace = cfg.PropagationNegativeCacheGrace.Duration
+		}
+		start := time.Now()
+		err = waitForResolvers(ctx, challengeLogger(ch).with(field("fqdn", fqdn)), resolve,
+			cfg.PropagationResolvers, fqdn, ch.Key, defaultPropagationInterval, grace)
+		elapsed += time.Since(start)
+	}
+	logger := challengeLogger(ch).with(field("fqdn", fqdn), field("elapsed", elapsed))
+	if err != nil {
+		logger.warning("TXT record did not propagate")
+		return err
+	}
+	propagationDuration.Observe(elapsed.Seconds())
+	logger.info("TXT record propagated")
+	return nil
+}
+
+func (c *bunnySolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
+	return displayError(challengeLogger(ch), "clean up", c.cleanUp(ch))
+}
+
+func (c *bunnySolver) cleanUp(ch *v1alpha1.ChallengeRequest) error {
+	ch = withASCIINames(ch)
+	if err := validateChallengeRequest(ch); err != nil {
+		return err
+	}
+	if c.skipCleanUp {
+		challengeLogger(ch).info("SKIP_CLEANUP is set, leaving the TXT record in place", field("fqdn", ch.ResolvedFQDN))
+		return nil
+	}
+	cfg, err := c.loadConfig(ch.Config)
+	if err != nil {
+		return err
+	}
+	strategy, err := newRecordStrategy(cfg)
+	if err != nil {
+		return err
+	}
+	if err := c.delayCleanUp(ch, cfg.CleanUpDelay.Duration); err != nil {
+		return err
+	}
+	if cfg.OperationTimeout.Duration > 0 {
+		// The delay is part of the operation, leave the rest of the
+		// operationTimeout to the deletion.
+		cfg.OperationTimeout.Duration -= cfg.CleanUpDelay.Duration
+	}
+	if c.cleanUpSlots != nil {
+		c.cleanUpSlots <- struct{}{}
+		defer func() { <-c.cleanUpSlots }()
+	}
+	recordName := strategy.cleanUpRecordName(ch)
+	err = c.withOperationTimeout(cfg, "clean up", func(ctx context.Context) error {
+		return traced(ctx, "CleanUp", func(ctx context.Context) error {
+			// Clean up at every backend even if one fails, so that a broken
+			// provider does not leave records behind at the others.
+			var firstErr error
+			backends := c.recordBackends()
+			for _, backend := range backends {
+				if err := backend.cleanUp(ctx, ch, cfg, recordName); err != nil && firstErr == nil {
+					firstErr = backendError(backends, backend, err)
+				}
+			}
+			return firstErr
+		}, challengeAttributes(ch, recordName)...)
+	})
+	if err != nil {
+		return err
+	}
+	c.notifier.notify("CleanUp", ch, recordName)
+	return nil
+}
+
+// cleanUp deletes the challenge record from the bunny.net zones matching
+// the challenge's zone.
+func (b bunnyBackend) cleanUp(ctx context.Context, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, recordName string) error {
+	c := b.c
+	bunnyClient, err := c.newAPIClient(ctx, ch, cfg)
+	if err != nil {
+		return err
+	}
+	err = c.inResolvedZones(ctx, bunnyClient, ch, cfg, func(zoneIDs []int64) error {
+		// A zone that is gone is skipped, but reported once the others
+		// are cleaned up, so that a stale cached ID is resolved again.
+		var gone error
+		for _, zoneID := range zoneIDs {
+			err := c.cleanUpInZone(ctx, bunnyClient, ch, cfg, recordName, zoneID)
+			if isNotFound(err) {
+				challengeLogger(ch).warning("zone no longer exists, nothing to clean up", field("zone", zoneID))
+				gone = err
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return gone
+	})
+	if errors.Is(err, errZoneNotFound) {
+		// The zone was deleted, and its records with it.
+		challengeLogger(ch).warning("zone no longer exists, nothing to clean up", field("zone", ch.ResolvedZone))
+		return nil
+	}
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (c *bunnySolver) cleanUpInZone(ctx context.Context, bunnyClient DNSZoneAPI, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, recordName string, zoneID int64) error {
+	zoneAttr := attribute.Int64("zone.id", zoneID)
+	var records []bunny.DNSRecord
+	err := traced(ctx, "check records", func(context.Context) (err error) {
+		records, err = c.findTXTRecords(bunnyClient, recordName, ch.Key, ch.ResolvedZone, cfg.RecordNameFormat, zoneID)
+		return err
+	}, zoneAttr)
+	if err != nil {
+		return fmt.Errorf("failed to get zone records: %w", err)
+	}
+	records, err = c.awaitCreatedRecord(ctx, bunnyClient, ch, cfg, recordName, zoneID, records)
+	if err != nil {
+		return fmt.Errorf("failed to get zone records: %w", err)
+	}
+	if limit := maxRecordsPerName(cfg); limit > 0 && len(records) > limit && !cfg.AllowMassDelete {
+		challengeLogger(ch).warning("refusing to delete an unexpected number of TXT records, set allowMassDelete to delete them",
+			field("zone", zoneID), field("record", recordName), field("records", len(records)), field("maxRecordsPerName", limit))
+		return fmt.Errorf("refusing to delete %d TXT records named %s, more than maxRecordsPerName (%d)", len(records), recordName, limit)
+	}
+	return traced(ctx, "delete records", func(context.Context) error {
+		var deleted, skipped []int64
+		for _, record := range records {
+			if record.ID == nil {
+				challengeLogger(ch).warning("not deleting TXT record bunny.net returned without an ID",
+					field("zone", zoneID), field("record", recordName))
+				continue
+			}
+			if !isManagedRecord(record) && !cfg.DeleteUnmanagedRecords {
+				skipped = append(skipped, *record.ID)
+				continue
+			}
+			if err := bunnyClient.DeleteDNSRecord(context.Background(), zoneID, *record.ID); err != nil {
+				return fmt.Errorf("failed to delete TXT record: %w", err)
+			}
+			deleted = append(deleted, *record.ID)
+		}
+		c.createdRecords.remove(newCreatedRecordKey(zoneID, recordName, ch.Key))
+		if len(skipped) > 0 {
+			challengeLogger(ch).warning("not deleting TXT records without the webhook's marker",
+				field("zone", zoneID), field("record", recordName), field("recordIDs", skipped))
+		}
+		// Only records actually deleted make the audit trail.
+		if len(deleted) > 0 {
+			challengeLogger(ch).message(msgAudit, "cleaned up challenge record",
+				field("zone", zoneID), field("record", recordName), field("recordIDs", deleted))
+		}
+		return nil
+	}, zoneAttr, attribute.Int("records", len(records)))
+}
+
+func (c *bunnySolver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	kubeClientConfig, err := withCABundle(kubeClientConfig, c.kubeCABundle)
+	if err != nil {
+		return err
+	}
+	cl, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+	c.client = cl
+	c.stop = stopCh
+	c.startSecretCache()
+	c.startSelfTest()
+	return nil
+}
+
+// loadConfig decodes the Issuer's solver config on top of the webhook-wide
+// defaults, so that fields the Issuer sets take precedence.
+func (c *bunnySolver) loadConfig(cfgJSON *extapi.JSON) (bunnyConfig, error) {
+	cfg := bunnyConfig{}
+	// The defaults are decoded afresh every time so that no two configs
+	// share the pointers of optional fields.
+	if len(c.configDefaults) > 0 {
+		if err := json.Unmarshal(c.configDefaults, &cfg); err != nil {
+			return cfg, fmt.Errorf("error decoding solver config defaults: %v", err)
+		}
+	}
+	if cfgJSON == nil {
+		return cfg, checkCleanUpDelay(cfg)
+	}
+	if err := json.Unmarshal(cfgJSON.Raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	}
+	return cfg, checkCleanUpDelay(cfg)
+}
+
+// getAccessKeyFromSecret returns the access key and the resourceVersion of
+// the Secret it was read from.
+func (c *bunnySolver) getAccessKeyFromSecret(ctx context.Context, ref corev1.SecretKeySelector, namespace string, cfg bunnyConfig) (string, string, error) {
+	if ref.Name == "" {
+		return "", "", fmt.Errorf("undefined access key secret")
+	}
+	secret, err := c.getSecret(ctx, namespace, ref.Name, cfg)
+	if err != nil {
+		return "", "", err
+	}
+	accessKey, ok := secret.Data[ref.Key]
+	if !ok {
+		key, err := similarSecretKey(secret.Data, ref.Key)
+		if err != nil {
+			return "", "", fmt.Errorf("%v in secret '%s/%s'", err, namespace, ref.Name)
+		}
+		rootLogger.warning("access key secret has no key named as referenced, using a similar one; fix the apiSecretRef key",
+			field("secret", namespace+"/"+ref.Name), field("referenced", ref.Key), field("used", key))
+		accessKey = secret.Data[key]
+	}
+	return string(accessKey), secret.ResourceVersion, nil
+}
+
+// acmeChallengeKey matches a DNS-01 key: the unpadded base64url encoding of
+// a SHA-256 digest (RFC 8555, section 8.4).
+var acmeChallengeKey = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+// challengeRecordName returns the challenge record's name relative to zone.
+// It fails if fqdn does not sit strictly below zone, which points at an
+// Issuer whose zone does not match the certificate's domain. The zone is
+// stripped label by label and case-insensitively, so that names repeating
+// the zone's labels, such as _acme-challenge.example.com.example.com, and
+// names nested several levels deep keep all of their own labels.
+func challengeRecordName(fqdn, zone string) (string, error) {
+	name, err := relativeRecordName(fqdn, zone)
+	if err != nil {
+		return "", err
+	}
+	if err := validateRecordName(name, fqdn); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// relativeRecordName is challengeRecordName without the validation of the
+// name's labels.
+func relativeRecordName(fqdn, zone string) (string, error) {
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+	zoneLabels := strings.Split(strings.TrimSuffix(zone, "."), ".")
+	if len(labels) < len(zoneLabels) || !sameLabels(labels[len(labels)-len(zoneLabels):], zoneLabels) {
+		return "", fmt.Errorf("challenge FQDN %q is not within zone %q", fqdn, zone)
+	}
+	if len(labels) == len(zoneLabels) {
+		return "", fmt.Errorf("challenge FQDN %q is the apex of zone %q, expected a record below it", fqdn, zone)
+	}
+	return strings.Join(labels[:len(labels)-len(zoneLabels)], "."), nil
+}
+
+// sameLabels reports whether a and b are the same domain name labels,
+// ignoring case.
+func sameLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	maxLabelLength = 63
+	maxNameLength  = 253
+)
+
+// validateRecordName checks that name, the part of fqdn below the zone, is
+// a name bunny.net accepts, so that a malformed FQDN fails with a clear
+// error instead of an opaque API response.
+func validateRecordName(name, fqdn string) error {
+	if n := len(strings.TrimSuffix(fqdn, ".")); n > maxNameLength {
+		return fmt.Errorf("challenge FQDN %q is %d characters long, the maximum is %d", fqdn, n, maxNameLength)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return fmt.Errorf("record name %q contains an empty label", name)
+		}
+		if len(label) > maxLabelLength {
+			return fmt.Errorf("label %q of record name %q is %d characters long, the maximum is %d", label, name, len(label), maxLabelLength)
+		}
+		if label == wildcardLabel {
+			continue
+		}
+		for _, r := range label {
+			if !isLabelChar(r) {
+				return fmt.Errorf("label %q of record name %q contains invalid character %q", label, name, r)
+			}
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("label %q of record name %q starts or ends with a hyphen", label, name)
+		}
+	}
+	return nil
+}
+
+// wildcardLabel may appear as a whole label of a challenge name: the
+// challenge for *.*.example.com is solved at _acme-challenge.*.example.com.
+const wildcardLabel = "*"
+
+// isLabelChar reports whether r may appear in a record name label. Besides
+// letters, digits and hyphens this allows underscores, which the
+// _acme-challenge label needs.
+func isLabelChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_'
+}
+
+func (c *bunnySolver) newAPIClient(ctx context.Context, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig) (DNSZoneAPI, error) {
+	var client DNSZoneAPI
+	if len(cfg.FallbackAccessKeySecretRefs) == 0 {
+		_, api, err := c.credentialClient(ctx, cfg.AccessKeySecretRef, ch.ResourceNamespace, cfg)
+		if err != nil {
+			return nil, err
+		}
+		client = api
+	} else {
+		chain, err := c.newCredentialChain(ctx, ch, cfg)
+		if err != nil {
+			return nil, err
+		}
+		client = chain
+	}
+	api := c.instrument(client, ch, cfg)
+	api.operation = ctx
+	return api, nil
+}
+
+// credentialClient returns the cached bunny.net client for the access key in
+// the referenced Secret, along with the Secret's namespace/name/key.
+func (c *bunnySolver) credentialClient(ctx context.Context, ref corev1.SecretKeySelector, namespace string, cfg bunnyConfig) (string, DNSZoneAPI, error) {
+	accessKey, version, err := c.getAccessKeyFromSecret(ctx, ref, namespace, cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	secret := namespace + "/" + ref.Name + "/" + ref.Key
+	client := c.clients.get(secret, version, func() DNSZoneAPI {
+		if c.newClient != nil {
+			return credentialTracker{api: c.newClient(accessKey), secret: secret}
+		}
+		return credentialTracker{api: bunny.NewClient(accessKey, bunny.WithUserAgent(userAgent())).DNSZone, secret: secret}
+	})
+	return secret, client, nil
+}
+
+const (
+	defaultMaxRecordsPerName   = 20
+	defaultMaxRecordNameLabels = 10
+)
+
+func maxRecordsPerName(cfg bunnyConfig) int {
+	if cfg.MaxRecordsPerName == nil {
+		return defaultMaxRecordsPerName
+	}
+	return *cfg.MaxRecordsPerName
+}
+
+// checkChallengeRecordLimit fails if records, the records of zone zoneID,
+// already hold max or more challenge TXT records. A max of zero disables
+// the check.
+func checkChallengeRecordLimit(records []bunny.DNSRecord, zone string, zoneID int64, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	count := 0
+	for _, r := range records {
+		if r.Type != nil && *r.Type == txtRecordType && r.Name != nil && isChallengeName(normalizeRecordName(*r.Name, zone)) {
+			count++
+		}
+	}
+	if count >= max {
+		return fmt.Errorf("zone %d already holds %d challenge TXT records, the maxChallengeRecordsPerZone limit is %d; "+
+			"this usually means certificates are renewed in a loop or CleanUp keeps failing, look for old _acme-challenge records",
+			zoneID, count, max)
+	}
+	return nil
+}
+
+func maxRecordNameLabels(cfg bunnyConfig) int {
+	if cfg.MaxRecordNameLabels == nil {
+		return defaultMaxRecordNameLabels
+	}
+	return *cfg.MaxRecordNameLabels
+}
+
+// checkRecordNameDepth fails if name, relative to zone, has more than max
+// labels. A max of zero disables the check.
+func checkRecordNameDepth(name, zone string, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	if labels := strings.Count(name, ".") + 1; labels > max {
+		return fmt.Errorf("record name %q has %d labels below zone %q, more than maxRecordNameLabels (%d); check the zone and any CNAME delegation cert-manager followed",
+			name, labels, zone, max)
+	}
+	return nil
+}
+
+// deleteStaleRecords deletes the TXT records named recordName whose value is
+// not the challenge key and returns the records that are left.
+func (c *bunnySolver) deleteStaleRecords(client DNSZoneAPI, logger fieldLogger, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, records []bunny.DNSRecord, recordName string, zoneID int64) ([]bunny.DNSRecord, error) {
+	key := normalizeTXTValue(ch.Key)
+	keepOne := cfg.UpdateStaleRecords && len(matchTXTRecords(records, recordName, ch.Key, ch.ResolvedZone, cfg.RecordNameFormat)) == 0
+	deleted := map[int64]bool{}
+	for _, record := range namedTXTRecords(records, recordName, ch.ResolvedZone, cfg.RecordNameFormat) {
+		if record.ID == nil || normalizeTXTValue(*record.Value) == key {
+			continue
+		}
+		if keepOne && (isManagedRecord(record) || cfg.DeleteUnmanagedRecords) {
+			// Left for writeChallengeRecord to update.
+			keepOne = false
+			continue
+		}
+		if !isManagedRecord(record) && !cfg.DeleteUnmanagedRecords {
+			logger.warning("not deleting stale TXT record without the webhook's marker", field("recordID", *record.ID))
+			continue
+		}
+		if err := client.DeleteDNSRecord(context.Background(), zoneID, *record.ID); err != nil {
+			return nil, fmt.Errorf("failed to delete stale TXT record: %w", err)
+		}
+		logger.message(msgRecordWritten, "deleted stale TXT record", field("recordID", *record.ID))
+		deleted[*record.ID] = true
+	}
+	return withoutRecords(records, deleted), nil
+}
+
+// updatableRecords returns the records the webhook may overwrite: those
+// with an ID that carry its marker, or any with an ID when
+// cfg.DeleteUnmanagedRecords is set, as for deleting them.
+func updatableRecords(records []bunny.DNSRecord, cfg bunnyConfig) []bunny.DNSRecord {
+	var updatable []bunny.DNSRecord
+	for _, record := range records {
+		if record.ID != nil && (isManagedRecord(record) || cfg.DeleteUnmanagedRecords) {
+			updatable = append(updatable, record)
+		}
+	}
+	return updatable
+}
+
+// maxExpiredRecordDeletes bounds how many expired records one Present
+// deletes, so that a zone full of them is cleaned up over several
+// challenges rather than in one long call.
+const maxExpiredRecordDeletes = 10
+
+// deleteExpiredRecords deletes the webhook's TXT records named recordName
+// whose value is not the challenge key and that were written longer than
+// cfg.StaleRecordMaxAge ago, and returns the records that are left. Unlike
+// deleteStaleRecords it leaves the fresh record of a concurrent challenge
+// for the same name alone, as well as records without a write time.
+func (c *bunnySolver) deleteExpiredRecords(client DNSZoneAPI, logger fieldLogger, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, records []bunny.DNSRecord, recordName string, zoneID int64) ([]bunny.DNSRecord, error) {
+	key := normalizeTXTValue(ch.Key)
+	cutoff := time.Now().Add(-cfg.StaleRecordMaxAge.Duration)
+	deleted := map[int64]bool{}
+	for _, record := range namedTXTRecords(records, recordName, ch.ResolvedZone, cfg.RecordNameFormat) {
+		if record.ID == nil || normalizeTXTValue(*record.Value) == key {
+			continue
+		}
+		written, ok := recordWritten(record)
+		if !ok || !written.Before(cutoff) {
+			continue
+		}
+		if len(deleted) == maxExpiredRecordDeletes {
+			logger.warning("more expired TXT records left, deleting them on the next challenge", field("deleted", len(deleted)))
+			break
+		}
+		if err := client.DeleteDNSRecord(context.Background(), zoneID, *record.ID); err != nil {
+			return nil, fmt.Errorf("failed to delete expired TXT record: %w", err)
+		}
+		logger.message(msgRecordWritten, "deleted expired TXT record", field("recordID", *record.ID), field("written", written.UTC()))
+		deleted[*record.ID] = true
+	}
+	return withoutRecords(records, deleted), nil
+}
+
+// warnAlteredRecord logs a warning for every field of the record bunny.net
+// created that differs from what was requested, such as a clamped TTL.
+// Fields missing from the response are not compared.
+func warnAlteredRecord(logger fieldLogger, requested *bunny.AddOrUpdateDNSRecordOptions, created *bunny.DNSRecord) {
+	if created == nil {
+		return
+	}
+	altered := func(name string, want, got interface{}) {
+		logger.warning("bunny.net altered the created TXT record", field("field", name),
+			field("requested", want), field("created", got))
+	}
+	if created.Type != nil && *created.Type != *requested.Type {
+		altered("type", *requested.Type, *created.Type)
+	}
+	if created.Name != nil && !strings.EqualFold(*created.Name, *requested.Name) {
+		altered("name", *requested.Name, *created.Name)
+	}
+	if created.Value != nil && *created.Value != *requested.Value {
+		altered("value", *requested.Value, *created.Value)
+	}
+	if created.TTL != nil && *created.TTL != *requested.TTL {
+		altered("ttl", *requested.TTL, *created.TTL)
+	}
+}
+
+// deleteMatchingRecords deletes the webhook's TXT records holding the
+// challenge key and returns the records that are left.
+func (c *bunnySolver) deleteMatchingRecords(client DNSZoneAPI, logger fieldLogger, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, records []bunny.DNSRecord, recordName string, zoneID int64) ([]bunny.DNSRecord, error) {
+	deleted := map[int64]bool{}
+	for _, record := range matchTXTRecords(records, recordName, ch.Key, ch.ResolvedZone, cfg.RecordNameFormat) {
+		if record.ID == nil {
+			continue
+		}
+		if !isManagedRecord(record) && !cfg.DeleteUnmanagedRecords {
+			logger.warning("not recreating TXT record without the webhook's marker", field("recordID", *record.ID))
+			continue
+		}
+		if err := client.DeleteDNSRecord(context.Background(), zoneID, *record.ID); err != nil {
+			return nil, fmt.Errorf("failed to delete TXT record to recreate it: %w", err)
+		}
+		logger.message(msgRecordWritten, "deleted TXT record to recreate it", field("recordID", *record.ID))
+		deleted[*record.ID] = true
+	}
+	return withoutRecords(records, deleted), nil
+}
+
+// withoutRecords returns records except those whose ID is in ids.
+func withoutRecords(records []bunny.DNSRecord, ids map[int64]bool) []bunny.DNSRecord {
+	var left []bunny.DNSRecord
+	for _, record := range records {
+		if record.ID == nil || !ids[*record.ID] {
+			left = append(left, record)
+		}
+	}
+	return left
+}
+
+// findTXTRecords returns all TXT records in the zone with the given name and
+// value.
+func (c *bunnySolver) findTXTRecords(client DNSZoneAPI, name, key, zoneName, format string, zoneId int64) ([]bunny.DNSRecord, error) {
+	records, err := c.zoneRecords(client, zoneId)
+	if err != nil {
+		return nil, err
+	}
+	return matchTXTRecords(records, name, key, zoneName, format), nil
+}
+
+// zoneRecords returns all records of the zone. bunny.net leaves Records
+// out for a zone without records, so the result is never nil for a zone
+// that was read: an empty zone is empty, not unknown.
+func (c *bunnySolver) zoneRecords(client DNSZoneAPI, zoneId int64) ([]bunny.DNSRecord, error) {
+	zone, err := client.Get(context.Background(), zoneId)
+	if err != nil {
+		if isNotFound(err) {
+			err = &zoneGoneError{zoneID: zoneId, err: err}
+		}
+		return nil, fmt.Errorf("error getting zone records: %w", err)
+	}
+	if zone == nil {
+		return nil, fmt.Errorf("error getting zone records: bunny.net returned no zone for ID %d", zoneId)
+	}
+	if zone.Records == nil {
+		zone.Records = []bunny.DNSRecord{}
+	}
+	zoneRecordCount.Observe(float64(len(zone.Records)))
+	c.warnLargeZone(zone)
+	return zone.Records, nil
+}
+
+// warnLargeZone warns once per zone when it holds more records than
+// largeZoneRecords. Every Present and CleanUp reads and scans the whole
+// zone, so a huge zone makes each of them slow and memory hungry.
+func (c *bunnySolver) warnLargeZone(zone *bunny.DNSZone) {
+	if c.largeZoneRecords <= 0 || len(zone.Records) <= int(c.largeZoneRecords) || zone.ID == nil {
+		return
+	}
+	if _, warned := c.largeZones.LoadOrStore(*zone.ID, true); warned {
+		return
+	}
+	rootLogger.warning("zone has many records, each challenge reads and scans all of them; consider delegating the challenge names with a CNAME to a small zone",
+		field("zone", *zone.ID), field("records", len(zone.Records)), field("threshold", c.largeZoneRecords))
+}
+
+// matchTXTRecords returns the TXT records with the given name and value.
+// format is the recordNameFormat the records were written with.
+func matchTXTRecords(records []bunny.DNSRecord, name, key, zoneName, format string) []bunny.DNSRecord {
+	var matches []bunny.DNSRecord
+	key = normalizeTXTValue(key)
+	for _, record := range namedTXTRecords(records, name, zoneName, format) {
+		if normalizeTXTValue(*record.Value) == key {
+			matches = append(matches, record)
+		}
+	}
+	return matches
+}
+
+// namedTXTRecords returns the TXT records with the given name, whatever
+// their value. Names are compared label by label, as isRecordNamed does.
+// The records are returned by value, so the result does not change along
+// with records.
+func namedTXTRecords(records []bunny.DNSRecord, name, zoneName, format string) []bunny.DNSRecord {
+	var matches []bunny.DNSRecord
+	for _, record := range records {
+		if record.Type == nil || record.Name == nil || record.Value == nil {
+			continue
+		}
+		if *record.Type == txtRecordType && isRecordNamed(*record.Name, name, zoneName, format) {
+			matches = append(matches, record)
+		}
+	}
+	return matches
+}
+
+// normalizeTXTValue returns a TXT value without surrounding whitespace or
+// quotes and with zone file escapes (\" or \DDD) resolved, so that values
+// bunny.net returns quoted or padded compare equal to the plain challenge
+// key. ACME keys never contain whitespace, so dropping it loses nothing.
+func normalizeTXTValue(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	value = value[1 : len(value)-1]
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		if i+2 < len(value) && isDigit(value[i]) && isDigit(value[i+1]) && isDigit(value[i+2]) {
+			n := int(value[i]-'0')*100 + int(value[i+1]-'0')*10 + int(value[i+2]-'0')
+			if n <= 255 {
+				b.WriteByte(byte(n))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// normalizeRecordName returns the name of a record bunny.net returned
+// from zoneName relative to the zone, lowercased and in punycode form, as
+// recordNameLabels derives it. The zone apex is returned as "". A fully
+// qualified name outside the zone is returned unchanged with its trailing
+// dot, so that it matches no relative name.
+func normalizeRecordName(name, zoneName string) string {
+	labels, ok := recordNameLabels(name, zoneName)
+	if !ok {
+		return name
+	}
+	return strings.Join(labels, ".")
+}
+
+// recordNameLabels returns the labels of a record name bunny.net returned
+// from zoneName, relative to the zone. bunny.net returns names relative to
+// the zone, so only a name with a trailing dot is fully qualified and has
+// the zone removed; a relative name that ends with the zone's name is a
+// record below the zone name repeated. It is false for a fully qualified
+// name outside the zone.
+func recordNameLabels(name, zoneName string) ([]string, bool) {
+	labels := nameLabels(name)
+	if !strings.HasSuffix(name, ".") || name == "." {
+		return labels, true
+	}
+	zone := nameLabels(zoneName)
+	if len(labels) < len(zone) || !sameLabels(labels[len(labels)-len(zone):], zone) {
+		return nil, false
+	}
+	return labels[:len(labels)-len(zone)], true
+}
+
+// nameLabels returns the labels of name, lowercased and in punycode form,
+// ignoring leading and trailing dots. "" and "@" have no labels.
+func nameLabels(name string) []string {
+	name = strings.ToLower(toASCIIName(strings.Trim(name, ".")))
+	if name == "" || name == "@" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+// isRecordNamed reports whether a record name bunny.net returned from
+// zoneName is name, which is relative to the zone. With recordNameFormat
+// fqdn the webhook sends names with the zone appended, so a record stored
+// under exactly that name is the record too.
+func isRecordNamed(recordName, name, zoneName, format string) bool {
+	got, ok := recordNameLabels(recordName, zoneName)
+	if !ok {
+		return false
+	}
+	want := nameLabels(name)
+	if sameLabels(got, want) {
+		return true
+	}
+	return format == recordNameFormatFQDN && sameLabels(got, append(want, nameLabels(zoneName)...))
+}
+
+// resolveZoneIds returns the IDs of the zones named zoneName. Unless
+// cfg.AllMatchingZones is set, only the first match is returned. Results
+// are cached unless cfg.DisableZoneCache is set.
+func (c *bunnySolver) resolveZoneIds(ctx context.Context, client DNSZoneAPI, zoneName string, cfg bunnyConfig) ([]int64, error) {
+	if cfg.DisableZoneCache {
+		return c.listZoneIds(ctx, client, zoneName, cfg)
+	}
+	return c.zones.resolve(ctx, newZoneCacheKey(client, zoneName, cfg), func() ([]int64, error) {
+		return c.listZoneIds(ctx, client, zoneName, cfg)
+	})
+}
+
+// listZoneIds resolves zoneName through the bunny.net API.
+//
+// An account without any zones is retried with backoff since it usually
+// means the zone was only just created and is not listable yet.
+func (c *bunnySolver) listZoneIds(ctx context.Context, client DNSZoneAPI, zoneName string, cfg bunnyConfig) ([]int64, error) {
+	retries := defaultEmptyZoneListRetries
+	if cfg.EmptyZoneListRetries != nil {
+		retries = *cfg.EmptyZoneListRetries
+	}
+	backoff := c.emptyZoneListBackoff
+	if backoff == 0 {
+		backoff = defaultEmptyZoneListBackoff
+	}
+	var visible int
+	for attempt := 0; ; attempt++ {
+		ids, scan, err := c.scanZones(client, zoneName, cfg)
+		if err != nil {
+			return nil, err
+		}
+		visible = scan.zones
+		zoneResolutionPages.Observe(float64(scan.pages))
+		zoneResolutionZonesScanned.Observe(float64(scan.zones))
+		rootLogger.message(msgZoneScan, "scanned zones", field("zone", zoneName), field("matches", len(ids)),
+			field("zonesScanned", scan.zones), field("pages", scan.pages))
+		if len(ids) > 0 {
+			return ids, nil
+		}
+		if scan.zones > 0 || attempt >= retries {
+			break
+		}
+		rootLogger.info("zone list is empty, retrying", field("zone", zoneName), field("backoff", backoff))
+		if err := sleepContext(ctx, backoff); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+	return nil, zoneNotFoundError(zoneName, visible)
+}
+
+// zoneNotFoundError says how many zones the access key can see, which tells
+// a mistyped zone name apart from a key of another, or an empty, account.
+func zoneNotFoundError(zoneName string, visible int) error {
+	if visible == 0 {
+		return fmt.Errorf("%w: %s (the access key sees no zones at all; check that it belongs to the bunny.net account hosting the zone)",
+			errZoneNotFound, zoneName)
+	}
+	return fmt.Errorf("%w: %s (not among the %d zones the access key sees)", errZoneNotFound, zoneName, visible)
+}
+
+// errZoneNotFound is returned when no zone on the account matches the
+// challenge's zone name.
+var errZoneNotFound = errors.New("failed to get zone id from zone name")
+
+// isNotFound reports whether err says the zone or record a bunny.net API
+// call named does not exist.
+func isNotFound(err error) bool {
+	d, ok := apiErrorDetails(err)
+	return ok && d.notFound()
+}
+
+// statusCode returns the HTTP status of a bunny.net API error, or 0 if err
+// is not one.
+func statusCode(err error) int {
+	d, _ := apiErrorDetails(err)
+	return d.Status
+}
+
+// zoneScan describes the work done by a zone scan.
+type zoneScan struct {
+	pages int
+	zones int
+}
+
+// scanZones lists all zones and returns the IDs of those named zoneName.
+// With skipFailedZonePages, pages that fail to load are skipped and
+// fetched again at the end if the zone was not found on the others, or
+// always when all matching zones are wanted.
+func (c *bunnySolver) scanZones(client DNSZoneAPI, zoneName string, cfg bunnyConfig) ([]int64, zoneScan, error) {
+	domain := toASCIIName(strings.TrimSuffix(zoneName, "."))
+	var ids []int64
+	var scan zoneScan
+	failed := map[int32]error{}
+	// match records the zones of a page and reports whether the scan can
+	// stop.
+	match := func(zones []*bunny.DNSZone) bool {
+		scan.pages++
+		scan.zones += len(zones)
+		for _, z := range zones {
+			if toASCIIName(*z.Domain) == domain {
+				ids = append(ids, *z.ID)
+				if !cfg.AllMatchingZones {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	it := listAllZones(client, c.zoneScanStartPage)
+	consecutiveFailures := 0
+	for {
+		page := it.page
+		zones, ok, err := it.next(context.Background())
+		if err != nil {
+			consecutiveFailures++
+			if !cfg.SkipFailedZonePages || consecutiveFailures >= maxConsecutiveFailedZonePages {
+				return nil, scan, err
+			}
+			rootLogger.warning("failed to list zone page, skipping it", field("zone", zoneName), field("page", page), field("error", err))
+			failed[page] = err
+			it.skip()
+			continue
+		}
+		consecutiveFailures = 0
+		if !ok {
+			break
+		}
+		if match(zones) {
+			return ids, scan, nil
+		}
+	}
+	if len(failed) > 0 && (len(ids) == 0 || cfg.AllMatchingZones) {
+		if err := retryFailedZonePages(it, zoneName, failed, match); err != nil {
+			return nil, scan, err
+		}
+	}
+	return ids, scan, nil
+}
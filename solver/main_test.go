@@ -0,0 +1,1445 @@
+package solver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/cert-manager/cert-manager/test/acme/dns"
+	bunny "github.com/simplesurance/bunny-go"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	zone = os.Getenv("TEST_ZONE_NAME")
+)
+
+func TestRunsSuite(t *testing.T) {
+	// The manifest path should contain a file named config.json that is a
+	// snippet of valid configuration that should be included on the
+	// ChallengeRequest passed as part of the test cases.
+
+	fixture := dns.NewFixture(&bunnySolver{},
+		dns.SetResolvedZone(zone),
+		dns.SetManifestPath("testdata/bunny"),
+		dns.SetDNSServer("9.9.9.9:53"),
+		dns.SetUseAuthoritative(false),
+	)
+
+	fixture.RunConformance(t)
+}
+
+// txtRecord returns a TXT record carrying the webhook's marker.
+func txtRecord(id int64, name, value string) bunny.DNSRecord {
+	record := unmanagedTXTRecord(id, name, value)
+	marker := recordMarker
+	record.Tag = &marker
+	return record
+}
+
+// unmanagedTXTRecord returns a TXT record as created by hand.
+func unmanagedTXTRecord(id int64, name, value string) bunny.DNSRecord {
+	recordType := 3
+	return bunny.DNSRecord{ID: &id, Type: &recordType, Name: &name, Value: &value}
+}
+
+func TestMatchTXTRecordsNameFormats(t *testing.T) {
+	tests := []struct {
+		name       string
+		recordName string
+		zone       string
+		format     string
+		stored     string
+	}{
+		{"relative", "_acme-challenge", "example.com.", "", "_acme-challenge"},
+		{"fqdn format", "_acme-challenge", "example.com.", recordNameFormatFQDN, "_acme-challenge.example.com"},
+		{"fqdn format stored relative", "_acme-challenge", "example.com.", recordNameFormatFQDN, "_acme-challenge"},
+		{"fqdn with trailing dot", "_acme-challenge", "example.com.", "", "_acme-challenge.example.com."},
+		{"uppercase", "_acme-challenge.sub", "example.com.", "", "_ACME-Challenge.Sub.Example.COM."},
+		{"relative name ending with the zone", "_acme-challenge.example.com", "example.com.", "", "_acme-challenge.example.com"},
+		{"computed with trailing dot", "_acme-challenge.", "example.com", "", "_acme-challenge"},
+		{"apex empty", "", "example.com.", "", ""},
+		{"apex at sign", "", "example.com.", "", "@"},
+		{"apex fqdn", "", "example.com.", "", "example.com."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := []bunny.DNSRecord{txtRecord(1, tt.stored, "key")}
+			matches := matchTXTRecords(records, tt.recordName, "key", tt.zone, tt.format)
+			if len(matches) != 1 {
+				t.Fatalf("record %q not matched against %q", tt.stored, tt.recordName)
+			}
+			if *matches[0].ID != 1 {
+				t.Errorf("got record ID %d, want 1", *matches[0].ID)
+			}
+		})
+	}
+}
+
+func TestMatchTXTRecordsNoMatch(t *testing.T) {
+	cnameType := 2
+	cname := txtRecord(2, "_acme-challenge", "key")
+	cname.Type = &cnameType
+	records := []bunny.DNSRecord{
+		txtRecord(1, "_acme-challenge.other", "key"),
+		txtRecord(3, "_acme-challenge", "other-key"),
+		cname,
+		{},
+		// Relative, so it is _acme-challenge.example.com.example.com.
+		txtRecord(4, "_acme-challenge.example.com", "key"),
+		txtRecord(5, "_acme-challenge.other.com.", "key"),
+	}
+	if matches := matchTXTRecords(records, "_acme-challenge", "key", "example.com.", ""); len(matches) != 0 {
+		t.Errorf("unexpected match for record ID %d", *matches[0].ID)
+	}
+}
+
+// fakeDNSZoneAPI is an in-memory stand-in for the bunny.net DNS zone API.
+type fakeDNSZoneAPI struct {
+	zones   []*bunny.DNSZone
+	perPage int
+	nextID  int64
+
+	listCalls   int
+	getCalls    int
+	addCalls    int
+	updateCalls int
+	deleteCalls int
+}
+
+func newFakeDNSZoneAPI(domains ...string) *fakeDNSZoneAPI {
+	api := &fakeDNSZoneAPI{nextID: 1000}
+	for i, domain := range domains {
+		id := int64(i + 1)
+		domain := domain
+		api.zones = append(api.zones, &bunny.DNSZone{ID: &id, Domain: &domain})
+	}
+	return api
+}
+
+func (f *fakeDNSZoneAPI) zone(id int64) *bunny.DNSZone {
+	for _, z := range f.zones {
+		if *z.ID == id {
+			return z
+		}
+	}
+	return nil
+}
+
+// errZoneGone is what bunny.net answers for a zone ID that does not exist.
+var errZoneGone = &bunny.HTTPError{StatusCode: http.StatusNotFound}
+
+func (f *fakeDNSZoneAPI) Get(ctx context.Context, id int64) (*bunny.DNSZone, error) {
+	f.getCalls++
+	z := f.zone(id)
+	if z == nil {
+		return nil, errZoneGone
+	}
+	cp := *z
+	cp.Records = append([]bunny.DNSRecord(nil), z.Records...)
+	return &cp, nil
+}
+
+func (f *fakeDNSZoneAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	f.listCalls++
+	perPage := f.perPage
+	if perPage == 0 {
+		perPage = int(opts.PerPage)
+	}
+	start := (int(opts.Page) - 1) * perPage
+	end := start + perPage
+	if start > len(f.zones) {
+		start = len(f.zones)
+	}
+	if end > len(f.zones) {
+		end = len(f.zones)
+	}
+	var items []*bunny.DNSZone
+	for _, z := range f.zones[start:end] {
+		cp := *z
+		cp.Records = nil
+		items = append(items, &cp)
+	}
+	page, total, hasMore := opts.Page, int32(len(f.zones)), end < len(f.zones)
+	return &bunny.DNSZones{Items: items, CurrentPage: &page, TotalItems: &total, HasMoreItems: &hasMore}, nil
+}
+
+func (f *fakeDNSZoneAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	f.addCalls++
+	z := f.zone(zoneID)
+	if z == nil {
+		return nil, errZoneGone
+	}
+	f.nextID++
+	id := f.nextID
+	record := bunny.DNSRecord{ID: &id, Type: opts.Type, TTL: opts.TTL, Name: opts.Name, Value: opts.Value, Tag: opts.Tag, MonitorType: opts.MonitorType}
+	z.Records = append(z.Records, record)
+	return &record, nil
+}
+
+func (f *fakeDNSZoneAPI) UpdateDNSRecord(ctx context.Context, zoneID int64, recordID int64, opts *bunny.AddOrUpdateDNSRecordOptions) error {
+	f.updateCalls++
+	z := f.zone(zoneID)
+	if z == nil {
+		return errZoneGone
+	}
+	for i, r := range z.Records {
+		if *r.ID == recordID {
+			z.Records[i].Value = opts.Value
+			z.Records[i].TTL = opts.TTL
+			z.Records[i].Tag = opts.Tag
+			z.Records[i].MonitorType = opts.MonitorType
+			return nil
+		}
+	}
+	return fmt.Errorf("record %d not found", recordID)
+}
+
+func (f *fakeDNSZoneAPI) DeleteDNSRecord(ctx context.Context, zoneID int64, recordID int64) error {
+	f.deleteCalls++
+	z := f.zone(zoneID)
+	if z == nil {
+		return errZoneGone
+	}
+	for i, r := range z.Records {
+		if *r.ID == recordID {
+			z.Records = append(z.Records[:i], z.Records[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("record %d not found", recordID)
+}
+
+// txtValues returns the sorted values of the TXT records in zone id.
+func (f *fakeDNSZoneAPI) txtValues(id int64) []string {
+	var values []string
+	for _, r := range f.zone(id).Records {
+		if *r.Type == txtRecordType {
+			values = append(values, *r.Value)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// newTestSolver returns a solver backed by api and a fake clientset that
+// holds the bunny-credentials secret.
+func newTestSolver(api DNSZoneAPI) *bunnySolver {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bunny-credentials", Namespace: "default"},
+		Data:       map[string][]byte{"accessKey": []byte("test-key")},
+	}
+	return &bunnySolver{
+		client:             fake.NewSimpleClientset(secret),
+		newClient:          func(string) DNSZoneAPI { return api },
+		apiRetryBackoff:    time.Millisecond,
+		maintenanceBackoff: time.Millisecond,
+		secretRetryBackoff: time.Millisecond,
+	}
+}
+
+// newChallenge returns a challenge for fqdn in zone with the given extra
+// solver config fields.
+func newChallenge(fqdn, zone, key, extraConfig string) *v1alpha1.ChallengeRequest {
+	cfg := `{"apiSecretRef":{"name":"bunny-credentials","key":"accessKey"}` + extraConfig + `}`
+	return &v1alpha1.ChallengeRequest{
+		UID:               "test-uid",
+		Key:               key,
+		ResourceNamespace: "default",
+		ResolvedFQDN:      fqdn,
+		ResolvedZone:      zone,
+		Config:            &extapi.JSON{Raw: []byte(cfg)},
+	}
+}
+
+func TestPresentAllMatchingZones(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		wantZones []int64
+	}{
+		{"first match only", "", []int64{2}},
+		{"all matching zones", `,"allMatchingZones":true`, []int64{2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("other.com", "example.com", "another.com", "example.com")
+			solver := newTestSolver(api)
+			ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config)
+
+			if err := solver.Present(ch); err != nil {
+				t.Fatal(err)
+			}
+			for _, z := range api.zones {
+				want := 0
+				for _, id := range tt.wantZones {
+					if id == *z.ID {
+						want = 1
+					}
+				}
+				if got := len(api.txtValues(*z.ID)); got != want {
+					t.Errorf("zone %d has %d TXT records, want %d", *z.ID, got, want)
+				}
+			}
+
+			if err := solver.CleanUp(ch); err != nil {
+				t.Fatal(err)
+			}
+			for _, z := range api.zones {
+				if got := len(api.txtValues(*z.ID)); got != 0 {
+					t.Errorf("zone %d has %d TXT records after cleanup", *z.ID, got)
+				}
+			}
+		})
+	}
+}
+
+func TestChallengeRecordName(t *testing.T) {
+	tests := []struct {
+		name    string
+		fqdn    string
+		zone    string
+		want    string
+		wantErr bool
+	}{
+		// Wildcard and apex certificates both validate through the
+		// _acme-challenge record directly below the domain.
+		{"wildcard", "_acme-challenge.example.com.", "example.com.", "_acme-challenge", false},
+		{"apex", "_acme-challenge.example.com.", "example.com.", "_acme-challenge", false},
+		{"subdomain", "_acme-challenge.www.example.com.", "example.com.", "_acme-challenge.www", false},
+		{"two levels", "_acme-challenge.a.b.example.com.", "example.com.", "_acme-challenge.a.b", false},
+		{"three levels", "_acme-challenge.a.b.c.example.com.", "example.com.", "_acme-challenge.a.b.c", false},
+		{"delegated subzone", "_acme-challenge.a.b.example.com.", "b.example.com.", "_acme-challenge.a", false},
+		{"multi-level wildcard", "_acme-challenge.*.example.com.", "example.com.", "_acme-challenge.*", false},
+		{"labels repeating the zone", "_acme-challenge.example.com.example.com.", "example.com.", "_acme-challenge.example.com", false},
+		{"label repeating the TLD", "_acme-challenge.com.example.com.", "example.com.", "_acme-challenge.com", false},
+		{"zone in other case", "_acme-challenge.www.Example.COM.", "example.com.", "_acme-challenge.www", false},
+		{"no trailing dots", "_acme-challenge.www.example.com", "example.com", "_acme-challenge.www", false},
+		{"zone without trailing dot", "_acme-challenge.www.example.com.", "example.com", "_acme-challenge.www", false},
+		{"zone longer than fqdn", "_acme-challenge.com.", "example.com.", "", true},
+		{"wildcard inside a label", "_acme-challenge.a*.example.com.", "example.com.", "", true},
+		{"fqdn is zone", "example.com.", "example.com.", "", true},
+		{"outside zone", "_acme-challenge.example.org.", "example.com.", "", true},
+		{"suffix without label boundary", "_acme-challenge.myexample.com.", "example.com.", "", true},
+		{"63 character label", "_acme-challenge." + strings.Repeat("a", 63) + ".example.com.", "example.com.", "_acme-challenge." + strings.Repeat("a", 63), false},
+		{"label too long", "_acme-challenge." + strings.Repeat("a", 64) + ".example.com.", "example.com.", "", true},
+		{"name too long", "_acme-challenge." + strings.Repeat(strings.Repeat("a", 60)+".", 4) + "example.com.", "example.com.", "", true},
+		{"empty label", "_acme-challenge..www.example.com.", "example.com.", "", true},
+		{"invalid character", "_acme-challenge.w w.example.com.", "example.com.", "", true},
+		{"non-ASCII character", "_acme-challenge.bücher.example.com.", "example.com.", "", true},
+		{"leading hyphen", "_acme-challenge.-www.example.com.", "example.com.", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := challengeRecordName(tt.fqdn, tt.zone)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPresentNestedNames(t *testing.T) {
+	for _, fqdn := range []string{
+		"_acme-challenge.a.b.example.com.",
+		"_acme-challenge.a.b.c.example.com.",
+		"_acme-challenge.*.example.com.",
+		"_acme-challenge.example.com.example.com.",
+	} {
+		t.Run(fqdn, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			solver := newTestSolver(api)
+			ch := newChallenge(fqdn, "example.com.", "key", "")
+			if err := solver.Present(ch); err != nil {
+				t.Fatal(err)
+			}
+			want := strings.TrimSuffix(fqdn, ".example.com.")
+			if records := api.zones[0].Records; len(records) != 1 || *records[0].Name != want {
+				t.Fatalf("records = %+v, want one named %q", records, want)
+			}
+			if err := solver.CleanUp(ch); err != nil {
+				t.Fatal(err)
+			}
+			if len(api.zones[0].Records) != 0 {
+				t.Errorf("records left after CleanUp: %+v", api.zones[0].Records)
+			}
+		})
+	}
+}
+
+func TestPresentRejectsInvalidRecordName(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	err := newTestSolver(api).Present(newChallenge("_acme-challenge."+strings.Repeat("a", 64)+".example.com.", "example.com.", "key", ""))
+	if err == nil || !strings.Contains(err.Error(), "the maximum is 63") {
+		t.Fatalf("got error %v, want a label length error", err)
+	}
+	if api.listCalls != 0 || api.addCalls != 0 {
+		t.Errorf("API called %d/%d times for an invalid name, want none", api.listCalls, api.addCalls)
+	}
+}
+
+func TestPresentRecordNameDepth(t *testing.T) {
+	deep := "_acme-challenge." + strings.Repeat("a.", 10) + "example.com."
+	tests := []struct {
+		name    string
+		fqdn    string
+		config  string
+		wantErr bool
+	}{
+		{"usual depth", "_acme-challenge.www.example.com.", "", false},
+		{"at the default limit", "_acme-challenge." + strings.Repeat("a.", 9) + "example.com.", "", false},
+		{"beyond the default limit", deep, "", true},
+		{"beyond a configured limit", "_acme-challenge.a.b.example.com.", `,"maxRecordNameLabels":2`, true},
+		{"limit disabled", deep, `,"maxRecordNameLabels":0`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			err := newTestSolver(api).Present(newChallenge(tt.fqdn, "example.com.", "key", tt.config))
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "maxRecordNameLabels") {
+				t.Fatalf("got error %v, want a depth error", err)
+			}
+			if api.listCalls != 0 || api.addCalls != 0 {
+				t.Errorf("API called %d/%d times for a too deep name, want none", api.listCalls, api.addCalls)
+			}
+		})
+	}
+}
+
+func TestPresentChallengeRecordLimit(t *testing.T) {
+	existing := []bunny.DNSRecord{
+		txtRecord(10, "_acme-challenge.a", "key-a"),
+		unmanagedTXTRecord(11, "_acme-challenge.b", "key-b"),
+		txtRecord(12, "www", "not a challenge"),
+	}
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"no limit", "", false},
+		{"under the limit", `,"maxChallengeRecordsPerZone":3`, false},
+		{"at the limit", `,"maxChallengeRecordsPerZone":2`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			api.zones[0].Records = append([]bunny.DNSRecord(nil), existing...)
+			err := newTestSolver(api).Present(newChallenge("_acme-challenge.c.example.com.", "example.com.", "key", tt.config))
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if api.addCalls != 1 {
+					t.Errorf("Present made %d adds, want 1", api.addCalls)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "maxChallengeRecordsPerZone") {
+				t.Fatalf("got error %v, want a limit error", err)
+			}
+			if api.addCalls != 0 {
+				t.Errorf("Present made %d adds over the limit, want none", api.addCalls)
+			}
+		})
+	}
+}
+
+func TestPresentChallengeRecordLimitAllowsPresentRecord(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	api.zones[0].Records = []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "key")}
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"maxChallengeRecordsPerZone":1`)
+	if err := newTestSolver(api).Present(ch); err != nil {
+		t.Fatalf("Present of a record that is already there failed: %v", err)
+	}
+}
+
+func TestPresentRejectsFQDNOutsideZone(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	ch := newChallenge("_acme-challenge.example.org.", "example.com.", "key", "")
+	if err := solver.Present(ch); err == nil {
+		t.Fatal("expected error for FQDN outside of zone")
+	}
+	if api.addCalls != 0 {
+		t.Errorf("AddDNSRecord called %d times, want 0", api.addCalls)
+	}
+}
+
+// emptyListAPI returns an empty zone list for the first emptyLists calls.
+type emptyListAPI struct {
+	*fakeDNSZoneAPI
+	emptyLists int
+}
+
+func (e *emptyListAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	if e.emptyLists > 0 {
+		e.emptyLists--
+		e.listCalls++
+		hasMore := false
+		return &bunny.DNSZones{HasMoreItems: &hasMore}, nil
+	}
+	return e.fakeDNSZoneAPI.List(ctx, opts)
+}
+
+func TestResolveZoneIdsRetriesEmptyList(t *testing.T) {
+	tests := []struct {
+		name       string
+		emptyLists int
+		config     bunnyConfig
+		wantErr    bool
+		wantCalls  int
+	}{
+		{"zone appears after retries", 2, bunnyConfig{}, false, 3},
+		{"retries exhausted", 5, bunnyConfig{}, true, 4},
+		{"retries disabled", 1, bunnyConfig{EmptyZoneListRetries: new(int)}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &emptyListAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), emptyLists: tt.emptyLists}
+			solver := &bunnySolver{emptyZoneListBackoff: time.Millisecond}
+			ids, err := solver.resolveZoneIds(context.Background(), api, "example.com.", tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (len(ids) != 1 || ids[0] != 1) {
+				t.Errorf("got zone IDs %v, want [1]", ids)
+			}
+			if api.listCalls != tt.wantCalls {
+				t.Errorf("List called %d times, want %d", api.listCalls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestResolveZoneIdsDoesNotRetryMissingZone(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.org")
+	solver := &bunnySolver{emptyZoneListBackoff: time.Millisecond}
+	if _, err := solver.resolveZoneIds(context.Background(), api, "example.com.", bunnyConfig{}); err == nil {
+		t.Fatal("expected not found error")
+	}
+	if api.listCalls != 1 {
+		t.Errorf("List called %d times, want 1", api.listCalls)
+	}
+}
+
+func TestResolveZoneIdsStartPage(t *testing.T) {
+	api := newFakeDNSZoneAPI("a.com", "b.com", "c.com", "d.com", "e.com", "f.com", "example.com")
+	solver := &bunnySolver{zoneScanStartPage: 3}
+	ids, err := solver.resolveZoneIds(context.Background(), api, "example.com.", bunnyConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != 7 {
+		t.Errorf("got zone IDs %v, want [7]", ids)
+	}
+	if api.listCalls != 1 {
+		t.Errorf("List called %d times, want 1", api.listCalls)
+	}
+	if _, err := solver.resolveZoneIds(context.Background(), api, "a.com.", bunnyConfig{}); err == nil {
+		t.Error("found a zone listed before the start page")
+	}
+}
+
+func TestFindTXTRecordsMultipleMatches(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	api.zones[0].Records = []bunny.DNSRecord{
+		txtRecord(10, "_acme-challenge", "key"),
+		txtRecord(11, "_acme-challenge", "other-key"),
+		txtRecord(12, "_acme-challenge.example.com.", "key"),
+		// _acme-challenge.example.com.example.com., not the challenge name.
+		txtRecord(13, "_acme-challenge.example.com", "key"),
+	}
+	solver := newTestSolver(api)
+	records, err := solver.findTXTRecords(api, "_acme-challenge", "key", "example.com.", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, r := range records {
+		ids = append(ids, *r.ID)
+	}
+	if len(ids) != 2 || ids[0] != 10 || ids[1] != 12 {
+		t.Errorf("got record IDs %v, want [10 12]", ids)
+	}
+}
+
+func TestPresentSkipsWhenAnyRecordMatches(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	api.zones[0].Records = []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "key")}
+	solver := newTestSolver(api)
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	if api.addCalls != 0 {
+		t.Errorf("AddDNSRecord called %d times, want 0", api.addCalls)
+	}
+}
+
+func TestCleanUpDeletesAllMatchingRecords(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	api.zones[0].Records = []bunny.DNSRecord{
+		txtRecord(10, "_acme-challenge", "key"),
+		txtRecord(11, "_acme-challenge", "other-key"),
+		txtRecord(12, "_acme-challenge", "key"),
+	}
+	solver := newTestSolver(api)
+	if err := solver.CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.txtValues(1); len(got) != 1 || got[0] != "other-key" {
+		t.Errorf("remaining TXT values %v, want [other-key]", got)
+	}
+}
+
+// TestWildcardAndBaseChallenges covers a certificate for example.com and
+// *.example.com, whose two challenges share one record name.
+func TestWildcardAndBaseChallenges(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	base := newChallenge("_acme-challenge.example.com.", "example.com.", "base-key", "")
+	wildcard := newChallenge("_acme-challenge.example.com.", "example.com.", "wildcard-key", "")
+	wildcard.UID = "wildcard-uid"
+	wildcard.DNSName = "*.example.com"
+	for _, ch := range []*v1alpha1.ChallengeRequest{base, wildcard, base} {
+		if err := solver.Present(ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := api.txtValues(1); len(got) != 2 || got[0] != "base-key" || got[1] != "wildcard-key" {
+		t.Fatalf("TXT values after presenting both %v, want [base-key wildcard-key]", got)
+	}
+	if err := solver.CleanUp(base); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.txtValues(1); len(got) != 1 || got[0] != "wildcard-key" {
+		t.Fatalf("TXT values after cleaning up the base challenge %v, want [wildcard-key]", got)
+	}
+	if err := solver.CleanUp(wildcard); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.txtValues(1); len(got) != 0 {
+		t.Errorf("TXT values after cleaning up both %v, want none", got)
+	}
+}
+
+func TestCleanUpWithoutMatchingRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []bunny.DNSRecord
+	}{
+		{"no records", nil},
+		{"other name", []bunny.DNSRecord{txtRecord(10, "_acme-challenge.www", "key")}},
+		{"same name, other value", []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "other-key")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			api.zones[0].Records = tt.records
+			solver := newTestSolver(api)
+			if err := solver.CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+				t.Fatal(err)
+			}
+			if api.deleteCalls != 0 {
+				t.Errorf("DeleteDNSRecord called %d times, want 0", api.deleteCalls)
+			}
+			if len(api.zones[0].Records) != len(tt.records) {
+				t.Errorf("%d records left, want %d", len(api.zones[0].Records), len(tt.records))
+			}
+		})
+	}
+}
+
+func TestPresentUpdateStaleRecords(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		records     []bunny.DNSRecord
+		wantAdds    int
+		wantUpdates int
+		wantValues  []string
+	}{
+		{"stale record, update disabled", "", []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "stale")}, 1, 0, []string{"key", "stale"}},
+		{"stale record, update enabled", `,"updateStaleRecords":true`, []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "stale")}, 0, 1, []string{"key"}},
+		{"no stale record, update enabled", `,"updateStaleRecords":true`, []bunny.DNSRecord{txtRecord(10, "_acme-challenge.www", "other")}, 1, 0, []string{"key", "other"}},
+		{"current record, update enabled", `,"updateStaleRecords":true`, []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "key")}, 0, 0, []string{"key"}},
+		{"unmanaged stale record, update enabled", `,"updateStaleRecords":true`,
+			[]bunny.DNSRecord{unmanagedTXTRecord(10, "_acme-challenge", "manual")}, 1, 0, []string{"key", "manual"}},
+		{"managed record updated, unmanaged kept", `,"updateStaleRecords":true`,
+			[]bunny.DNSRecord{unmanagedTXTRecord(10, "_acme-challenge", "manual"), txtRecord(11, "_acme-challenge", "stale")}, 0, 1, []string{"key", "manual"}},
+		{"unmanaged stale record, unmanaged records allowed", `,"updateStaleRecords":true,"deleteUnmanagedRecords":true`,
+			[]bunny.DNSRecord{unmanagedTXTRecord(10, "_acme-challenge", "manual")}, 0, 1, []string{"key"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			api.zones[0].Records = tt.records
+			solver := newTestSolver(api)
+			if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config)); err != nil {
+				t.Fatal(err)
+			}
+			if api.addCalls != tt.wantAdds || api.updateCalls != tt.wantUpdates {
+				t.Errorf("got %d adds and %d updates, want %d and %d", api.addCalls, api.updateCalls, tt.wantAdds, tt.wantUpdates)
+			}
+			if got := api.txtValues(1); fmt.Sprint(got) != fmt.Sprint(tt.wantValues) {
+				t.Errorf("got TXT values %v, want %v", got, tt.wantValues)
+			}
+		})
+	}
+}
+
+func TestPresentDeleteStaleRecords(t *testing.T) {
+	stale := func() []bunny.DNSRecord {
+		return []bunny.DNSRecord{
+			txtRecord(10, "_acme-challenge", "stale-1"),
+			txtRecord(11, "_acme-challenge", "stale-2"),
+			txtRecord(12, "_acme-challenge.www", "other"),
+		}
+	}
+	tests := []struct {
+		name        string
+		config      string
+		records     []bunny.DNSRecord
+		wantDeletes int
+		wantValues  []string
+	}{
+		{"disabled", "", stale(), 0, []string{"key", "other", "stale-1", "stale-2"}},
+		{"stale records deleted before adding", `,"deleteStaleRecords":true`, stale(), 2, []string{"key", "other"}},
+		{"stale records deleted when current record exists", `,"deleteStaleRecords":true`,
+			append(stale(), txtRecord(13, "_acme-challenge", "key")), 2, []string{"key", "other"}},
+		{"one stale record kept for update", `,"deleteStaleRecords":true,"updateStaleRecords":true`, stale(), 1, []string{"key", "other"}},
+		{"unmanaged stale record kept", `,"deleteStaleRecords":true`,
+			[]bunny.DNSRecord{unmanagedTXTRecord(10, "_acme-challenge", "manual")}, 0, []string{"key", "manual"}},
+		{"managed stale record kept for update", `,"deleteStaleRecords":true,"updateStaleRecords":true`,
+			[]bunny.DNSRecord{unmanagedTXTRecord(10, "_acme-challenge", "manual"), txtRecord(11, "_acme-challenge", "stale")},
+			0, []string{"key", "manual"}},
+		{"relative name ending with the zone kept", `,"deleteStaleRecords":true`,
+			[]bunny.DNSRecord{txtRecord(10, "_acme-challenge.example.com", "nested")}, 0, []string{"key", "nested"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			api.zones[0].Records = tt.records
+			solver := newTestSolver(api)
+			if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config)); err != nil {
+				t.Fatal(err)
+			}
+			if api.deleteCalls != tt.wantDeletes {
+				t.Errorf("DeleteDNSRecord called %d times, want %d", api.deleteCalls, tt.wantDeletes)
+			}
+			if got := api.txtValues(1); fmt.Sprint(got) != fmt.Sprint(tt.wantValues) {
+				t.Errorf("got TXT values %v, want %v", got, tt.wantValues)
+			}
+		})
+	}
+}
+
+// writtenTXTRecord returns a TXT record the webhook wrote age ago.
+func writtenTXTRecord(id int64, name, value string, age time.Duration) bunny.DNSRecord {
+	record := unmanagedTXTRecord(id, name, value)
+	tag := recordMarkerTag(time.Now().Add(-age))
+	record.Tag = &tag
+	return record
+}
+
+func TestPresentDeletesExpiredRecords(t *testing.T) {
+	records := func() []bunny.DNSRecord {
+		return []bunny.DNSRecord{
+			writtenTXTRecord(10, "_acme-challenge", "expired", 2*time.Hour),
+			writtenTXTRecord(11, "_acme-challenge", "sibling", time.Minute),
+			txtRecord(12, "_acme-challenge", "untimed"),
+			unmanagedTXTRecord(13, "_acme-challenge", "manual"),
+			writtenTXTRecord(14, "_acme-challenge.www", "other-name", 2*time.Hour),
+			writtenTXTRecord(15, "_acme-challenge", "key", 2*time.Hour),
+		}
+	}
+	tests := []struct {
+		name       string
+		config     string
+		wantValues []string
+	}{
+		{"disabled", "", []string{"expired", "key", "manual", "other-name", "sibling", "untimed"}},
+		{"expired record deleted", `,"staleRecordMaxAge":"1h"`, []string{"key", "manual", "other-name", "sibling", "untimed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			api.zones[0].Records = records()
+			solver := newTestSolver(api)
+			if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config)); err != nil {
+				t.Fatal(err)
+			}
+			if got := api.txtValues(1); fmt.Sprint(got) != fmt.Sprint(tt.wantValues) {
+				t.Errorf("got TXT values %v, want %v", got, tt.wantValues)
+			}
+		})
+	}
+}
+
+func TestPresentBoundsExpiredRecordDeletes(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	for i := 0; i < maxExpiredRecordDeletes+5; i++ {
+		api.zones[0].Records = append(api.zones[0].Records,
+			writtenTXTRecord(int64(10+i), "_acme-challenge", fmt.Sprintf("expired-%d", i), 2*time.Hour))
+	}
+	solver := newTestSolver(api)
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"staleRecordMaxAge":"1h"`)); err != nil {
+		t.Fatal(err)
+	}
+	if api.deleteCalls != maxExpiredRecordDeletes {
+		t.Errorf("DeleteDNSRecord called %d times, want %d", api.deleteCalls, maxExpiredRecordDeletes)
+	}
+}
+
+func TestPresentDisablesMonitoring(t *testing.T) {
+	for _, update := range []bool{false, true} {
+		api := newFakeDNSZoneAPI("example.com")
+		api.zones[0].Records = []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "old-key")}
+		solver := newTestSolver(api)
+		extra := fmt.Sprintf(`,"updateStaleRecords":%v`, update)
+		if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", extra)); err != nil {
+			t.Fatal(err)
+		}
+		written := 0
+		for _, r := range api.zones[0].Records {
+			if *r.Value != "key" {
+				continue
+			}
+			written++
+			if r.MonitorType == nil || *r.MonitorType != monitorTypeNone {
+				t.Errorf("updateStaleRecords %v: record written with MonitorType %v, want %d", update, r.MonitorType, monitorTypeNone)
+			}
+		}
+		if written != 1 {
+			t.Errorf("updateStaleRecords %v: %d records hold the key, want 1", update, written)
+		}
+	}
+}
+
+func TestPresentRecreateRecords(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	api.zones[0].Records = []bunny.DNSRecord{
+		txtRecord(10, "_acme-challenge", "key"),
+		txtRecord(11, "_acme-challenge", "key"),
+	}
+	solver := newTestSolver(api)
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"recreateRecords":true`)
+	// Retried presents must keep exactly one fresh record.
+	for i := 1; i <= 3; i++ {
+		if err := solver.Present(ch); err != nil {
+			t.Fatal(err)
+		}
+		if api.addCalls != i {
+			t.Errorf("after present %d: AddDNSRecord called %d times, want %d", i, api.addCalls, i)
+		}
+		records := api.zones[0].Records
+		if len(records) != 1 || *records[0].ID <= 11 {
+			t.Errorf("after present %d: got records %v, want one new record", i, records)
+		}
+	}
+}
+
+func TestPresentRecreateKeepsUnmanagedRecord(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	api.zones[0].Records = []bunny.DNSRecord{unmanagedTXTRecord(10, "_acme-challenge", "key")}
+	solver := newTestSolver(api)
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"recreateRecords":true`)); err != nil {
+		t.Fatal(err)
+	}
+	if api.deleteCalls != 0 || api.addCalls != 0 {
+		t.Errorf("got %d deletes and %d adds, want the unmanaged record left alone", api.deleteCalls, api.addCalls)
+	}
+}
+
+func TestPresentStrictChallengeKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"acme key", "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0", false},
+		{"arbitrary text", "v=spf1 include:evil.example -all", true},
+		{"padded base64", "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0=", true},
+		{"too short", "123d==", true},
+		{"standard base64 alphabet", "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAj+uX/", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			solver := newTestSolver(api)
+			solver.strictChallengeKeys = true
+			err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", tt.key, ""))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if wantAdds := map[bool]int{false: 1, true: 0}[tt.wantErr]; api.addCalls != wantAdds {
+				t.Errorf("AddDNSRecord called %d times, want %d", api.addCalls, wantAdds)
+			}
+		})
+	}
+}
+
+func TestPresentAllowsArbitraryKeysByDefault(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	if err := newTestSolver(api).Present(newChallenge("_acme-challenge.example.com.", "example.com.", "123d==", "")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPresentLogsCreatedRecordID(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := newFakeDNSZoneAPI("example.com")
+	if err := newTestSolver(api).Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("[test-uid] added TXT record namespace=default zone=1 record=_acme-challenge recordID=%d value=key", *api.zones[0].Records[0].ID)
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("log %q does not contain %q", buf.String(), want)
+	}
+}
+
+// clampingAPI returns created records with their TTL raised to minTTL, as
+// a provider enforcing a minimum would.
+type clampingAPI struct {
+	*fakeDNSZoneAPI
+	minTTL int32
+}
+
+func (c *clampingAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	created, err := c.fakeDNSZoneAPI.AddDNSRecord(ctx, zoneID, opts)
+	if err == nil && *created.TTL < c.minTTL {
+		ttl := c.minTTL
+		created.TTL = &ttl
+	}
+	return created, err
+}
+
+func TestPresentWarnsAboutAlteredRecord(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := &clampingAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), minTTL: 300}
+	solver := newTestSolver(api)
+	solver.minTTL, solver.maxTTL = 60, 3600
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"ttl":120`)); err != nil {
+		t.Fatal(err)
+	}
+	want := "warning: bunny.net altered the created TXT record namespace=default zone=1 record=_acme-challenge field=ttl requested=120 created=300"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("log %q does not contain %q", buf.String(), want)
+	}
+	if strings.Count(buf.String(), "altered") != 1 {
+		t.Errorf("log %q warns about more than the TTL", buf.String())
+	}
+}
+
+// recordingAPI keeps the options of every record written.
+type recordingAPI struct {
+	*fakeDNSZoneAPI
+	written []bunny.AddOrUpdateDNSRecordOptions
+}
+
+func (r *recordingAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	r.written = append(r.written, *opts)
+	return r.fakeDNSZoneAPI.AddDNSRecord(ctx, zoneID, opts)
+}
+
+func TestPresentSendsExplicitRecordOptions(t *testing.T) {
+	api := &recordingAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com")}
+	solver := newTestSolver(api)
+	solver.minTTL, solver.maxTTL = 60, 3600
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"ttl":120,"recordWeight":10`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.written) != 1 {
+		t.Fatalf("wrote %d records, want 1", len(api.written))
+	}
+	got := api.written[0]
+	if got.Tag == nil || !isManagedRecord(bunny.DNSRecord{Tag: got.Tag}) {
+		t.Fatalf("record written with tag %v, want the marker", got.Tag)
+	}
+	weight := int32(10)
+	want := txtRecordOptions(bunnyConfig{RecordWeight: &weight}, "_acme-challenge", "key", 120, *got.Tag)
+	if !reflect.DeepEqual(&got, want) {
+		t.Errorf("record written with %+v, want %+v", got, want)
+	}
+}
+
+func TestNormalizeTXTValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{`abc`, `abc`},
+		{`"abc"`, `abc`},
+		{`"a\"b"`, `a"b`},
+		{`"a\\b"`, `a\b`},
+		{`"a\065b"`, `aAb`},
+		{`"a\999"`, `a999`},
+		{`"`, `"`},
+		{`""`, ``},
+		{`"abc`, `"abc`},
+		{`a"b"`, `a"b"`},
+		{" abc\n", `abc`},
+		{` "abc" `, `abc`},
+		{`" abc "`, `abc`},
+		{"\t\"a\\\"b\"", `a"b`},
+	}
+	for _, tt := range tests {
+		if got := normalizeTXTValue(tt.value); got != tt.want {
+			t.Errorf("normalizeTXTValue(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPresentAndCleanUpWhitespacePaddedValues(t *testing.T) {
+	key := "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+	tests := []struct {
+		name   string
+		stored string
+		key    string
+	}{
+		{"padded stored value", " " + key + "\n", key},
+		{"padded quoted stored value", ` "` + key + `" `, key},
+		{"padded key", key, "  " + key + " "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			api.zones[0].Records = []bunny.DNSRecord{txtRecord(10, "_acme-challenge", tt.stored)}
+			solver := newTestSolver(api)
+			ch := newChallenge("_acme-challenge.example.com.", "example.com.", tt.key, "")
+			if err := solver.Present(ch); err != nil {
+				t.Fatal(err)
+			}
+			if api.addCalls != 0 {
+				t.Errorf("Present added %d records next to the padded one, want none", api.addCalls)
+			}
+			if err := solver.CleanUp(ch); err != nil {
+				t.Fatal(err)
+			}
+			if len(api.zones[0].Records) != 0 {
+				t.Errorf("%d records left after CleanUp, want 0", len(api.zones[0].Records))
+			}
+		})
+	}
+}
+
+func TestPresentWritesTrimmedValue(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	if err := newTestSolver(api).Present(newChallenge("_acme-challenge.example.com.", "example.com.", " key\t", "")); err != nil {
+		t.Fatal(err)
+	}
+	if values := api.txtValues(1); len(values) != 1 || values[0] != "key" {
+		t.Errorf("wrote %q, want the trimmed key", values)
+	}
+}
+
+func TestMatchTXTRecordsQuotedValues(t *testing.T) {
+	key := "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0"
+	for _, stored := range []string{key, `"` + key + `"`} {
+		records := []bunny.DNSRecord{txtRecord(1, "_acme-challenge", stored)}
+		if matches := matchTXTRecords(records, "_acme-challenge", key, "example.com.", ""); len(matches) != 1 {
+			t.Errorf("stored value %q not matched against key", stored)
+		}
+	}
+}
+
+// TestMatchingRecordsAreDistinct collects several matching records and
+// checks that each result is its own record rather than an alias of one
+// loop variable or of the zone's records.
+func TestMatchingRecordsAreDistinct(t *testing.T) {
+	records := []bunny.DNSRecord{
+		txtRecord(1, "_acme-challenge", "key"),
+		txtRecord(2, "_acme-challenge", "other-key"),
+		txtRecord(3, "_acme-challenge.www", "key"),
+		txtRecord(4, "_acme-challenge", `"key"`),
+	}
+	check := func(name string, got []bunny.DNSRecord, wantIDs []int64, wantValues []string) {
+		t.Helper()
+		if len(got) != len(wantIDs) {
+			t.Fatalf("%s returned %d records, want %d", name, len(got), len(wantIDs))
+		}
+		for i, r := range got {
+			if *r.ID != wantIDs[i] || *r.Value != wantValues[i] {
+				t.Errorf("%s record %d is ID %d with %q, want ID %d with %q", name, i, *r.ID, *r.Value, wantIDs[i], wantValues[i])
+			}
+		}
+	}
+	matches := matchTXTRecords(records, "_acme-challenge", "key", "example.com.", "")
+	named := namedTXTRecords(records, "_acme-challenge", "example.com.", "")
+	// Replacing the zone's records must not change what was collected.
+	for i := range records {
+		records[i] = txtRecord(99, "replaced", "replaced")
+	}
+	check("matchTXTRecords", matches, []int64{1, 4}, []string{"key", `"key"`})
+	check("namedTXTRecords", named, []int64{1, 2, 4}, []string{"key", "other-key", `"key"`})
+}
+
+func TestCleanUpSkipped(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	api.zones[0].Records = []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "key")}
+	solver := newTestSolver(api)
+	solver.skipCleanUp = true
+	solver.client = nil
+	if err := solver.CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	if calls := api.listCalls + api.getCalls + api.addCalls + api.updateCalls + api.deleteCalls; calls != 0 {
+		t.Errorf("CleanUp made %d API calls, want none", calls)
+	}
+	if len(api.zones[0].Records) != 1 {
+		t.Errorf("%d records left, want the challenge record", len(api.zones[0].Records))
+	}
+}
+
+func TestCleanUpDeletesQuotedRecord(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	api.zones[0].Records = []bunny.DNSRecord{txtRecord(10, "_acme-challenge", `"key"`)}
+	if err := newTestSolver(api).CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	if api.deleteCalls != 1 {
+		t.Errorf("DeleteDNSRecord called %d times, want 1", api.deleteCalls)
+	}
+}
+
+func TestCleanUpZoneDeleted(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.org")
+	solver := newTestSolver(api)
+	if err := solver.CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatalf("CleanUp of a deleted zone returned %v, want nil", err)
+	}
+	if api.deleteCalls != 0 {
+		t.Errorf("DeleteDNSRecord called %d times, want 0", api.deleteCalls)
+	}
+}
+
+func TestCleanUpCachedZoneDeleted(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	solver.emptyZoneListBackoff = 1
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	// The zone ID is still cached from Present, and resolving it again
+	// finds an empty account.
+	api.zones = nil
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatalf("CleanUp of a deleted zone returned %v, want nil", err)
+	}
+}
+
+// failingListAPI fails every zone listing.
+type failingListAPI struct {
+	*fakeDNSZoneAPI
+}
+
+func (f *failingListAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	return nil, &bunny.HTTPError{StatusCode: http.StatusBadGateway}
+}
+
+func TestCleanUpListFailure(t *testing.T) {
+	api := &failingListAPI{newFakeDNSZoneAPI("example.com")}
+	solver := newTestSolver(api)
+	if err := solver.CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err == nil {
+		t.Fatal("CleanUp succeeded despite failing to list zones")
+	}
+}
+
+func TestCleanUpRefusesMassDelete(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		wantErr  bool
+		wantLeft int
+	}{
+		{"above the default limit", "", true, 25},
+		{"override", `,"allowMassDelete":true`, false, 0},
+		{"limit disabled", `,"maxRecordsPerName":0`, false, 0},
+		{"raised limit", `,"maxRecordsPerName":30`, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			for i := 0; i < 25; i++ {
+				api.zones[0].Records = append(api.zones[0].Records, txtRecord(int64(10+i), "_acme-challenge", "key"))
+			}
+			solver := newTestSolver(api)
+			err := solver.CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CleanUp() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := len(api.txtValues(1)); got != tt.wantLeft {
+				t.Errorf("%d TXT records left, want %d", got, tt.wantLeft)
+			}
+			if tt.wantErr && api.deleteCalls != 0 {
+				t.Errorf("%d records deleted despite refusing", api.deleteCalls)
+			}
+		})
+	}
+}
+
+func TestCleanUpSkipsUnmanagedRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		wantLeft []int64
+	}{
+		{"unmanaged record kept", "", []int64{11}},
+		{"unmanaged record deleted when allowed", `,"deleteUnmanagedRecords":true`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			api.zones[0].Records = []bunny.DNSRecord{
+				txtRecord(10, "_acme-challenge", "key"),
+				unmanagedTXTRecord(11, "_acme-challenge", "key"),
+			}
+			solver := newTestSolver(api)
+			if err := solver.CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config)); err != nil {
+				t.Fatal(err)
+			}
+			var left []int64
+			for _, r := range api.zones[0].Records {
+				left = append(left, *r.ID)
+			}
+			if fmt.Sprint(left) != fmt.Sprint(tt.wantLeft) {
+				t.Errorf("remaining records %v, want %v", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestPresentMarksRecords(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	if err := newTestSolver(api).Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	if !isManagedRecord(api.zones[0].Records[0]) {
+		t.Error("created record does not carry the webhook's marker")
+	}
+}
+
+// blockingAPI holds every zone lookup until release is closed and tracks
+// how many run at once. It serializes the calls into the fake.
+type blockingAPI struct {
+	*fakeDNSZoneAPI
+	release chan struct{}
+	mu      sync.Mutex
+	active  int
+	peak    int
+}
+
+func (b *blockingAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	b.mu.Lock()
+	b.active++
+	if b.active > b.peak {
+		b.peak = b.active
+	}
+	b.mu.Unlock()
+	<-b.release
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.active--
+	return b.fakeDNSZoneAPI.List(ctx, opts)
+}
+
+func (b *blockingAPI) Get(ctx context.Context, id int64) (*bunny.DNSZone, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.fakeDNSZoneAPI.Get(ctx, id)
+}
+
+func (b *blockingAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.fakeDNSZoneAPI.AddDNSRecord(ctx, zoneID, opts)
+}
+
+func (b *blockingAPI) DeleteDNSRecord(ctx context.Context, zoneID int64, recordID int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.fakeDNSZoneAPI.DeleteDNSRecord(ctx, zoneID, recordID)
+}
+
+func TestPresentConcurrencyLimit(t *testing.T) {
+	api := &blockingAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), release: make(chan struct{})}
+	solver := newTestSolver(api)
+	solver.presentSlots = make(chan struct{}, 2)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ch := newChallenge("_acme-challenge.example.com.", "example.com.", fmt.Sprintf("key-%d", i), `,"disableZoneCache":true`)
+			errs <- solver.Present(ch)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(api.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if api.peak != 2 {
+		t.Errorf("%d presents ran at once, want 2", api.peak)
+	}
+}
+
+func TestCleanUpConcurrencyLimit(t *testing.T) {
+	api := &blockingAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), release: make(chan struct{})}
+	for i := 0; i < 6; i++ {
+		api.zones[0].Records = append(api.zones[0].Records, txtRecord(int64(10+i), "_acme-challenge", fmt.Sprintf("key-%d", i)))
+	}
+	solver := newTestSolver(api)
+	solver.cleanUpSlots = make(chan struct{}, 2)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 6)
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ch := newChallenge("_acme-challenge.example.com.", "example.com.", fmt.Sprintf("key-%d", i), `,"disableZoneCache":true`)
+			errs <- solver.CleanUp(ch)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(api.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if api.peak != 2 {
+		t.Errorf("%d clean ups ran at once, want 2", api.peak)
+	}
+	if got := api.txtValues(1); len(got) != 0 {
+		t.Errorf("TXT values %v left after the burst, want none", got)
+	}
+}
+
+func TestPresentWarnsAboutLargeZoneOnce(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	api := newFakeDNSZoneAPI("example.com")
+	for i := 0; i < 5; i++ {
+		api.zones[0].Records = append(api.zones[0].Records, unmanagedTXTRecord(int64(i+1), fmt.Sprintf("host%d", i), "value"))
+	}
+	solver := newTestSolver(api)
+	solver.largeZoneRecords = 5
+	if err := solver.Present(newChallenge("_acme-challenge.a.example.com.", "example.com.", "key-a", "")); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "many records") {
+		t.Fatalf("zone at the threshold was warned about: %q", buf.String())
+	}
+	for _, name := range []string{"b", "c"} {
+		if err := solver.Present(newChallenge("_acme-challenge."+name+".example.com.", "example.com.", "key-"+name, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "warning: zone has many records, each challenge reads and scans all of them; consider delegating the challenge names with a CNAME to a small zone zone=1 records=6 threshold=5"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("log %q does not contain %q", buf.String(), want)
+	}
+	if n := strings.Count(buf.String(), "many records"); n != 1 {
+		t.Errorf("warned %d times about the zone, want once", n)
+	}
+}
+
+// recordlessZoneAPI returns zones with Records set to records, as bunny.net
+// does for a zone without records, or no zone at all with missing set.
+type recordlessZoneAPI struct {
+	*fakeDNSZoneAPI
+	records []bunny.DNSRecord
+	missing bool
+}
+
+func (r *recordlessZoneAPI) Get(ctx context.Context, id int64) (*bunny.DNSZone, error) {
+	zone, err := r.fakeDNSZoneAPI.Get(ctx, id)
+	if err != nil || r.missing {
+		return nil, err
+	}
+	zone.Records = r.records
+	return zone, nil
+}
+
+func TestZoneWithoutRecords(t *testing.T) {
+	for name, records := range map[string][]bunny.DNSRecord{"nil": nil, "empty": {}} {
+		t.Run(name, func(t *testing.T) {
+			api := &recordlessZoneAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), records: records}
+			solver := newTestSolver(api)
+			got, err := solver.zoneRecords(api, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == nil || len(got) != 0 {
+				t.Errorf("zoneRecords() = %#v, want an empty, non-nil slice", got)
+			}
+			if matches := matchTXTRecords(got, "_acme-challenge", "key", "example.com.", ""); len(matches) != 0 {
+				t.Errorf("matched %d records in an empty zone", len(matches))
+			}
+
+			// The zone never lists the added record, so CleanUp must not
+			// wait for it.
+			ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"deleteStaleRecords":true,"staleRecordMaxAge":"1h","cleanUpVisibilityWindow":"0s"`)
+			if err := solver.Present(ch); err != nil {
+				t.Fatal(err)
+			}
+			if api.addCalls != 1 || api.deleteCalls != 0 {
+				t.Errorf("Present made %d add and %d delete calls, want 1 and 0", api.addCalls, api.deleteCalls)
+			}
+			if err := solver.CleanUp(ch); err != nil {
+				t.Fatal(err)
+			}
+			if api.deleteCalls != 0 {
+				t.Errorf("CleanUp made %d delete calls in a zone listing no records", api.deleteCalls)
+			}
+		})
+	}
+}
+
+func TestZoneRecordsWithoutZone(t *testing.T) {
+	api := &recordlessZoneAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), missing: true}
+	if _, err := newTestSolver(api).zoneRecords(api, 1); err == nil || !strings.Contains(err.Error(), "no zone for ID 1") {
+		t.Errorf("zoneRecords() error = %v, want one saying no zone was returned", err)
+	}
+}
@@ -0,0 +1,121 @@
+package solver
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// These tests pin down the parts of bunny-go's record types the webhook
+// relies on, so that a dependency update that changes them fails here
+// rather than in production.
+
+func TestRecordConstantsMatchBunnyGo(t *testing.T) {
+	if txtRecordType != bunny.DNSRecordTypeTXT {
+		t.Errorf("txtRecordType = %d, bunny-go's DNSRecordTypeTXT = %d", txtRecordType, bunny.DNSRecordTypeTXT)
+	}
+}
+
+func TestTXTRecordOptions(t *testing.T) {
+	recordType, ttl, monitorType, smartRoutingType := txtRecordType, int32(120), monitorTypeNone, smartRoutingTypeNone
+	name, value, marker := "_acme-challenge", "key", recordMarker
+	accelerated, disabled := false, false
+	want := &bunny.AddOrUpdateDNSRecordOptions{
+		Type:             &recordType,
+		Name:             &name,
+		Value:            &value,
+		TTL:              &ttl,
+		Tag:              &marker,
+		MonitorType:      &monitorType,
+		SmartRoutingType: &smartRoutingType,
+		Accelerated:      &accelerated,
+		Disabled:         &disabled,
+	}
+	if got := txtRecordOptions(bunnyConfig{}, name, value, ttl, marker); !reflect.DeepEqual(got, want) {
+		t.Errorf("txtRecordOptions() = %+v, want %+v", got, want)
+	}
+
+	weight := int32(50)
+	want.Weight = &weight
+	if got := txtRecordOptions(bunnyConfig{RecordWeight: &weight}, name, value, ttl, marker); !reflect.DeepEqual(got, want) {
+		t.Errorf("txtRecordOptions() with recordWeight = %+v, want %+v", got, want)
+	}
+}
+
+func TestRecordOptionsWireFormat(t *testing.T) {
+	b, err := json.Marshal(txtRecordOptions(bunnyConfig{}, "_acme-challenge", "key", 120, recordMarker))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"Type":             float64(3),
+		"Name":             "_acme-challenge",
+		"Value":            "key",
+		"Ttl":              float64(120),
+		"Tag":              recordMarker,
+		"MonitorType":      float64(0),
+		"SmartRoutingType": float64(0),
+		"Accelerated":      false,
+		"Disabled":         false,
+	}
+	if len(got) != len(want) {
+		t.Errorf("options encode as %s, want exactly the fields %v", b, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("options field %s = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestDecodeRecordResponse(t *testing.T) {
+	// Abridged record as returned in a zone by the bunny.net API.
+	const response = `{"Id":42,"Type":3,"Ttl":120,"Value":"key","Name":"_acme-challenge","Tag":"cert-manager-webhook-bunny","MonitorType":0}`
+	var record bunny.DNSRecord
+	if err := json.Unmarshal([]byte(response), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.ID == nil || *record.ID != 42 || record.TTL == nil || *record.TTL != 120 {
+		t.Fatalf("decoded record %+v, want ID 42 and TTL 120", record)
+	}
+	if matches := matchTXTRecords([]bunny.DNSRecord{record}, "_acme-challenge", "key", "example.com.", ""); len(matches) != 1 {
+		t.Error("decoded record is not matched as the challenge's TXT record")
+	}
+	if !isManagedRecord(record) {
+		t.Error("decoded record is not recognized as managed")
+	}
+}
+
+func TestRecordMarkerTag(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	tag := recordMarkerTag(at)
+	record := bunny.DNSRecord{Tag: &tag}
+	if !isManagedRecord(record) {
+		t.Errorf("record tagged %q is not managed", tag)
+	}
+	if written, ok := recordWritten(record); !ok || !written.Equal(at) {
+		t.Errorf("recordWritten(%q) = %v, %v, want %v", tag, written, ok, at)
+	}
+
+	for _, tag := range []string{recordMarker, recordMarker + "@soon", "manual"} {
+		tag := tag
+		if _, ok := recordWritten(bunny.DNSRecord{Tag: &tag}); ok {
+			t.Errorf("recordWritten(%q) reports a write time", tag)
+		}
+	}
+	bare := recordMarker
+	if !isManagedRecord(bunny.DNSRecord{Tag: &bare}) {
+		t.Error("record with the bare marker of older versions is not managed")
+	}
+	broken := recordMarker + "@soon"
+	if isManagedRecord(bunny.DNSRecord{Tag: &broken}) {
+		t.Errorf("record tagged %q is managed", broken)
+	}
+}
@@ -0,0 +1,140 @@
+package solver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	propagationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "bunny_webhook",
+		Name:      "propagation_duration_seconds",
+		Help:      "Time from creating a TXT record until the configured quorum of authoritative nameservers, and the configured propagation resolvers if any, served it.",
+		Buckets:   []float64{1, 2, 5, 10, 20, 30, 60, 120, 300},
+	})
+
+	zoneResolutionPages = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "bunny_webhook",
+		Name:      "zone_resolution_pages",
+		Help:      "Number of zone list pages fetched to resolve a zone.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	zoneResolutionZonesScanned = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "bunny_webhook",
+		Name:      "zone_resolution_zones_scanned",
+		Help:      "Number of zones inspected to resolve a zone.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	})
+
+	slowAPICalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bunny_webhook",
+		Name:      "slow_api_calls_total",
+		Help:      "Number of bunny.net API calls that took longer than the slow call threshold.",
+	}, []string{"operation"})
+
+	zoneRecordCount = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "bunny_webhook",
+		Name:      "zone_records",
+		Help:      "Number of records in the zones read to present or clean up a challenge.",
+		Buckets:   prometheus.ExponentialBuckets(10, 4, 8),
+	})
+
+	lastSuccessfulAPICall = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "bunny_webhook",
+		Name:      "last_successful_api_call_timestamp_seconds",
+		Help:      "Unix time of the last bunny.net API call that succeeded.",
+	})
+
+	secretCacheReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bunny_webhook",
+		Name:      "secret_cache_reads_total",
+		Help:      "Number of access key Secret reads from the Secret informer cache, by whether the Secret was cached.",
+	}, []string{"result"})
+
+	circuitBreakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "bunny_webhook",
+		Name:      "circuit_breaker_state",
+		Help:      "State of the bunny.net API circuit breaker: 0 closed, 1 open, 2 half-open.",
+	})
+
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "bunny_webhook",
+		Name:      "build_info",
+		Help:      "Always 1, labelled with the version, commit and build date of the running webhook.",
+	}, []string{"version", "commit", "build_date", "go_version"})
+
+	credentialValid = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "bunny_webhook",
+		Name:      "credential_valid",
+		Help:      "Whether bunny.net accepted the access key of a credential Secret on its last use: 1 accepted, 0 rejected.",
+	}, []string{"secret"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(propagationDuration, zoneResolutionPages, zoneResolutionZonesScanned, slowAPICalls, zoneRecordCount, lastSuccessfulAPICall, secretCacheReads, circuitBreakerState, buildInfo, credentialValid)
+	buildInfo.WithLabelValues(Version, Commit, BuildDate, runtime.Version()).Set(1)
+}
+
+// Policies for when the metrics address cannot be bound.
+const (
+	// metricsBindRetry keeps serving challenges and retries the bind in
+	// the background.
+	metricsBindRetry = "retry"
+	// metricsBindIgnore keeps serving challenges without metrics.
+	metricsBindIgnore = "ignore"
+	// metricsBindFail refuses to start the webhook.
+	metricsBindFail = "fail"
+)
+
+var metricsBindRetryInterval = 30 * time.Second
+
+// startMetricsServer exposes the webhook's metrics on addr under /metrics.
+// Metrics are auxiliary, so unless policy is metricsBindFail a bind failure
+// is logged and never stops the webhook from solving challenges.
+func startMetricsServer(addr, policy string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err == nil {
+		go serveMetrics(ln)
+		return nil
+	}
+	switch policy {
+	case metricsBindFail:
+		return fmt.Errorf("failed to serve metrics on %s: %v", addr, err)
+	case metricsBindIgnore:
+		rootLogger.warning("failed to serve metrics, continuing without metrics", field("address", addr), field("error", err))
+	default:
+		rootLogger.warning("failed to serve metrics, retrying", field("address", addr),
+			field("interval", metricsBindRetryInterval), field("error", err))
+		go func() {
+			for {
+				time.Sleep(metricsBindRetryInterval)
+				ln, err := net.Listen("tcp", addr)
+				if err == nil {
+					serveMetrics(ln)
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+func serveMetrics(ln net.Listener) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	mux.Handle("/status/bunny", &bunnyAPIHealth)
+	mux.Handle("/status/credentials", &bunnyCredentialHealth)
+	rootLogger.info("serving metrics", field("address", ln.Addr()))
+	if err := http.Serve(ln, mux); err != nil {
+		rootLogger.error("metrics server failed", field("error", err))
+	}
+}
@@ -0,0 +1,90 @@
+package solver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// counterValue returns the current value of c.
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// histogramSnapshot returns the sample count and sum of h.
+func histogramSnapshot(t *testing.T, h prometheus.Histogram) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestZoneResolutionMetrics(t *testing.T) {
+	pagesBefore, pagesSumBefore := histogramSnapshot(t, zoneResolutionPages)
+	zonesBefore, zonesSumBefore := histogramSnapshot(t, zoneResolutionZonesScanned)
+
+	api := newFakeDNSZoneAPI("a.com", "b.com", "c.com", "d.com", "example.com", "f.com", "g.com")
+	api.perPage = 2
+	if _, err := (&bunnySolver{}).resolveZoneIds(context.Background(), api, "example.com.", bunnyConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, pagesSum := histogramSnapshot(t, zoneResolutionPages)
+	zones, zonesSum := histogramSnapshot(t, zoneResolutionZonesScanned)
+	if pages-pagesBefore != 1 || pagesSum-pagesSumBefore != 3 {
+		t.Errorf("got %d page observations summing to %v, want 1 of 3", pages-pagesBefore, pagesSum-pagesSumBefore)
+	}
+	if zones-zonesBefore != 1 || zonesSum-zonesSumBefore != 6 {
+		t.Errorf("got %d zone observations summing to %v, want 1 of 6", zones-zonesBefore, zonesSum-zonesSumBefore)
+	}
+}
+
+func TestStartMetricsServerBindFailure(t *testing.T) {
+	blocker, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := blocker.Addr().String()
+
+	if err := startMetricsServer(addr, metricsBindFail); err == nil {
+		t.Error("expected error with fail policy")
+	}
+	if err := startMetricsServer(addr, metricsBindIgnore); err != nil {
+		t.Errorf("unexpected error with ignore policy: %v", err)
+	}
+
+	defer func(d time.Duration) { metricsBindRetryInterval = d }(metricsBindRetryInterval)
+	metricsBindRetryInterval = 10 * time.Millisecond
+	if err := startMetricsServer(addr, metricsBindRetry); err != nil {
+		t.Fatalf("unexpected error with retry policy: %v", err)
+	}
+	blocker.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d", resp.StatusCode)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics server did not come up after the port was freed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
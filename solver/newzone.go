@@ -0,0 +1,55 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+const (
+	defaultNewZoneRetryWindow   = 30 * time.Second
+	defaultNewZoneRetryInterval = 2 * time.Second
+)
+
+// isEventualConsistencyError reports whether err is what bunny.net returns
+// for a zone created moments ago: either the zone is not listed yet or its
+// ID is not known to the record endpoints yet.
+func isEventualConsistencyError(err error) bool {
+	return errors.Is(err, errZoneNotFound) || isNotFound(err)
+}
+
+// newZoneRetryWindow returns how long Present keeps retrying a zone it has
+// not resolved before. Zones already in the cache have been listable for a
+// while, so their errors are not retried.
+func (c *bunnySolver) newZoneRetryWindow(client DNSZoneAPI, zoneName string, cfg bunnyConfig) time.Duration {
+	if _, ok := c.zones.get(newZoneCacheKey(client, zoneName, cfg)); ok {
+		return 0
+	}
+	if cfg.NewZoneRetryWindow != nil {
+		return cfg.NewZoneRetryWindow.Duration
+	}
+	return defaultNewZoneRetryWindow
+}
+
+// retryNewZone calls present until it succeeds, fails permanently or the
+// retry window is used up.
+func (c *bunnySolver) retryNewZone(ctx context.Context, ch *v1alpha1.ChallengeRequest, window time.Duration, present func() error) error {
+	interval := c.newZoneRetryInterval
+	if interval == 0 {
+		interval = defaultNewZoneRetryInterval
+	}
+	deadline := time.Now().Add(window)
+	for {
+		err := present()
+		if err == nil || !isEventualConsistencyError(err) || time.Now().Add(interval).After(deadline) {
+			return err
+		}
+		challengeLogger(ch).info("zone is not available yet, retrying",
+			field("zone", ch.ResolvedZone), field("interval", interval), field("error", err))
+		if sleepContext(ctx, interval) != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package solver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// newZoneAPI imitates a zone that was just created: it is missing from the
+// zone list for the first hiddenLists calls and record writes fail for the
+// first failedAdds calls.
+type newZoneAPI struct {
+	*fakeDNSZoneAPI
+	hiddenLists int
+	failedAdds  int
+	addErr      error
+}
+
+func (n *newZoneAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	if n.hiddenLists > 0 {
+		n.hiddenLists--
+		n.listCalls++
+		hasMore := false
+		other, id := "other.com", int64(99)
+		return &bunny.DNSZones{Items: []*bunny.DNSZone{{ID: &id, Domain: &other}}, HasMoreItems: &hasMore}, nil
+	}
+	return n.fakeDNSZoneAPI.List(ctx, opts)
+}
+
+func (n *newZoneAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	if n.failedAdds > 0 {
+		n.failedAdds--
+		n.addCalls++
+		return nil, n.addErr
+	}
+	return n.fakeDNSZoneAPI.AddDNSRecord(ctx, zoneID, opts)
+}
+
+func TestPresentRetriesNewZone(t *testing.T) {
+	tests := []struct {
+		name        string
+		hiddenLists int
+		failedAdds  int
+		addErr      error
+		config      string
+		wantErr     bool
+		wantAdds    int
+	}{
+		{"zone listed after delay", 3, 0, nil, "", false, 1},
+		{"record write fails until zone is ready", 0, 2, errZoneGone, "", false, 3},
+		{"zone never appears", 1000, 0, nil, `,"newZoneRetryWindow":"50ms"`, true, 0},
+		{"retries disabled", 1, 0, nil, `,"newZoneRetryWindow":"0s"`, true, 0},
+		{"permanent error", 0, 5, &bunny.HTTPError{StatusCode: http.StatusBadRequest}, "", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &newZoneAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"),
+				hiddenLists: tt.hiddenLists, failedAdds: tt.failedAdds, addErr: tt.addErr}
+			solver := newTestSolver(api)
+			solver.newZoneRetryInterval = time.Millisecond
+			err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if api.addCalls != tt.wantAdds {
+				t.Errorf("AddDNSRecord called %d times, want %d", api.addCalls, tt.wantAdds)
+			}
+			if !tt.wantErr && len(api.txtValues(1)) != 1 {
+				t.Errorf("got TXT values %v, want one", api.txtValues(1))
+			}
+		})
+	}
+}
+
+func TestPresentDoesNotRetryCachedZone(t *testing.T) {
+	api := &newZoneAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com")}
+	solver := newTestSolver(api)
+	solver.newZoneRetryInterval = time.Millisecond
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	api.failedAdds, api.addErr = 1, errZoneGone
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "other-key", "")); err == nil {
+		t.Fatal("expected the error for a cached zone to be returned")
+	}
+	if api.addCalls != 2 {
+		t.Errorf("AddDNSRecord called %d times, want 2", api.addCalls)
+	}
+}
@@ -0,0 +1,122 @@
+package solver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+const (
+	defaultNotifyTimeout = 5 * time.Second
+	// maxPendingNotifications bounds the notifications in flight, so that
+	// a slow receiver cannot pile up goroutines. Further ones are dropped.
+	maxPendingNotifications = 32
+)
+
+// notification is the JSON posted to NOTIFY_URL after a challenge record
+// was presented or cleaned up.
+type notification struct {
+	Operation string    `json:"operation"`
+	Zone      string    `json:"zone"`
+	FQDN      string    `json:"fqdn"`
+	Record    string    `json:"record"`
+	Namespace string    `json:"namespace"`
+	Time      time.Time `json:"time"`
+}
+
+// notifier tells an external system, such as a change management tool,
+// about the DNS changes the webhook made. Delivery happens in the
+// background and never affects the challenge: failures are only logged.
+type notifier struct {
+	url     string
+	client  *http.Client
+	pending chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newNotifier(url string, timeout time.Duration) *notifier {
+	return &notifier{
+		url:     url,
+		client:  &http.Client{Timeout: timeout},
+		pending: make(chan struct{}, maxPendingNotifications),
+	}
+}
+
+// notify posts a notification for a successful operation on ch's record.
+// It does nothing when n is nil.
+func (n *notifier) notify(op string, ch *v1alpha1.ChallengeRequest, recordName string) {
+	if n == nil {
+		return
+	}
+	logger := challengeLogger(ch)
+	select {
+	case n.pending <- struct{}{}:
+	default:
+		logger.warning("too many notifications pending, dropping one", field("notifyOperation", op))
+		return
+	}
+	body, err := json.Marshal(notification{
+		Operation: op,
+		Zone:      ch.ResolvedZone,
+		FQDN:      ch.ResolvedFQDN,
+		Record:    recordName,
+		Namespace: ch.ResourceNamespace,
+		Time:      time.Now().UTC(),
+	})
+	if err != nil {
+		<-n.pending
+		logger.warning("failed to encode notification", field("error", err))
+		return
+	}
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		defer func() { <-n.pending }()
+		if err := n.post(body); err != nil {
+			logger.warning("failed to send notification", field("notifyOperation", op), field("error", err))
+		}
+	}()
+}
+
+func (n *notifier) post(body []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+// wait blocks until the notifications sent so far were delivered or failed.
+func (n *notifier) wait() {
+	if n != nil {
+		n.wg.Wait()
+	}
+}
+
+// validateNotifyURL checks that NOTIFY_URL is an absolute http or https URL.
+func validateNotifyURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", s)
+	}
+	return nil
+}
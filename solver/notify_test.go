@@ -0,0 +1,100 @@
+package solver
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotifyAfterPresentAndCleanUp(t *testing.T) {
+	var mu sync.Mutex
+	var got []notification
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("invalid notification: %v", err)
+		}
+		mu.Lock()
+		got = append(got, n)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	solver := newTestSolver(newFakeDNSZoneAPI("example.com"))
+	solver.notifier = newNotifier(receiver.URL, time.Second)
+	ch := newChallenge("_acme-challenge.www.example.com.", "example.com.", "key", "")
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	solver.notifier.wait()
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	solver.notifier.wait()
+
+	if len(got) != 2 {
+		t.Fatalf("got %d notifications, want 2: %+v", len(got), got)
+	}
+	for i, op := range []string{"Present", "CleanUp"} {
+		n := got[i]
+		if n.Operation != op || n.Zone != "example.com." || n.FQDN != "_acme-challenge.www.example.com." ||
+			n.Record != "_acme-challenge.www" || n.Namespace != "default" || n.Time.IsZero() {
+			t.Errorf("notification %d = %+v, want %s of _acme-challenge.www", i, n, op)
+		}
+	}
+}
+
+func TestNotifyFailureDoesNotFailChallenge(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	solver := newTestSolver(newFakeDNSZoneAPI("example.com"))
+	solver.notifier = newNotifier(receiver.URL, time.Second)
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatalf("Present() error = %v, want the notification failure ignored", err)
+	}
+	solver.notifier.wait()
+	if !strings.Contains(buf.String(), "failed to send notification") || !strings.Contains(buf.String(), "503") {
+		t.Errorf("notification failure not logged:\n%s", buf.String())
+	}
+}
+
+func TestNotifyTimeout(t *testing.T) {
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer receiver.Close()
+	defer close(release)
+
+	n := newNotifier(receiver.URL, 20*time.Millisecond)
+	start := time.Now()
+	n.notify("Present", newChallenge("_acme-challenge.example.com.", "example.com.", "key", ""), "_acme-challenge")
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("notify blocked for %s", elapsed)
+	}
+	n.wait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("notification gave up after %s, want the 20ms timeout", elapsed)
+	}
+}
+
+func TestNoNotifierConfigured(t *testing.T) {
+	solver := newTestSolver(newFakeDNSZoneAPI("example.com"))
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	solver.notifier.wait()
+}
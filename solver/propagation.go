@@ -0,0 +1,247 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+const (
+	defaultPropagationTimeout  = 2 * time.Minute
+	defaultPropagationInterval = 5 * time.Second
+	// defaultNegativeCacheGrace is how long a recursive resolver that
+	// cached the absence of the record is waited for.
+	defaultNegativeCacheGrace = time.Minute
+)
+
+// txtLookupFunc returns the TXT values served for fqdn by nameserver.
+type txtLookupFunc func(ctx context.Context, fqdn, nameserver string) ([]string, error)
+
+// lookupTXT queries nameserver directly, without recursion, for the TXT
+// records of fqdn.
+func lookupTXT(ctx context.Context, fqdn, nameserver string) ([]string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(fqdn), dns.TypeTXT)
+	m.RecursionDesired = false
+	client := &dns.Client{}
+	in, _, err := client.ExchangeContext(ctx, m, net.JoinHostPort(nameserver, "53"))
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, rr := range in.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			values = append(values, strings.Join(txt.Txt, ""))
+		}
+	}
+	return values, nil
+}
+
+// Propagation quorums: how many of the zone's nameservers must serve the
+// record for it to count as propagated.
+const (
+	propagationQuorumAll      = "all"
+	propagationQuorumMajority = "majority"
+	propagationQuorumAny      = "any"
+)
+
+// validatePropagationQuorum checks the propagationQuorum of cfg.
+func validatePropagationQuorum(cfg bunnyConfig) error {
+	switch cfg.PropagationQuorum {
+	case "", propagationQuorumAll, propagationQuorumMajority, propagationQuorumAny:
+		return nil
+	}
+	return fmt.Errorf("unknown propagationQuorum %q, want %q, %q or %q",
+		cfg.PropagationQuorum, propagationQuorumAll, propagationQuorumMajority, propagationQuorumAny)
+}
+
+// quorumSize returns how many of n nameservers quorum requires.
+func quorumSize(quorum string, n int) int {
+	switch quorum {
+	case propagationQuorumAny:
+		return 1
+	case propagationQuorumMajority:
+		return n/2 + 1
+	}
+	return n
+}
+
+// waitForPropagation polls the nameservers, all at once, until need of
+// them serve value for fqdn, and returns how long that took. A nameserver
+// that served it is not asked again. It gives up when ctx is done.
+func waitForPropagation(ctx context.Context, lookup txtLookupFunc, nameservers []string, fqdn, value string, interval time.Duration, need int) (time.Duration, error) {
+	if len(nameservers) == 0 {
+		return 0, fmt.Errorf("no nameservers to check propagation against")
+	}
+	start := time.Now()
+	pending := append([]string(nil), nameservers...)
+	served := 0
+	for {
+		found := make([]bool, len(pending))
+		var wg sync.WaitGroup
+		for i, ns := range pending {
+			wg.Add(1)
+			go func(i int, ns string) {
+				defer wg.Done()
+				values, err := lookup(ctx, fqdn, ns)
+				found[i] = err == nil && containsValue(values, value)
+			}(i, ns)
+		}
+		wg.Wait()
+		var remaining []string
+		for i, ns := range pending {
+			if found[i] {
+				served++
+			} else {
+				remaining = append(remaining, ns)
+			}
+		}
+		if served >= need || len(remaining) == 0 {
+			return time.Since(start), nil
+		}
+		pending = remaining
+		select {
+		case <-ctx.Done():
+			return time.Since(start), fmt.Errorf("TXT record %s visible on %d of %d nameservers, %d needed, not on %s: %v",
+				fqdn, served, len(nameservers), need, strings.Join(pending, ", "), ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+// txtAnswer is a recursive resolver's answer to a TXT query.
+type txtAnswer struct {
+	values []string
+	// negativeTTL is how long the resolver keeps answering that the name
+	// has no TXT records, taken from the SOA record of a negative answer.
+	// It is zero for positive answers and negative answers without SOA.
+	negativeTTL time.Duration
+}
+
+// txtResolveFunc asks the recursive resolver at address for the TXT
+// records of fqdn.
+type txtResolveFunc func(ctx context.Context, fqdn, address string) (txtAnswer, error)
+
+// resolveTXT queries the recursive resolver at address, which defaults to
+// port 53, for the TXT records of fqdn.
+func resolveTXT(ctx context.Context, fqdn, address string) (txtAnswer, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "53")
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(fqdn), dns.TypeTXT)
+	client := &dns.Client{}
+	in, _, err := client.ExchangeContext(ctx, m, address)
+	if err != nil {
+		return txtAnswer{}, err
+	}
+	var answer txtAnswer
+	for _, rr := range in.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			answer.values = append(answer.values, strings.Join(txt.Txt, ""))
+		}
+	}
+	if len(answer.values) == 0 {
+		for _, rr := range in.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				// A negative answer is cached for the smaller of the SOA's
+				// TTL and minimum (RFC 2308); resolvers count the TTL down.
+				ttl := soa.Hdr.Ttl
+				if soa.Minttl < ttl {
+					ttl = soa.Minttl
+				}
+				answer.negativeTTL = time.Duration(ttl) * time.Second
+			}
+		}
+	}
+	return answer, nil
+}
+
+// waitForResolvers polls recursive resolvers until each serves value for
+// fqdn. It is meant to run once the authoritative nameservers serve the
+// record, so that the polls cannot cache its absence.
+//
+// A resolver that answers from a negative cache entry is not asked again
+// before the entry expires, as it would give the same answer until then.
+// If the entry outlasts grace, the resolver is given up on with a warning
+// instead of holding the challenge up. It gives up when ctx is done.
+func waitForResolvers(ctx context.Context, logger fieldLogger, resolve txtResolveFunc, resolvers []string, fqdn, value string, interval, grace time.Duration) error {
+	next := map[string]time.Time{}
+	for _, r := range resolvers {
+		next[r] = time.Time{}
+	}
+	for {
+		now := time.Now()
+		for _, r := range resolvers {
+			at, ok := next[r]
+			if !ok || now.Before(at) {
+				continue
+			}
+			answer, err := resolve(ctx, fqdn, r)
+			switch {
+			case err != nil:
+				next[r] = now.Add(interval)
+			case containsValue(answer.values, value):
+				delete(next, r)
+			case answer.negativeTTL > grace:
+				logger.warning("resolver cached the absence of the TXT record for longer than the grace, not waiting for it",
+					field("resolver", r), field("negativeTTL", answer.negativeTTL), field("grace", grace))
+				delete(next, r)
+			case answer.negativeTTL > interval:
+				logger.debug("resolver answers from a negative cache entry, waiting for it to expire",
+					field("resolver", r), field("negativeTTL", answer.negativeTTL))
+				next[r] = now.Add(answer.negativeTTL)
+			default:
+				next[r] = now.Add(interval)
+			}
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		var pending []string
+		var wake time.Time
+		for _, r := range resolvers {
+			if at, ok := next[r]; ok {
+				pending = append(pending, r)
+				if wake.IsZero() || at.Before(wake) {
+					wake = at
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("TXT record %s not visible through resolvers %s: %v",
+				fqdn, strings.Join(pending, ", "), ctx.Err())
+		case <-time.After(time.Until(wake)):
+		}
+	}
+}
+
+// containsValue reports whether values holds value, comparing them as
+// normalizeTXTValue does.
+func containsValue(values []string, value string) bool {
+	value = normalizeTXTValue(value)
+	for _, v := range values {
+		if normalizeTXTValue(v) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneNameservers returns the nameservers bunny.net assigned to zone.
+func zoneNameservers(zone *bunny.DNSZone) []string {
+	var nameservers []string
+	for _, ns := range []*string{zone.Nameserver1, zone.Nameserver2} {
+		if ns != nil && *ns != "" {
+			nameservers = append(nameservers, *ns)
+		}
+	}
+	return nameservers
+}
@@ -0,0 +1,216 @@
+package solver
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitForPropagation(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	lookup := func(ctx context.Context, fqdn, ns string) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[ns]++
+		if ns == "coco.bunny.net" && calls[ns] < 3 {
+			return nil, nil
+		}
+		return []string{"other", "key"}, nil
+	}
+	_, err := waitForPropagation(context.Background(), lookup,
+		[]string{"kiki.bunny.net", "coco.bunny.net"}, "_acme-challenge.example.com.", "key", time.Millisecond, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls["kiki.bunny.net"] != 1 {
+		t.Errorf("propagated nameserver queried %d times, want 1", calls["kiki.bunny.net"])
+	}
+	if calls["coco.bunny.net"] != 3 {
+		t.Errorf("lagging nameserver queried %d times, want 3", calls["coco.bunny.net"])
+	}
+}
+
+func TestWaitForPropagationTimeout(t *testing.T) {
+	lookup := func(ctx context.Context, fqdn, ns string) ([]string, error) {
+		return []string{"stale"}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := waitForPropagation(ctx, lookup, []string{"kiki.bunny.net"}, "_acme-challenge.example.com.", "key", time.Millisecond, 1)
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+}
+
+func TestWaitForPropagationNoNameservers(t *testing.T) {
+	_, err := waitForPropagation(context.Background(), nil, nil, "_acme-challenge.example.com.", "key", time.Millisecond, 0)
+	if err == nil {
+		t.Fatal("expected error without nameservers")
+	}
+}
+
+// laggingNameservers serve the record on the nameservers in lag only from
+// the given query on, and never on those in down.
+type laggingNameservers struct {
+	mu      sync.Mutex
+	lag     map[string]int
+	down    map[string]bool
+	queries map[string]int
+}
+
+func (l *laggingNameservers) lookup(ctx context.Context, fqdn, ns string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queries[ns]++
+	if l.down[ns] || l.queries[ns] < l.lag[ns] {
+		return []string{"stale"}, nil
+	}
+	return []string{"key"}, nil
+}
+
+func TestWaitForPropagationQuorum(t *testing.T) {
+	nameservers := []string{"ns1", "ns2", "ns3", "ns4", "ns5"}
+	tests := []struct {
+		quorum      string
+		lag         map[string]int
+		down        map[string]bool
+		wantErr     bool
+		wantQueries int
+	}{
+		{"all", map[string]int{"ns5": 3}, nil, false, 7},
+		{"all", nil, map[string]bool{"ns5": true}, true, 0},
+		{"majority", map[string]int{"ns4": 2}, map[string]bool{"ns1": true, "ns5": true}, false, 8},
+		{"majority", nil, map[string]bool{"ns1": true, "ns2": true, "ns3": true}, true, 0},
+		{"any", map[string]int{"ns1": 2}, map[string]bool{"ns2": true, "ns3": true, "ns4": true, "ns5": true}, false, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.quorum, func(t *testing.T) {
+			l := &laggingNameservers{lag: tt.lag, down: tt.down, queries: map[string]int{}}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err := waitForPropagation(ctx, l.lookup, nameservers, "_acme-challenge.example.com.", "key", time.Millisecond,
+				quorumSize(tt.quorum, len(nameservers)))
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "needed") {
+					t.Fatalf("got %v, want an error naming the quorum", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			queries := 0
+			for _, n := range l.queries {
+				queries += n
+			}
+			if queries != tt.wantQueries {
+				t.Errorf("got %d queries, want %d", queries, tt.wantQueries)
+			}
+		})
+	}
+}
+
+func TestPresentRejectsUnknownPropagationQuorum(t *testing.T) {
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"waitForPropagation":true,"propagationQuorum":"most"`)
+	err := newTestSolver(newFakeDNSZoneAPI("example.com")).Present(ch)
+	if err == nil || !strings.Contains(err.Error(), `unknown propagationQuorum "most"`) {
+		t.Fatalf("got %v, want an error naming the quorum", err)
+	}
+}
+
+// negativeCachingResolver behaves like a recursive resolver that was asked
+// for the record before it existed: it keeps answering that there is no
+// record, counting the negative TTL down, until the cache entry expires.
+type negativeCachingResolver struct {
+	expires time.Time
+	queries int
+}
+
+func (r *negativeCachingResolver) resolve(ctx context.Context, fqdn, address string) (txtAnswer, error) {
+	r.queries++
+	if remaining := time.Until(r.expires); remaining > 0 {
+		return txtAnswer{negativeTTL: remaining}, nil
+	}
+	return txtAnswer{values: []string{"key"}}, nil
+}
+
+func TestWaitForResolversWaitsOutNegativeCache(t *testing.T) {
+	r := &negativeCachingResolver{expires: time.Now().Add(50 * time.Millisecond)}
+	err := waitForResolvers(context.Background(), rootLogger, r.resolve, []string{"9.9.9.9"},
+		"_acme-challenge.example.com.", "key", time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Polling every millisecond would have queried about 50 times.
+	if r.queries > 3 {
+		t.Errorf("resolver queried %d times while its negative cache entry was valid, want it asked again once it expired", r.queries)
+	}
+}
+
+func TestWaitForResolversGivesUpOnLongNegativeCache(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	cached := &negativeCachingResolver{expires: time.Now().Add(time.Hour)}
+	resolve := func(ctx context.Context, fqdn, address string) (txtAnswer, error) {
+		if address == "1.1.1.1" {
+			return txtAnswer{values: []string{"key"}}, nil
+		}
+		return cached.resolve(ctx, fqdn, address)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := waitForResolvers(ctx, rootLogger, resolve, []string{"1.1.1.1", "9.9.9.9"},
+		"_acme-challenge.example.com.", "key", time.Millisecond, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.queries != 1 {
+		t.Errorf("resolver with an hour long negative cache entry queried %d times, want 1", cached.queries)
+	}
+	if !strings.Contains(buf.String(), "resolver=9.9.9.9") {
+		t.Errorf("log %q does not warn about the resolver given up on", buf.String())
+	}
+}
+
+func TestWaitForResolversTimeout(t *testing.T) {
+	resolve := func(ctx context.Context, fqdn, address string) (txtAnswer, error) {
+		return txtAnswer{values: []string{"stale"}}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := waitForResolvers(ctx, rootLogger, resolve, []string{"9.9.9.9"}, "_acme-challenge.example.com.", "key", time.Millisecond, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "9.9.9.9") {
+		t.Fatalf("got %v, want a timeout naming the resolver", err)
+	}
+}
+
+func TestPresentAsksResolversAfterNameservers(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	kiki := "kiki.bunny.net"
+	api.zones[0].Nameserver1 = &kiki
+	solver := newTestSolver(api)
+	var order []string
+	solver.lookupTXT = func(ctx context.Context, fqdn, ns string) ([]string, error) {
+		order = append(order, ns)
+		return []string{"key"}, nil
+	}
+	solver.resolveTXT = func(ctx context.Context, fqdn, address string) (txtAnswer, error) {
+		order = append(order, address)
+		return txtAnswer{values: []string{"key"}}, nil
+	}
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key",
+		`,"waitForPropagation":true,"propagationResolvers":["9.9.9.9"]`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, " "); got != "kiki.bunny.net 9.9.9.9" {
+		t.Errorf("queried %s, want the nameserver before the resolver", got)
+	}
+}
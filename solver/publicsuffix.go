@@ -0,0 +1,27 @@
+package solver
+
+import (
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// hasKnownPublicSuffix reports whether zone ends with a suffix on the
+// public suffix list. An unlisted top-level domain, such as .internal or a
+// typo of a real one, is not known: publicsuffix then falls back to the
+// last label, which it reports as neither ICANN-managed nor a listed
+// private suffix of two or more labels.
+func hasKnownPublicSuffix(zone string) bool {
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	suffix, icann := publicsuffix.PublicSuffix(zone)
+	return icann || strings.Contains(suffix, ".")
+}
+
+// warnUnknownPublicSuffix warns when zone does not end with a public
+// suffix. bunny.net only hosts public zones, so such a zone is usually a
+// typo or an internal name that cannot be resolved.
+func warnUnknownPublicSuffix(logger fieldLogger, zone string) {
+	if !hasKnownPublicSuffix(zone) {
+		logger.warning("zone does not end with a known public suffix, check the challenge's zone for a typo", field("zone", zone))
+	}
+}
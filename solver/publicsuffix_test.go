@@ -0,0 +1,62 @@
+package solver
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHasKnownPublicSuffix(t *testing.T) {
+	tests := []struct {
+		zone string
+		want bool
+	}{
+		{"example.com.", true},
+		{"example.co.uk.", true},
+		{"Example.COM", true},
+		{"xn--bcher-kva.de.", true},
+		{"user.github.io.", true},
+		{"example.internal.", false},
+		{"example.local.", false},
+		{"example.cmo.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			if got := hasKnownPublicSuffix(tt.zone); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPresentChecksPublicSuffix(t *testing.T) {
+	tests := []struct {
+		name     string
+		zone     string
+		config   string
+		wantWarn bool
+	}{
+		{"public", "example.com", `,"checkPublicSuffix":true`, false},
+		{"private", "example.internal", `,"checkPublicSuffix":true`, true},
+		{"private unchecked", "example.internal", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			api := newFakeDNSZoneAPI(tt.zone)
+			ch := newChallenge("_acme-challenge."+tt.zone+".", tt.zone+".", "key", tt.config)
+			if err := newTestSolver(api).Present(ch); err != nil {
+				t.Fatal(err)
+			}
+			warned := strings.Contains(buf.String(), "zone does not end with a known public suffix")
+			if warned != tt.wantWarn {
+				t.Errorf("got warning %v, want %v in log %q", warned, tt.wantWarn, buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package solver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+// Strategies for deriving the challenge record's name.
+const (
+	// recordNameFromFQDN names the record after the challenge's resolved
+	// FQDN, relative to the resolved zone.
+	recordNameFromFQDN = "fqdn"
+	// recordNameFixed always uses the configured recordName.
+	recordNameFixed = "fixed"
+)
+
+// Formats of the record name sent to bunny.net.
+const (
+	// recordNameFormatAuto sends the name relative to the zone. A fixed
+	// recordName that already ends with the zone has it removed, so that
+	// the record is not created below a doubled zone name.
+	recordNameFormatAuto = "auto"
+	// recordNameFormatRelative sends the name exactly as derived.
+	recordNameFormatRelative = "relative"
+	// recordNameFormatFQDN sends the name with the zone appended.
+	recordNameFormatFQDN = "fqdn"
+)
+
+// Strategies for deriving the challenge record's value.
+const (
+	// recordValueNormalized writes the key without surrounding quotes or
+	// escapes, which is what bunny.net expects.
+	recordValueNormalized = "normalized"
+	// recordValueVerbatim writes the key exactly as cert-manager passed it.
+	recordValueVerbatim = "verbatim"
+)
+
+// recordStrategy decides the name and value of the challenge record.
+type recordStrategy struct {
+	name      string
+	value     string
+	fixedName string
+	format    string
+}
+
+// newRecordStrategy returns the strategy selected by cfg.
+func newRecordStrategy(cfg bunnyConfig) (recordStrategy, error) {
+	s := recordStrategy{name: cfg.RecordNameStrategy, value: cfg.RecordValueStrategy, fixedName: cfg.RecordName, format: cfg.RecordNameFormat}
+	if s.name == "" {
+		s.name = recordNameFromFQDN
+	}
+	if s.value == "" {
+		s.value = recordValueNormalized
+	}
+	if s.format == "" {
+		s.format = recordNameFormatAuto
+	}
+	switch s.name {
+	case recordNameFromFQDN:
+		if s.fixedName != "" {
+			return s, fmt.Errorf("recordName is only used with recordNameStrategy %q", recordNameFixed)
+		}
+	case recordNameFixed:
+		s.fixedName = toASCIIName(strings.TrimSuffix(s.fixedName, "."))
+		if s.fixedName == "" {
+			return s, fmt.Errorf("recordNameStrategy %q needs a recordName", recordNameFixed)
+		}
+	default:
+		return s, fmt.Errorf("unknown recordNameStrategy %q, expected %s or %s", s.name, recordNameFromFQDN, recordNameFixed)
+	}
+	switch s.format {
+	case recordNameFormatAuto, recordNameFormatRelative, recordNameFormatFQDN:
+	default:
+		return s, fmt.Errorf("unknown recordNameFormat %q, expected %s, %s or %s",
+			s.format, recordNameFormatAuto, recordNameFormatRelative, recordNameFormatFQDN)
+	}
+	switch s.value {
+	case recordValueNormalized, recordValueVerbatim:
+	default:
+		return s, fmt.Errorf("unknown recordValueStrategy %q, expected %s or %s", s.value, recordValueNormalized, recordValueVerbatim)
+	}
+	return s, nil
+}
+
+// recordName returns the name of the record to present, relative to the
+// challenge's zone.
+func (s recordStrategy) recordName(ch *v1alpha1.ChallengeRequest) (string, error) {
+	if s.name == recordNameFixed {
+		name := s.fixedRecordName(ch.ResolvedZone)
+		if err := validateRecordName(name, name+"."+ch.ResolvedZone); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	return challengeRecordName(ch.ResolvedFQDN, ch.ResolvedZone)
+}
+
+// cleanUpRecordName returns the name of the record to clean up. It is
+// derived like recordName's, but not validated, so that records presented
+// by older versions can still be removed.
+func (s recordStrategy) cleanUpRecordName(ch *v1alpha1.ChallengeRequest) string {
+	if s.name == recordNameFixed {
+		return s.fixedRecordName(ch.ResolvedZone)
+	}
+	name, err := relativeRecordName(ch.ResolvedFQDN, ch.ResolvedZone)
+	if err != nil {
+		// Present refuses such a challenge, so no record was written for
+		// it and the lookup under the FQDN finds nothing to delete.
+		return strings.TrimSuffix(ch.ResolvedFQDN, ".")
+	}
+	return name
+}
+
+// fixedRecordName returns the fixed recordName relative to zone. Unless
+// the format is relative, a name that already ends with the zone has it
+// removed.
+func (s recordStrategy) fixedRecordName(zone string) string {
+	zone = strings.TrimSuffix(zone, ".")
+	name := s.fixedName
+	if s.format != recordNameFormatRelative && len(name) > len(zone) && strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(zone)) {
+		return name[:len(name)-len(zone)-1]
+	}
+	return name
+}
+
+// wireRecordName returns name, relative to zone, in the form format asks
+// for bunny.net. Records are matched on their names relative to the zone,
+// so records written in either form are found again.
+func wireRecordName(format, name, zone string) string {
+	if format == recordNameFormatFQDN {
+		return name + "." + strings.TrimSuffix(zone, ".")
+	}
+	return name
+}
+
+// recordValue returns the value to write for the challenge's key.
+func (s recordStrategy) recordValue(ch *v1alpha1.ChallengeRequest) string {
+	if s.value == recordValueVerbatim {
+		return ch.Key
+	}
+	return normalizeTXTValue(ch.Key)
+}
@@ -0,0 +1,231 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRecordStrategy(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       bunnyConfig
+		key       string
+		wantName  string
+		wantValue string
+		wantErr   bool
+	}{
+		{"defaults", bunnyConfig{}, `"key"`, "_acme-challenge.www", "key", false},
+		{"fixed name", bunnyConfig{RecordNameStrategy: recordNameFixed, RecordName: "_acme-challenge.shared."}, "key", "_acme-challenge.shared", "key", false},
+		{"verbatim value", bunnyConfig{RecordValueStrategy: recordValueVerbatim}, `"key"`, "_acme-challenge.www", `"key"`, false},
+		{"fixed name without recordName", bunnyConfig{RecordNameStrategy: recordNameFixed}, "key", "", "", true},
+		{"recordName without fixed strategy", bunnyConfig{RecordName: "_acme-challenge"}, "key", "", "", true},
+		{"invalid fixed name", bunnyConfig{RecordNameStrategy: recordNameFixed, RecordName: "a b"}, "key", "", "", true},
+		{"unknown name strategy", bunnyConfig{RecordNameStrategy: "cname"}, "key", "", "", true},
+		{"unknown value strategy", bunnyConfig{RecordValueStrategy: "base64"}, "key", "", "", true},
+		{"unknown name format", bunnyConfig{RecordNameFormat: "absolute"}, "key", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := newChallenge("_acme-challenge.www.example.com.", "example.com.", tt.key, "")
+			s, err := newRecordStrategy(tt.cfg)
+			var name string
+			if err == nil {
+				name, err = s.recordName(ch)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if name != tt.wantName {
+				t.Errorf("got name %q, want %q", name, tt.wantName)
+			}
+			if got := s.cleanUpRecordName(ch); got != tt.wantName {
+				t.Errorf("got clean up name %q, want %q", got, tt.wantName)
+			}
+			if got := s.recordValue(ch); got != tt.wantValue {
+				t.Errorf("got value %q, want %q", got, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestPresentAndCleanUpFixedRecordName(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	ch := newChallenge("_acme-challenge.www.example.com.", "example.com.", "key",
+		`,"recordNameStrategy":"fixed","recordName":"_acme-challenge.shared"`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	records := api.zones[0].Records
+	if len(records) != 1 || *records[0].Name != "_acme-challenge.shared" {
+		t.Fatalf("got records %v, want one named _acme-challenge.shared", records)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.zones[0].Records) != 0 {
+		t.Errorf("%d records left after clean up, want 0", len(api.zones[0].Records))
+	}
+}
+
+func TestWireRecordName(t *testing.T) {
+	tests := []struct {
+		format string
+		name   string
+		zone   string
+		want   string
+	}{
+		{"", "_acme-challenge.sub", "example.com.", "_acme-challenge.sub"},
+		{recordNameFormatAuto, "_acme-challenge.example.com", "example.com.", "_acme-challenge.example.com"},
+		{recordNameFormatRelative, "_acme-challenge.sub", "example.com.", "_acme-challenge.sub"},
+		{recordNameFormatFQDN, "_acme-challenge.sub", "example.com.", "_acme-challenge.sub.example.com"},
+		{recordNameFormatFQDN, "_acme-challenge", "example.com", "_acme-challenge.example.com"},
+	}
+	for _, tt := range tests {
+		if got := wireRecordName(tt.format, tt.name, tt.zone); got != tt.want {
+			t.Errorf("wireRecordName(%q, %q, %q) = %q, want %q", tt.format, tt.name, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestFixedRecordNameWithZone(t *testing.T) {
+	tests := []struct {
+		format string
+		name   string
+		want   string
+	}{
+		{"", "_acme-challenge.shared.example.com", "_acme-challenge.shared"},
+		{"", "_acme-challenge.shared.EXAMPLE.com.", "_acme-challenge.shared"},
+		{"", "_acme-challenge.notexample.com", "_acme-challenge.notexample.com"},
+		{recordNameFormatFQDN, "_acme-challenge.shared.example.com", "_acme-challenge.shared"},
+		{recordNameFormatRelative, "_acme-challenge.shared.example.com", "_acme-challenge.shared.example.com"},
+	}
+	for _, tt := range tests {
+		s, err := newRecordStrategy(bunnyConfig{RecordNameStrategy: recordNameFixed, RecordName: tt.name, RecordNameFormat: tt.format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch := newChallenge("_acme-challenge.www.example.com.", "example.com.", "key", "")
+		if got, err := s.recordName(ch); err != nil || got != tt.want {
+			t.Errorf("format %q: recordName(%q) = %q, %v, want %q", tt.format, tt.name, got, err, tt.want)
+		}
+		if got := s.cleanUpRecordName(ch); got != tt.want {
+			t.Errorf("format %q: cleanUpRecordName(%q) = %q, want %q", tt.format, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPresentSendsRecordNameFormat(t *testing.T) {
+	tests := []struct {
+		fqdn   string
+		zone   string
+		config string
+		want   string
+	}{
+		{"_acme-challenge.sub.example.com.", "example.com.", "", "_acme-challenge.sub"},
+		{"_acme-challenge.example.com.example.com.", "example.com.", "", "_acme-challenge.example.com"},
+		{"_acme-challenge.example.com.", "example.com.", `,"recordNameFormat":"fqdn"`, "_acme-challenge.example.com"},
+		{"_acme-challenge.www.sub.example.com.", "sub.example.com.", `,"recordNameFormat":"fqdn"`, "_acme-challenge.www.sub.example.com"},
+		{"_acme-challenge.www.example.com.", "example.com.",
+			`,"recordNameStrategy":"fixed","recordName":"_acme-challenge.shared.example.com"`, "_acme-challenge.shared"},
+		{"_acme-challenge.www.example.com.", "example.com.",
+			`,"recordNameStrategy":"fixed","recordName":"_acme-challenge.shared.example.com","recordNameFormat":"relative"`,
+			"_acme-challenge.shared.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s%s", tt.fqdn, tt.config), func(t *testing.T) {
+			api := &recordingAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com", "sub.example.com")}
+			solver := newTestSolver(api)
+			ch := newChallenge(tt.fqdn, tt.zone, "key", tt.config)
+			if err := solver.Present(ch); err != nil {
+				t.Fatal(err)
+			}
+			if len(api.written) != 1 || *api.written[0].Name != tt.want {
+				t.Fatalf("wrote %+v, want one record named %q", api.written, tt.want)
+			}
+			// The record is found again under its relative name.
+			if err := solver.Present(ch); err != nil {
+				t.Fatal(err)
+			}
+			if len(api.written) != 1 {
+				t.Errorf("second Present wrote %d records, want none", len(api.written)-1)
+			}
+			if err := solver.CleanUp(ch); err != nil {
+				t.Fatal(err)
+			}
+			if api.deleteCalls != 1 {
+				t.Errorf("CleanUp made %d deletes, want 1", api.deleteCalls)
+			}
+		})
+	}
+}
+
+func TestPresentFixedRecordNameAwaitsPropagation(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	kiki := "kiki.bunny.net"
+	api.zones[0].Nameserver1 = &kiki
+	solver := newTestSolver(api)
+	var queried []string
+	solver.lookupTXT = func(ctx context.Context, fqdn, ns string) ([]string, error) {
+		queried = append(queried, fqdn)
+		if fqdn != "_acme-challenge.shared.example.com." {
+			return nil, nil
+		}
+		return []string{"key"}, nil
+	}
+	solver.resolveTXT = func(ctx context.Context, fqdn, address string) (txtAnswer, error) {
+		queried = append(queried, fqdn)
+		if fqdn != "_acme-challenge.shared.example.com." {
+			return txtAnswer{}, nil
+		}
+		return txtAnswer{values: []string{"key"}}, nil
+	}
+	ch := newChallenge("_acme-challenge.www.example.com.", "example.com.", "key",
+		`,"recordNameStrategy":"fixed","recordName":"_acme-challenge.shared","waitForPropagation":true,`+
+			`"propagationTimeout":"1s","propagationResolvers":["9.9.9.9"]`)
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	for _, fqdn := range queried {
+		if fqdn != "_acme-challenge.shared.example.com." {
+			t.Errorf("looked up %s, want only the fixed record name", fqdn)
+		}
+	}
+}
+
+func TestCleanUpRecordNameMatchesPresent(t *testing.T) {
+	tests := []struct {
+		fqdn string
+		zone string
+		want string
+	}{
+		{"_acme-challenge.www.example.com.", "example.com.", "_acme-challenge.www"},
+		{"_acme-challenge.WWW.Example.COM.", "example.com.", "_acme-challenge.WWW"},
+		{"_acme-challenge.example.com.example.com.", "example.com.", "_acme-challenge.example.com"},
+		{"_acme-challenge.bücher.example.", "bücher.example.", "_acme-challenge"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fqdn, func(t *testing.T) {
+			api := newFakeDNSZoneAPI(toASCIIName(strings.TrimSuffix(tt.zone, ".")))
+			solver := newTestSolver(api)
+			ch := newChallenge(tt.fqdn, tt.zone, "key", "")
+			if err := solver.Present(ch); err != nil {
+				t.Fatal(err)
+			}
+			if records := api.zones[0].Records; len(records) != 1 || *records[0].Name != tt.want {
+				t.Fatalf("got records %v, want one named %s", records, tt.want)
+			}
+			if err := solver.CleanUp(ch); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(api.zones[0].Records); n != 0 {
+				t.Errorf("%d records left after clean up, want 0", n)
+			}
+		})
+	}
+}
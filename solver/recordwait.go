@@ -0,0 +1,167 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+const (
+	defaultRecordVisibilityTimeout = 30 * time.Second
+	defaultRecordPollInterval      = time.Second
+)
+
+// awaitRecordVisible polls the zone until the record just written is
+// returned by the API, so that a propagation check or a later clean up
+// does not act on a view of the zone without it. The record is looked up
+// by id when bunny.net returned one, and by name and value otherwise.
+func (c *bunnySolver) awaitRecordVisible(ctx context.Context, client DNSZoneAPI, logger fieldLogger, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, recordName string, zoneID int64, id *int64) error {
+	timeout := cfg.RecordVisibilityTimeout.Duration
+	if timeout <= 0 {
+		timeout = defaultRecordVisibilityTimeout
+	}
+	interval := c.recordPollInterval
+	if interval == 0 {
+		interval = defaultRecordPollInterval
+	}
+	deadline := time.Now().Add(timeout)
+	for polls := 1; ; polls++ {
+		records, err := c.zoneRecords(client, zoneID)
+		if err != nil {
+			return err
+		}
+		for _, r := range matchTXTRecords(records, recordName, ch.Key, ch.ResolvedZone, cfg.RecordNameFormat) {
+			if id == nil || (r.ID != nil && *r.ID == *id) {
+				logger.info("TXT record is visible through the API", field("polls", polls))
+				return nil
+			}
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("TXT record %s was written but not returned by the bunny.net API within %s", recordName, timeout)
+		}
+		if err := sleepContext(ctx, interval); err != nil {
+			return fmt.Errorf("stopped waiting for TXT record %s to be returned by the bunny.net API: %w", recordName, err)
+		}
+	}
+}
+
+const (
+	defaultCleanUpVisibilityWindow = 10 * time.Second
+	// maxCreatedRecordAge is how long a created record is remembered
+	// when no CleanUp for it comes.
+	maxCreatedRecordAge = time.Hour
+)
+
+// createdRecords remembers the records Present added, so that a CleanUp
+// coming right after can wait for a record the API does not list yet
+// instead of leaving it behind.
+type createdRecords struct {
+	mu      sync.Mutex
+	records map[createdRecordKey]createdRecord
+}
+
+type createdRecordKey struct {
+	zoneID int64
+	name   string
+	value  string
+}
+
+// createdRecord is a record Present added. id is 0 when bunny.net did not
+// return the record's ID; the record is then recognized by its name and
+// value alone.
+type createdRecord struct {
+	id      int64
+	created time.Time
+}
+
+// listedIn reports whether the record is among records, the challenge's
+// records as matched by name and value.
+func (r createdRecord) listedIn(records []bunny.DNSRecord) bool {
+	if r.id == 0 {
+		return len(records) > 0
+	}
+	return hasRecordID(records, r.id)
+}
+
+func newCreatedRecordKey(zoneID int64, name, value string) createdRecordKey {
+	return createdRecordKey{zoneID: zoneID, name: strings.ToLower(name), value: normalizeTXTValue(value)}
+}
+
+func (r *createdRecords) add(key createdRecordKey, id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if r.records == nil {
+		r.records = map[createdRecordKey]createdRecord{}
+	}
+	for k, c := range r.records {
+		if now.Sub(c.created) > maxCreatedRecordAge {
+			delete(r.records, k)
+		}
+	}
+	r.records[key] = createdRecord{id: id, created: now}
+}
+
+func (r *createdRecords) get(key createdRecordKey) (createdRecord, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.records[key]
+	return c, ok
+}
+
+func (r *createdRecords) remove(key createdRecordKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.records, key)
+}
+
+// awaitCreatedRecord re-reads the zone while the record Present created
+// is missing from records, for up to the clean up visibility window after
+// it was created. It returns the challenge's records as last read; a
+// record that never shows up is left to the caller to report.
+func (c *bunnySolver) awaitCreatedRecord(ctx context.Context, client DNSZoneAPI, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, recordName string, zoneID int64, records []bunny.DNSRecord) ([]bunny.DNSRecord, error) {
+	created, ok := c.createdRecords.get(newCreatedRecordKey(zoneID, recordName, ch.Key))
+	if !ok || created.listedIn(records) {
+		return records, nil
+	}
+	window := defaultCleanUpVisibilityWindow
+	if cfg.CleanUpVisibilityWindow != nil {
+		window = cfg.CleanUpVisibilityWindow.Duration
+	}
+	interval := c.recordPollInterval
+	if interval == 0 {
+		interval = defaultRecordPollInterval
+	}
+	deadline := created.created.Add(window)
+	for polls := 1; time.Now().Before(deadline); polls++ {
+		if err := sleepContext(ctx, interval); err != nil {
+			return nil, err
+		}
+		var err error
+		records, err = c.findTXTRecords(client, recordName, ch.Key, ch.ResolvedZone, cfg.RecordNameFormat, zoneID)
+		if err != nil {
+			return nil, err
+		}
+		if created.listedIn(records) {
+			challengeLogger(ch).info("TXT record created by Present is visible for clean up", field("zone", zoneID), field("polls", polls))
+			return records, nil
+		}
+	}
+	challengeLogger(ch).warning("TXT record created by Present is not listed by the bunny.net API, it may be left behind",
+		field("zone", zoneID), field("record", recordName), field("recordID", created.id))
+	return records, nil
+}
+
+func hasRecordID(records []bunny.DNSRecord, id int64) bool {
+	for _, r := range records {
+		if r.ID != nil && *r.ID == id {
+			return true
+		}
+	}
+	return false
+}
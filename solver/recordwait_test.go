@@ -0,0 +1,179 @@
+package solver
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// laggingAPI leaves records out of the zone for the first hiddenGets Get
+// calls after they were added.
+type laggingAPI struct {
+	*fakeDNSZoneAPI
+	hiddenGets int
+	hidden     map[int64]int
+}
+
+func (l *laggingAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	created, err := l.fakeDNSZoneAPI.AddDNSRecord(ctx, zoneID, opts)
+	if err == nil {
+		l.hidden[*created.ID] = l.hiddenGets
+	}
+	return created, err
+}
+
+func (l *laggingAPI) Get(ctx context.Context, id int64) (*bunny.DNSZone, error) {
+	zone, err := l.fakeDNSZoneAPI.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var visible []bunny.DNSRecord
+	for _, r := range zone.Records {
+		if l.hidden[*r.ID] > 0 {
+			l.hidden[*r.ID]--
+			continue
+		}
+		visible = append(visible, r)
+	}
+	zone.Records = visible
+	return zone, nil
+}
+
+func TestPresentWaitsForRecord(t *testing.T) {
+	api := &laggingAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), hiddenGets: 2, hidden: map[int64]int{}}
+	solver := newTestSolver(api)
+	solver.recordPollInterval = time.Millisecond
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"waitForRecord":true`)); err != nil {
+		t.Fatal(err)
+	}
+	// One Get to look for existing records, two that miss the new one and
+	// one that finds it.
+	if api.getCalls != 4 {
+		t.Errorf("Get called %d times, want 4", api.getCalls)
+	}
+}
+
+func TestPresentWaitForRecordTimeout(t *testing.T) {
+	api := &laggingAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), hiddenGets: 1000, hidden: map[int64]int{}}
+	solver := newTestSolver(api)
+	solver.recordPollInterval = time.Millisecond
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"waitForRecord":true,"recordVisibilityTimeout":"20ms"`)
+	if err := solver.Present(ch); err == nil {
+		t.Fatal("Present succeeded although the record never became visible")
+	}
+}
+
+func TestPresentDoesNotWaitForRecordByDefault(t *testing.T) {
+	api := &laggingAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), hiddenGets: 1000, hidden: map[int64]int{}}
+	if err := newTestSolver(api).Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	if api.getCalls != 1 {
+		t.Errorf("Get called %d times, want 1", api.getCalls)
+	}
+}
+
+func TestCleanUpWaitsForCreatedRecord(t *testing.T) {
+	tests := []struct {
+		name        string
+		hiddenGets  int
+		config      string
+		wantDeleted bool
+		wantGets    int
+	}{
+		// One Get in Present, one miss in CleanUp and two re-reads.
+		{"becomes visible", 2, "", true, 4},
+		{"window disabled", 2, `,"cleanUpVisibilityWindow":"0s"`, false, 2},
+		{"never visible", 1000, `,"cleanUpVisibilityWindow":"20ms"`, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &laggingAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), hiddenGets: tt.hiddenGets, hidden: map[int64]int{}}
+			solver := newTestSolver(api)
+			solver.recordPollInterval = time.Millisecond
+			ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config)
+			if err := solver.Present(ch); err != nil {
+				t.Fatal(err)
+			}
+			if err := solver.CleanUp(ch); err != nil {
+				t.Fatal(err)
+			}
+			if deleted := api.deleteCalls == 1; deleted != tt.wantDeleted {
+				t.Errorf("record deleted: %v, want %v", deleted, tt.wantDeleted)
+			}
+			if tt.wantGets > 0 && api.getCalls != tt.wantGets {
+				t.Errorf("Get called %d times, want %d", api.getCalls, tt.wantGets)
+			}
+			if _, ok := solver.createdRecords.get(newCreatedRecordKey(1, "_acme-challenge", "key")); ok {
+				t.Error("created record still remembered after CleanUp")
+			}
+		})
+	}
+}
+
+// idlessAPI adds records like laggingAPI but returns them with an ID of 0
+// or, when nilID is set, without one.
+type idlessAPI struct {
+	*laggingAPI
+	nilID bool
+}
+
+func (a *idlessAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	created, err := a.laggingAPI.AddDNSRecord(ctx, zoneID, opts)
+	if err != nil {
+		return nil, err
+	}
+	returned := *created
+	returned.ID = new(int64)
+	if a.nilID {
+		returned.ID = nil
+	}
+	return &returned, nil
+}
+
+func TestPresentAndCleanUpWithoutRecordID(t *testing.T) {
+	tests := []struct {
+		name   string
+		nilID  bool
+		config string
+	}{
+		{"nil ID, waiting for the record", true, `,"waitForRecord":true`},
+		{"zero ID, waiting for the record", false, `,"waitForRecord":true`},
+		{"nil ID, clean up waits", true, ""},
+		{"zero ID, clean up waits", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			lagging := &laggingAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), hiddenGets: 2, hidden: map[int64]int{}}
+			api := &idlessAPI{laggingAPI: lagging, nilID: tt.nilID}
+			solver := newTestSolver(api)
+			solver.recordPollInterval = time.Millisecond
+			ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config)
+			if err := solver.Present(ch); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), "bunny.net returned no record ID") {
+				t.Errorf("log %q does not warn about the missing record ID", buf.String())
+			}
+			if _, ok := solver.createdRecords.get(newCreatedRecordKey(1, "_acme-challenge", "key")); !ok {
+				t.Error("record created without an ID is not remembered for clean up")
+			}
+			if err := solver.CleanUp(ch); err != nil {
+				t.Fatal(err)
+			}
+			if lagging.deleteCalls != 1 || len(lagging.txtValues(1)) != 0 {
+				t.Errorf("got %d deletes and records %q, want the record deleted", lagging.deleteCalls, lagging.txtValues(1))
+			}
+		})
+	}
+}
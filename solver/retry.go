@@ -0,0 +1,123 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultAPIRetryBackoff    = time.Second
+	defaultMaintenanceBackoff = 30 * time.Second
+)
+
+// apiBackoff holds the initial delays before retrying a failed API call.
+// Zero values use the defaults.
+type apiBackoff struct {
+	transient   time.Duration
+	maintenance time.Duration
+}
+
+// maintenanceError is returned when bunny.net keeps answering 503, which it
+// does while its API is down for maintenance.
+type maintenanceError struct {
+	err error
+}
+
+func (e *maintenanceError) Error() string {
+	return fmt.Sprintf("bunny.net API is in maintenance or temporarily unavailable (HTTP 503), try again later: %v", e.err)
+}
+
+func (e *maintenanceError) Unwrap() error {
+	return e.err
+}
+
+// isRetryable reports whether err is a failure bunny.net usually recovers
+// from on its own.
+func isRetryable(err error) bool {
+	switch statusCode(err) {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return isUnsent(err)
+}
+
+// isUnsent reports whether err means the request never reached bunny.net,
+// because the API's name could not be resolved or no connection could be
+// made. Such a call had no effect and can always be repeated.
+func isUnsent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryPolicy says whether retry may repeat a call whose request may have
+// reached bunny.net. The zero value is for idempotent calls such as reads
+// and deletes, which are repeated freely.
+type retryPolicy struct {
+	// landed is set for calls that must not be repeated once they took
+	// effect, such as adding a record, which would add a duplicate. It
+	// is asked before each repeat whether the failed call took effect
+	// after all; the call is only repeated when it reports that it did
+	// not, and retry succeeds when it did.
+	landed func() (bool, error)
+}
+
+// idempotent is the retryPolicy of calls that can be repeated freely.
+var idempotent = retryPolicy{}
+
+// retry calls f until it succeeds, fails with an error that is not
+// retryable or has been retried retries times, doubling the delay after
+// each attempt. A 503 gets the much longer maintenance delay, and when the
+// last attempt fails with one the error says so. Calls that are not
+// idempotent are only repeated as policy allows.
+func retry(ctx context.Context, retries int, backoff apiBackoff, policy retryPolicy, logger fieldLogger, f func() error) error {
+	transient, maintenance := backoff.transient, backoff.maintenance
+	if transient == 0 {
+		transient = defaultAPIRetryBackoff
+	}
+	if maintenance == 0 {
+		maintenance = defaultMaintenanceBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		unavailable := statusCode(err) == http.StatusServiceUnavailable
+		if attempt >= retries {
+			if unavailable {
+				return &maintenanceError{err}
+			}
+			return err
+		}
+		if policy.landed != nil && !isUnsent(err) {
+			landed, checkErr := policy.landed()
+			if checkErr != nil {
+				// Repeating the call blindly could apply it twice.
+				logger.warning("bunny.net API call failed and could not be checked, not retrying", append([]logField{field("error", err), field("checkError", checkErr)}, apiErrorFields(err)...)...)
+				return err
+			}
+			if landed {
+				logger.info("bunny.net API call failed but took effect", append([]logField{field("error", err)}, apiErrorFields(err)...)...)
+				return nil
+			}
+		}
+		delay := transient << attempt
+		if unavailable {
+			delay = maintenance << attempt
+		}
+		logger.warning("bunny.net API call failed, retrying", append([]logField{field("delay", delay), field("error", err)}, apiErrorFields(err)...)...)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
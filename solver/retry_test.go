@@ -0,0 +1,224 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// unavailableAPI fails the first failures zone lists with status.
+type unavailableAPI struct {
+	*fakeDNSZoneAPI
+	status   int
+	failures int
+}
+
+func (u *unavailableAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	if u.failures > 0 {
+		u.failures--
+		u.listCalls++
+		return nil, &bunny.HTTPError{StatusCode: u.status}
+	}
+	return u.fakeDNSZoneAPI.List(ctx, opts)
+}
+
+func TestPresentRetriesTransientErrors(t *testing.T) {
+	tests := []struct {
+		name            string
+		status          int
+		failures        int
+		wantCalls       int
+		wantMaintenance bool
+		wantErr         bool
+	}{
+		{"bad gateway recovers", http.StatusBadGateway, 2, 3, false, false},
+		{"maintenance recovers", http.StatusServiceUnavailable, 1, 2, false, false},
+		{"maintenance persists", http.StatusServiceUnavailable, 10, 3, true, true},
+		{"gateway timeout persists", http.StatusGatewayTimeout, 10, 3, false, true},
+		{"client error not retried", http.StatusBadRequest, 10, 1, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &unavailableAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), status: tt.status, failures: tt.failures}
+			err := newTestSolver(api).Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", ""))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			var maintenance *maintenanceError
+			if errors.As(err, &maintenance) != tt.wantMaintenance {
+				t.Errorf("got error %v, want maintenance error %v", err, tt.wantMaintenance)
+			}
+			if tt.wantMaintenance && !strings.Contains(err.Error(), "maintenance") {
+				t.Errorf("error %q does not mention maintenance", err)
+			}
+			if api.listCalls != tt.wantCalls {
+				t.Errorf("List called %d times, want %d", api.listCalls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var calls []time.Time
+	fail := func(status int) func() error {
+		return func() error {
+			calls = append(calls, time.Now())
+			return &bunny.HTTPError{StatusCode: status}
+		}
+	}
+	backoff := apiBackoff{transient: time.Millisecond, maintenance: 20 * time.Millisecond}
+
+	retry(context.Background(), 1, backoff, idempotent, rootLogger, fail(http.StatusBadGateway))
+	if d := calls[1].Sub(calls[0]); d >= 20*time.Millisecond {
+		t.Errorf("gateway error retried after %s, want the short backoff", d)
+	}
+	calls = nil
+	retry(context.Background(), 1, backoff, idempotent, rootLogger, fail(http.StatusServiceUnavailable))
+	if d := calls[1].Sub(calls[0]); d < 20*time.Millisecond {
+		t.Errorf("503 retried after %s, want at least the maintenance backoff", d)
+	}
+}
+
+// lostResponseAPI fails the first failures writes with err. With applied
+// set, the write still takes effect, as when the response is lost on a
+// gateway after bunny.net processed the request.
+type lostResponseAPI struct {
+	*fakeDNSZoneAPI
+	err      error
+	failures int
+	applied  bool
+}
+
+func (l *lostResponseAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	if l.failures == 0 {
+		return l.fakeDNSZoneAPI.AddDNSRecord(ctx, zoneID, opts)
+	}
+	l.failures--
+	if l.applied {
+		l.fakeDNSZoneAPI.AddDNSRecord(ctx, zoneID, opts)
+	} else {
+		l.addCalls++
+	}
+	return nil, l.err
+}
+
+func (l *lostResponseAPI) DeleteDNSRecord(ctx context.Context, zoneID int64, recordID int64) error {
+	if l.failures == 0 {
+		if l.zone(zoneID) != nil && !l.hasRecord(zoneID, recordID) {
+			l.deleteCalls++
+			// bunny.net answers a delete of a missing record with 400.
+			return &bunny.APIError{HTTPError: bunny.HTTPError{StatusCode: http.StatusBadRequest}, ErrorKey: "dnszone.record.not_found"}
+		}
+		return l.fakeDNSZoneAPI.DeleteDNSRecord(ctx, zoneID, recordID)
+	}
+	l.failures--
+	if l.applied {
+		l.fakeDNSZoneAPI.DeleteDNSRecord(ctx, zoneID, recordID)
+	} else {
+		l.deleteCalls++
+	}
+	return l.err
+}
+
+func (l *lostResponseAPI) hasRecord(zoneID, recordID int64) bool {
+	for _, r := range l.zone(zoneID).Records {
+		if *r.ID == recordID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPresentDoesNotDuplicateRetriedAdds(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	tests := []struct {
+		name         string
+		err          error
+		applied      bool
+		wantAddCalls int
+		wantGetCalls int
+	}{
+		// The zone check finds the record, so the add is not repeated.
+		{"response lost after add", &bunny.HTTPError{StatusCode: http.StatusBadGateway}, true, 1, 2},
+		{"add failed at the gateway", &bunny.HTTPError{StatusCode: http.StatusBadGateway}, false, 2, 2},
+		// A request that was never sent is repeated without a check.
+		{"connection refused", dialErr, false, 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &lostResponseAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), err: tt.err, failures: 1, applied: tt.applied}
+			if err := newTestSolver(api).Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+				t.Fatal(err)
+			}
+			if got := api.txtValues(1); len(got) != 1 {
+				t.Errorf("zone holds TXT records %q, want exactly one", got)
+			}
+			if api.addCalls != tt.wantAddCalls || api.getCalls != tt.wantGetCalls {
+				t.Errorf("made %d add and %d Get calls, want %d and %d", api.addCalls, api.getCalls, tt.wantAddCalls, tt.wantGetCalls)
+			}
+		})
+	}
+}
+
+func TestCleanUpRetriesDeleteThatTookEffect(t *testing.T) {
+	api := &lostResponseAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), err: &bunny.HTTPError{StatusCode: http.StatusGatewayTimeout}, applied: true}
+	api.zones[0].Records = []bunny.DNSRecord{txtRecord(1, "_acme-challenge", "key")}
+	api.failures = 1
+	if err := newTestSolver(api).CleanUp(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	if api.deleteCalls != 2 {
+		t.Errorf("made %d delete calls, want the failed one and a repeat", api.deleteCalls)
+	}
+	if got := api.txtValues(1); len(got) != 0 {
+		t.Errorf("zone still holds TXT records %q", got)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	backoff := apiBackoff{transient: time.Millisecond, maintenance: time.Millisecond}
+	gatewayErr := &bunny.HTTPError{StatusCode: http.StatusBadGateway}
+	tests := []struct {
+		name      string
+		landed    func() (bool, error)
+		wantCalls int
+		wantErr   bool
+	}{
+		{"idempotent", nil, 3, true},
+		{"did not land", func() (bool, error) { return false, nil }, 3, true},
+		{"landed", func() (bool, error) { return true, nil }, 1, false},
+		{"check failed", func() (bool, error) { return false, errors.New("zone read failed") }, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retry(context.Background(), 2, backoff, retryPolicy{landed: tt.landed}, rootLogger, func() error {
+				calls++
+				return gatewayErr
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("made %d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRepeatedDeleteOfMissingRecordSucceeds(t *testing.T) {
+	api := &lostResponseAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), err: &bunny.HTTPError{StatusCode: http.StatusGatewayTimeout}, applied: true}
+	api.zones[0].Records = []bunny.DNSRecord{txtRecord(1, "_acme-challenge", "key")}
+	api.failures = 1
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")
+	client := newTestSolver(api).instrument(api, ch, bunnyConfig{})
+	if err := client.DeleteDNSRecord(context.Background(), 1, 1); err != nil {
+		t.Errorf("repeated delete of a record the first attempt removed returned %v", err)
+	}
+}
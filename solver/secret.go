@@ -0,0 +1,95 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultSecretTimeout      = 10 * time.Second
+	defaultSecretRetries      = 3
+	defaultSecretRetryBackoff = 500 * time.Millisecond
+)
+
+// getSecret reads a Secret from the Secret cache, or, when it is not
+// cached, from the API server, retrying with doubling backoff while the
+// Kubernetes API server is overloaded or unreachable. Errors such as a
+// missing Secret or missing RBAC permissions are returned right away.
+func (c *bunnySolver) getSecret(ctx context.Context, namespace, name string, cfg bunnyConfig) (*corev1.Secret, error) {
+	if secret, ok := c.secrets.get(namespace, name); ok {
+		return secret, nil
+	}
+	timeout := cfg.SecretTimeout.Duration
+	if timeout <= 0 {
+		timeout = defaultSecretTimeout
+	}
+	retries := defaultSecretRetries
+	if cfg.SecretRetries != nil {
+		retries = *cfg.SecretRetries
+	}
+	backoff := c.secretRetryBackoff
+	if backoff == 0 {
+		backoff = defaultSecretRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		getCtx, cancel := context.WithTimeout(ctx, timeout)
+		secret, err := c.client.CoreV1().Secrets(namespace).Get(getCtx, name, metav1.GetOptions{})
+		cancel()
+		if err == nil || !isTransientKubeError(err) || attempt >= retries {
+			return secret, err
+		}
+		rootLogger.warning("failed to read access key secret, retrying", field("secret", namespace+"/"+name),
+			field("delay", backoff), field("error", err))
+		if sleepContext(ctx, backoff) != nil {
+			return secret, err
+		}
+		backoff *= 2
+	}
+}
+
+// isTransientKubeError reports whether err is a Kubernetes API failure
+// that is likely to go away on its own.
+func isTransientKubeError(err error) bool {
+	var netErr net.Error
+	return apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		apierrors.IsInternalError(err) ||
+		apierrors.IsUnexpectedServerError(err) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr)
+}
+
+// similarSecretKey returns the only key of data that equals key when case
+// and the separators "-", "_" and "." are ignored, such as "api-key" for
+// "apiKey". It fails if there is no such key or more than one.
+func similarSecretKey(data map[string][]byte, key string) (string, error) {
+	var matches []string
+	for k := range data {
+		if foldSecretKey(k) == foldSecretKey(key) {
+			matches = append(matches, k)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("key not found %q", key)
+	case 1:
+		return matches[0], nil
+	}
+	sort.Strings(matches)
+	return "", fmt.Errorf("key not found %q, and it is ambiguous which of %q was meant", key, matches)
+}
+
+func foldSecretKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "", ".", "").Replace(key))
+}
@@ -0,0 +1,114 @@
+package solver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// failSecretGets makes the first n Secret reads of solver's clientset fail
+// with err and returns a pointer to the number of reads made.
+func failSecretGets(solver *bunnySolver, n int, err error) *int {
+	calls := 0
+	solver.client.(*fake.Clientset).PrependReactor("get", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		if calls <= n {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	return &calls
+}
+
+func TestGetSecretRetries(t *testing.T) {
+	secrets := schema.GroupResource{Resource: "secrets"}
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		config    string
+		wantErr   bool
+		wantCalls int
+	}{
+		{"transient error", 2, apierrors.NewServiceUnavailable("overloaded"), "", false, 3},
+		{"too many requests", 1, apierrors.NewTooManyRequests("slow down", 1), "", false, 2},
+		{"retries exhausted", 5, apierrors.NewInternalError(errors.New("etcd unavailable")), `,"secretRetries":1`, true, 2},
+		{"not found", 5, apierrors.NewNotFound(secrets, "bunny-credentials"), "", true, 1},
+		{"forbidden", 5, apierrors.NewForbidden(secrets, "bunny-credentials", nil), "", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			solver := newTestSolver(newFakeDNSZoneAPI("example.com"))
+			calls := failSecretGets(solver, tt.failures, tt.err)
+			ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config)
+			cfg, err := solver.loadConfig(ch.Config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, _, err := solver.getAccessKeyFromSecret(context.Background(), cfg.AccessKeySecretRef, "default", cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("read the secret, want an error")
+				}
+			} else if err != nil || key != "test-key" {
+				t.Errorf("got key %q, error %v, want test-key", key, err)
+			}
+			if *calls != tt.wantCalls {
+				t.Errorf("read the secret %d times, want %d", *calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestSimilarSecretKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		want    string
+		wantErr bool
+	}{
+		{"other case", []string{"AccessKey"}, "AccessKey", false},
+		{"separators", []string{"access-key", "other"}, "access-key", false},
+		{"no similar key", []string{"token"}, "", true},
+		{"ambiguous", []string{"access-key", "access_key"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[string][]byte{}
+			for _, k := range tt.keys {
+				data[k] = []byte("value")
+			}
+			got, err := similarSecretKey(data, "accessKey")
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("got %q, error %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetAccessKeyFromSimilarSecretKey(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	solver := newTestSolver(newFakeDNSZoneAPI("example.com"))
+	ref := corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "bunny-credentials"}, Key: "access-key"}
+	key, _, err := solver.getAccessKeyFromSecret(context.Background(), ref, "default", bunnyConfig{})
+	if err != nil || key != "test-key" {
+		t.Fatalf("got key %q, error %v, want test-key", key, err)
+	}
+	if !strings.Contains(buf.String(), "referenced=access-key used=accessKey") {
+		t.Errorf("log %q does not warn about the key mismatch", buf.String())
+	}
+}
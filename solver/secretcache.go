@@ -0,0 +1,95 @@
+package solver
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// secretCacheSyncWarning is how long an informer may take to fill its
+// cache before the webhook warns that it cannot list or watch Secrets.
+var secretCacheSyncWarning = time.Minute
+
+// secretCache serves Secret reads from informers that watch the Secrets of
+// a fixed set of namespaces, so that a busy webhook does not read the
+// Secret of every challenge from the API server. Each namespace has its
+// own informer, as the webhook's RBAC usually only allows it to list and
+// watch Secrets in a few namespaces rather than cluster-wide.
+type secretCache struct {
+	listers map[string]corelisters.SecretNamespaceLister
+	synced  map[string]cache.InformerSynced
+}
+
+// newSecretCache starts informers for the Secrets of namespaces, which run
+// until stop is closed.
+func newSecretCache(client kubernetes.Interface, namespaces []string, stop <-chan struct{}) *secretCache {
+	sc := &secretCache{
+		listers: make(map[string]corelisters.SecretNamespaceLister, len(namespaces)),
+		synced:  make(map[string]cache.InformerSynced, len(namespaces)),
+	}
+	for _, namespace := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(namespace))
+		secrets := factory.Core().V1().Secrets()
+		sc.listers[namespace] = secrets.Lister().Secrets(namespace)
+		sc.synced[namespace] = secrets.Informer().HasSynced
+		factory.Start(stop)
+		go warnUnsyncedSecretCache(namespace, sc.synced[namespace], stop)
+	}
+	return sc
+}
+
+// warnUnsyncedSecretCache logs once when the informer of namespace has not
+// filled its cache in time, which usually means the webhook may not list
+// or watch Secrets there. Reads fall back to getting the Secret directly.
+func warnUnsyncedSecretCache(namespace string, synced cache.InformerSynced, stop <-chan struct{}) {
+	deadline := time.NewTimer(secretCacheSyncWarning)
+	defer deadline.Stop()
+	poll := time.NewTicker(100 * time.Millisecond)
+	defer poll.Stop()
+	for !synced() {
+		select {
+		case <-stop:
+			return
+		case <-deadline.C:
+			rootLogger.warning("secret cache has not synced, reading Secrets directly; check that the webhook may list and watch Secrets in the namespace",
+				field("namespace", namespace), field("after", secretCacheSyncWarning))
+			return
+		case <-poll.C:
+		}
+	}
+	rootLogger.debug("secret cache synced", field("namespace", namespace))
+}
+
+// get returns a copy of the cached Secret namespace/name. It is false when
+// the namespace is not cached, its informer has not synced yet or the
+// Secret is not in the cache, for example because it was just created; the
+// caller then reads it from the API server.
+func (sc *secretCache) get(namespace, name string) (*corev1.Secret, bool) {
+	if sc == nil {
+		return nil, false
+	}
+	lister, ok := sc.listers[namespace]
+	if !ok || !sc.synced[namespace]() {
+		return nil, false
+	}
+	secret, err := lister.Get(name)
+	if err != nil {
+		secretCacheReads.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	secretCacheReads.WithLabelValues("hit").Inc()
+	return secret.DeepCopy(), true
+}
+
+// startSecretCache starts the informers for SECRET_CACHE_NAMESPACES, if
+// any, once the Kubernetes client is set up.
+func (c *bunnySolver) startSecretCache() {
+	if len(c.secretCacheNamespaces) == 0 {
+		return
+	}
+	c.secrets = newSecretCache(c.client, c.secretCacheNamespaces, c.stop)
+}
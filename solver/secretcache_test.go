@@ -0,0 +1,90 @@
+package solver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// startTestSecretCache starts the Secret cache of solver for the default
+// namespace and waits for it to sync.
+func startTestSecretCache(t *testing.T, solver *bunnySolver) {
+	t.Helper()
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	solver.secretCacheNamespaces = []string{"default"}
+	solver.stop = stop
+	solver.startSecretCache()
+	deadline := time.Now().Add(5 * time.Second)
+	for !solver.secrets.synced["default"]() {
+		if time.Now().After(deadline) {
+			t.Fatal("secret cache did not sync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSecretCacheAvoidsGets(t *testing.T) {
+	solver := newTestSolver(newFakeDNSZoneAPI("example.com"))
+	gets := failSecretGets(solver, 0, nil)
+	startTestSecretCache(t, solver)
+	cfg, err := solver.loadConfig(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "").Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		key, _, err := solver.getAccessKeyFromSecret(context.Background(), cfg.AccessKeySecretRef, "default", cfg)
+		if err != nil || key != "test-key" {
+			t.Fatalf("got key %q, error %v, want test-key", key, err)
+		}
+	}
+	if *gets != 0 {
+		t.Errorf("read the cached secret %d times from the API server, want 0", *gets)
+	}
+
+	missing := cfg.AccessKeySecretRef
+	missing.Name = "other-credentials"
+	if _, _, err := solver.getAccessKeyFromSecret(context.Background(), missing, "default", cfg); err == nil {
+		t.Error("read a secret that does not exist")
+	}
+	if _, _, err := solver.getAccessKeyFromSecret(context.Background(), cfg.AccessKeySecretRef, "cert-manager", cfg); err == nil {
+		t.Error("read a secret that does not exist in an uncached namespace")
+	}
+	if *gets != 2 {
+		t.Errorf("read secrets missing from the cache %d times from the API server, want 2", *gets)
+	}
+}
+
+func TestSecretCacheSeesUpdates(t *testing.T) {
+	solver := newTestSolver(newFakeDNSZoneAPI("example.com"))
+	startTestSecretCache(t, solver)
+	cfg, err := solver.loadConfig(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "").Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := solver.client.CoreV1().Secrets("default")
+	secret, err := secrets.Get(context.Background(), "bunny-credentials", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Data["accessKey"] = []byte("rotated-key")
+	if _, err := secrets.Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		key, _, err := solver.getAccessKeyFromSecret(context.Background(), cfg.AccessKeySecretRef, "default", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key == "rotated-key" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still got key %q after the secret was updated", key)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
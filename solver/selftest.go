@@ -0,0 +1,113 @@
+package solver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// selfTestRecordPrefix starts the names of the records a self-test writes,
+// so that one left behind by a crash is obviously temporary.
+const selfTestRecordPrefix = "_cert-manager-webhook-bunny-self-test-"
+
+// selfTestValue is the value of self-test records.
+const selfTestValue = "cert-manager-webhook-bunny self-test, safe to delete"
+
+// selfTest runs a challenge's lifecycle with a temporary TXT record in the
+// zone: it resolves the zone, adds the record, reads it back and deletes
+// it, confirming that the access key behind client may do everything a
+// challenge needs. It returns the record's name. The record is deleted
+// even when reading it back fails.
+func (c *bunnySolver) selfTest(client DNSZoneAPI, zone string, cfg bunnyConfig) (string, error) {
+	zoneIDs, err := c.resolveZoneIds(context.Background(), client, zone, cfg)
+	if err != nil {
+		return "", fmt.Errorf("self-test could not resolve zone %s: %w", zone, err)
+	}
+	zoneID := zoneIDs[0]
+	now := time.Now()
+	name := fmt.Sprintf("%s%d", selfTestRecordPrefix, now.Unix())
+	ttl, err := c.challengeTTL(cfg, zone)
+	if err != nil {
+		return name, fmt.Errorf("self-test: %w", err)
+	}
+	created, err := client.AddDNSRecord(context.Background(), zoneID, txtRecordOptions(cfg, wireRecordName(cfg.RecordNameFormat, name, zone), selfTestValue, ttl, recordMarkerTag(now)))
+	if err != nil {
+		return name, fmt.Errorf("self-test could not add TXT record %s in zone %d: %w", name, zoneID, explainTTLRejection(err, ttl, zone))
+	}
+	if returnedRecordID(created) == nil {
+		return name, fmt.Errorf("self-test added TXT record %s in zone %d but bunny.net returned no record ID, delete it by hand", name, zoneID)
+	}
+	records, readErr := c.zoneRecords(client, zoneID)
+	if err := client.DeleteDNSRecord(context.Background(), zoneID, *created.ID); err != nil {
+		return name, fmt.Errorf("self-test could not delete TXT record %s (ID %d) in zone %d, delete it by hand: %w", name, *created.ID, zoneID, err)
+	}
+	if readErr != nil {
+		return name, fmt.Errorf("self-test could not read zone %d: %w", zoneID, readErr)
+	}
+	if !hasRecordID(records, *created.ID) {
+		return name, fmt.Errorf("self-test added TXT record %s (ID %d) but zone %d did not list it", name, *created.ID, zoneID)
+	}
+	return name, nil
+}
+
+// parseSecretKeyRef parses a Secret key reference written as
+// namespace/name/key.
+func parseSecretKeyRef(s string) (namespace string, ref corev1.SecretKeySelector, err error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", ref, fmt.Errorf("want namespace/name/key, got %q", s)
+	}
+	ref.Name, ref.Key = parts[1], parts[2]
+	return parts[0], ref, nil
+}
+
+// startSelfTest starts the self-test configured by SELF_TEST_ZONE and
+// SELF_TEST_SECRET, if any, in the background. Its outcome is only logged;
+// the webhook serves regardless.
+func (c *bunnySolver) startSelfTest() {
+	if c.selfTestZone == "" {
+		return
+	}
+	go c.logStartupSelfTest()
+}
+
+func (c *bunnySolver) logStartupSelfTest() {
+	logger := rootLogger.with(field("zone", c.selfTestZone), field("secret", c.selfTestSecret))
+	if err := c.runStartupSelfTest(); err != nil {
+		logger.error("startup self-test failed, challenges using this access key will likely fail", field("error", err))
+		return
+	}
+	logger.info("startup self-test passed, the access key can write, read and delete challenge records")
+}
+
+func (c *bunnySolver) runStartupSelfTest() error {
+	namespace, ref, err := parseSecretKeyRef(c.selfTestSecret)
+	if err != nil {
+		return err
+	}
+	config, err := json.Marshal(map[string]interface{}{"apiSecretRef": ref})
+	if err != nil {
+		return err
+	}
+	ch := &v1alpha1.ChallengeRequest{
+		ResourceNamespace: namespace,
+		ResolvedZone:      absoluteName(c.selfTestZone),
+		Config:            &extapi.JSON{Raw: config},
+	}
+	cfg, err := c.loadConfig(ch.Config)
+	if err != nil {
+		return err
+	}
+	client, err := c.newAPIClient(context.Background(), ch, cfg)
+	if err != nil {
+		return err
+	}
+	_, err = c.selfTest(client, ch.ResolvedZone, cfg)
+	return err
+}
@@ -0,0 +1,91 @@
+package solver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestRunCheckSelfTest(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	var out strings.Builder
+	args := []string{"--fqdn", "_acme-challenge.www.example.com", "--zone", "example.com", "--self-test"}
+	if err := runCheck(solver, args, "test-key", &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "self-test:   added, read back and deleted "+selfTestRecordPrefix) {
+		t.Errorf("output %q does not report the self-test", out.String())
+	}
+	if strings.Contains(out.String(), "nothing was written") {
+		t.Errorf("output %q claims nothing was written", out.String())
+	}
+	if api.addCalls != 1 || api.deleteCalls != 1 {
+		t.Errorf("self-test made %d adds and %d deletes, want 1 of each", api.addCalls, api.deleteCalls)
+	}
+	if values := api.txtValues(1); len(values) != 0 {
+		t.Errorf("self-test left records behind: %v", values)
+	}
+}
+
+// unlistingAPI accepts records but never lists them.
+type unlistingAPI struct{ *fakeDNSZoneAPI }
+
+func (u unlistingAPI) Get(ctx context.Context, id int64) (*bunny.DNSZone, error) {
+	zone, err := u.fakeDNSZoneAPI.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &bunny.DNSZone{ID: zone.ID, Domain: zone.Domain}, nil
+}
+
+func TestSelfTestDeletesUnlistedRecord(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	_, err := solver.selfTest(unlistingAPI{api}, "example.com.", bunnyConfig{})
+	if err == nil || !strings.Contains(err.Error(), "did not list it") {
+		t.Fatalf("self-test returned %v, want an error about the missing record", err)
+	}
+	if api.deleteCalls != 1 {
+		t.Errorf("self-test made %d deletes, want 1", api.deleteCalls)
+	}
+	if values := api.txtValues(1); len(values) != 0 {
+		t.Errorf("self-test left records behind: %v", values)
+	}
+}
+
+func TestSelfTestRejectedKey(t *testing.T) {
+	solver := newTestSolver(&rejectedAPI{})
+	if _, err := solver.selfTest(&rejectedAPI{}, "example.com.", bunnyConfig{}); err == nil {
+		t.Fatal("self-test succeeded with a rejected key")
+	}
+}
+
+func TestStartupSelfTest(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr bool
+	}{
+		{"valid secret", "default/bunny-credentials/accessKey", false},
+		{"missing secret", "default/other-credentials/accessKey", true},
+		{"malformed reference", "bunny-credentials/accessKey", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			solver := newTestSolver(api)
+			solver.selfTestZone = "example.com"
+			solver.selfTestSecret = tt.secret
+			err := solver.runStartupSelfTest()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("startup self-test returned %v, want error: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (api.addCalls != 1 || api.deleteCalls != 1) {
+				t.Errorf("self-test made %d adds and %d deletes, want 1 of each", api.addCalls, api.deleteCalls)
+			}
+		})
+	}
+}
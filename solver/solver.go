@@ -0,0 +1,70 @@
+package solver
+
+import (
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Options are the dependencies of a Solver that embedding programs can
+// replace. The zero value uses the same dependencies as the webhook.
+type Options struct {
+	// Name is the solver name Issuers refer to in solverName. It defaults
+	// to "bunny"; programs serving more than one bunny.net solver, for
+	// example with different settings, give each its own name.
+	Name string
+	// KubeClient reads the Secrets named in Issuer configs. When nil,
+	// Initialize builds one from the rest config cert-manager passes.
+	KubeClient kubernetes.Interface
+	// NewDNSZoneAPI returns the bunny.net client for an access key. When
+	// nil, the bunny-go client is used.
+	NewDNSZoneAPI func(accessKey string) DNSZoneAPI
+}
+
+// Solver is the bunny.net DNS-01 solver. It implements cert-manager's
+// webhook.Solver interface.
+type Solver struct {
+	c *bunnySolver
+}
+
+// New returns a Solver for settings, which are checked the same way the
+// webhook checks its environment. Unlike Main, New leaves process-wide
+// state such as logging, metrics and the HTTP transport alone.
+func New(settings Settings, opts Options) (*Solver, error) {
+	if err := validateStartupConfig(settings); err != nil {
+		return nil, err
+	}
+	c := newBunnySolver(settings)
+	c.name = opts.Name
+	c.client = opts.KubeClient
+	c.newClient = opts.NewDNSZoneAPI
+	return &Solver{c: c}, nil
+}
+
+// Name returns the solver name Issuers refer to, Options.Name or "bunny".
+func (s *Solver) Name() string {
+	return s.c.Name()
+}
+
+// Initialize is called by cert-manager before the solver is used. It
+// keeps Options.KubeClient when one was given.
+func (s *Solver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	if s.c.client != nil {
+		s.c.stop = stopCh
+		s.c.startSecretCache()
+		s.c.startSelfTest()
+		return nil
+	}
+	return s.c.Initialize(kubeClientConfig, stopCh)
+}
+
+// Present publishes the TXT record for ch.
+func (s *Solver) Present(ch *v1alpha1.ChallengeRequest) error {
+	return s.c.Present(ch)
+}
+
+// CleanUp removes the TXT record for ch, leaving the records of other
+// challenges for the same name in place.
+func (s *Solver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
+	return s.c.CleanUp(ch)
+}
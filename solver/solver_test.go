@@ -0,0 +1,245 @@
+package solver_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	bunny "github.com/simplesurance/bunny-go"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+
+	"gitlab.com/digilol/cert-manager-webhook-bunny/solver"
+)
+
+var _ webhook.Solver = (*solver.Solver)(nil)
+
+// zoneAPI is a single in-memory bunny.net zone, written against the public
+// DNSZoneAPI interface only.
+type zoneAPI struct {
+	zone   bunny.DNSZone
+	nextID int64
+}
+
+func newZoneAPI(domain string) *zoneAPI {
+	id := int64(1)
+	return &zoneAPI{zone: bunny.DNSZone{ID: &id, Domain: &domain}, nextID: 100}
+}
+
+func (z *zoneAPI) Get(ctx context.Context, id int64) (*bunny.DNSZone, error) {
+	if id != *z.zone.ID {
+		return nil, &bunny.HTTPError{StatusCode: http.StatusNotFound}
+	}
+	cp := z.zone
+	cp.Records = append([]bunny.DNSRecord(nil), z.zone.Records...)
+	return &cp, nil
+}
+
+func (z *zoneAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	cp := z.zone
+	cp.Records = nil
+	hasMore := false
+	return &bunny.DNSZones{Items: []*bunny.DNSZone{&cp}, HasMoreItems: &hasMore}, nil
+}
+
+func (z *zoneAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	id := z.nextID
+	z.nextID++
+	r := bunny.DNSRecord{ID: &id, Type: opts.Type, Name: opts.Name, Value: opts.Value, TTL: opts.TTL, Tag: opts.Tag}
+	z.zone.Records = append(z.zone.Records, r)
+	return &r, nil
+}
+
+func (z *zoneAPI) UpdateDNSRecord(ctx context.Context, zoneID, recordID int64, opts *bunny.AddOrUpdateDNSRecordOptions) error {
+	for i, r := range z.zone.Records {
+		if *r.ID == recordID {
+			z.zone.Records[i].Value = opts.Value
+			z.zone.Records[i].TTL = opts.TTL
+			z.zone.Records[i].Tag = opts.Tag
+		}
+	}
+	return nil
+}
+
+func (z *zoneAPI) DeleteDNSRecord(ctx context.Context, zoneID, recordID int64) error {
+	kept := z.zone.Records[:0]
+	for _, r := range z.zone.Records {
+		if *r.ID != recordID {
+			kept = append(kept, r)
+		}
+	}
+	z.zone.Records = kept
+	return nil
+}
+
+func (z *zoneAPI) values() []string {
+	var values []string
+	for _, r := range z.zone.Records {
+		values = append(values, *r.Value)
+	}
+	return values
+}
+
+func newSolver(t *testing.T, api solver.DNSZoneAPI, settings solver.Settings) *solver.Solver {
+	t.Helper()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bunny-credentials", Namespace: "default"},
+		Data:       map[string][]byte{"accessKey": []byte("test-key")},
+	}
+	s, err := solver.New(settings, solver.Options{
+		KubeClient:    fake.NewSimpleClientset(secret),
+		NewDNSZoneAPI: func(string) solver.DNSZoneAPI { return api },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Initialize(nil, make(chan struct{})); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testSettings() solver.Settings {
+	settings := solver.DefaultSettings()
+	settings.GroupName = "acme.example.com"
+	return settings
+}
+
+func TestSolverPresentAndCleanUp(t *testing.T) {
+	api := newZoneAPI("example.com")
+	s := newSolver(t, api, testSettings())
+	ch := &v1alpha1.ChallengeRequest{
+		UID:               "test-uid",
+		Key:               "challenge-key",
+		ResourceNamespace: "default",
+		ResolvedFQDN:      "_acme-challenge.example.com.",
+		ResolvedZone:      "example.com.",
+		Config:            &extapi.JSON{Raw: []byte(`{"apiSecretRef":{"name":"bunny-credentials","key":"accessKey"}}`)},
+	}
+
+	if s.Name() != "bunny" {
+		t.Errorf("Name() = %q, want bunny", s.Name())
+	}
+	if err := s.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.values(); len(got) != 1 || got[0] != "challenge-key" {
+		t.Fatalf("records after Present = %q, want the challenge key", got)
+	}
+	if err := s.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.values(); len(got) != 0 {
+		t.Errorf("records after CleanUp = %q, want none", got)
+	}
+}
+
+func TestNewValidatesSettings(t *testing.T) {
+	settings := testSettings()
+	settings.GroupName = ""
+	settings.MinTTL = 0
+	_, err := solver.New(settings, solver.Options{})
+	if err == nil {
+		t.Fatal("New accepted invalid settings")
+	}
+	for _, want := range []string{"GROUP_NAME", "MIN_RECORD_TTL"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestSettingsFromEnvDefaults(t *testing.T) {
+	for _, name := range []string{"MIN_RECORD_TTL", "MAX_RECORD_TTL", "LOG_LEVEL", "METRICS_BIND_POLICY"} {
+		t.Setenv(name, "")
+	}
+	got, want := solver.SettingsFromEnv(), solver.DefaultSettings()
+	if got.MinTTL != want.MinTTL || got.MaxTTL != want.MaxTTL || got.LogLevel != want.LogLevel || got.MetricsBindPolicy != want.MetricsBindPolicy {
+		t.Errorf("SettingsFromEnv() = %+v, want the defaults %+v", got, want)
+	}
+}
+
+// otherSolver stands in for the solver of another DNS provider bundled
+// into the same webhook binary.
+type otherSolver struct{ presented []string }
+
+func (o *otherSolver) Name() string { return "other" }
+
+func (o *otherSolver) Present(ch *v1alpha1.ChallengeRequest) error {
+	o.presented = append(o.presented, ch.ResolvedFQDN)
+	return nil
+}
+
+func (o *otherSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error { return nil }
+
+func (o *otherSolver) Initialize(*rest.Config, <-chan struct{}) error { return nil }
+
+func TestSolverAlongsideOtherSolvers(t *testing.T) {
+	settings := testSettings()
+	strict := settings
+	strict.MinTTL = 600
+	production, staging := newZoneAPI("example.com"), newZoneAPI("example.com")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "bunny-credentials", Namespace: "default"},
+		Data:       map[string][]byte{"accessKey": []byte("test-key")},
+	}
+	newNamed := func(name string, settings solver.Settings, api solver.DNSZoneAPI) webhook.Solver {
+		s, err := solver.New(settings, solver.Options{
+			Name:          name,
+			KubeClient:    fake.NewSimpleClientset(secret),
+			NewDNSZoneAPI: func(string) solver.DNSZoneAPI { return api },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	other := &otherSolver{}
+	// This is the list a bundling binary passes to cmd.RunWebhookServer,
+	// which initializes every solver and routes challenges by name.
+	solvers := []webhook.Solver{
+		newNamed("", settings, production),
+		newNamed("bunny-staging", strict, staging),
+		other,
+	}
+	byName := map[string]webhook.Solver{}
+	stop := make(chan struct{})
+	defer close(stop)
+	for _, s := range solvers {
+		if _, ok := byName[s.Name()]; ok {
+			t.Fatalf("two solvers are named %q", s.Name())
+		}
+		byName[s.Name()] = s
+		if err := s.Initialize(nil, stop); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ch := &v1alpha1.ChallengeRequest{
+		UID:               "test-uid",
+		Key:               "challenge-key",
+		ResourceNamespace: "default",
+		ResolvedFQDN:      "_acme-challenge.example.com.",
+		ResolvedZone:      "example.com.",
+		Config:            &extapi.JSON{Raw: []byte(`{"apiSecretRef":{"name":"bunny-credentials","key":"accessKey"},"ttl":60}`)},
+	}
+	for _, name := range []string{"bunny", "bunny-staging", "other"} {
+		if err := byName[name].Present(ch); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if len(production.zone.Records) != 1 || *production.zone.Records[0].TTL != 60 {
+		t.Errorf("bunny wrote %+v, want one record with the Issuer's TTL", production.zone.Records)
+	}
+	if len(staging.zone.Records) != 1 || *staging.zone.Records[0].TTL != 600 {
+		t.Errorf("bunny-staging wrote %+v, want one record with its own minimum TTL", staging.zone.Records)
+	}
+	if len(other.presented) != 1 {
+		t.Errorf("other solver presented %v, want the challenge once", other.presented)
+	}
+}
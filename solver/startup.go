@@ -0,0 +1,362 @@
+package solver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Settings holds the webhook-wide settings read from the environment
+// at startup, as opposed to the per-Issuer bunnyConfig. Programs that
+// embed the solver can fill them in directly, starting from
+// DefaultSettings.
+type Settings struct {
+	GroupName string
+	// MetricsAddress is the address to serve Prometheus metrics on.
+	// Metrics are not served when it is empty.
+	MetricsAddress string
+	// MetricsBindPolicy decides what happens when MetricsAddress cannot
+	// be bound: "retry", "ignore" or "fail".
+	MetricsBindPolicy string
+	// StrictChallengeKeys rejects TXT values that do not look like an
+	// ACME DNS-01 key, so the webhook cannot be used to write arbitrary
+	// TXT content into managed zones.
+	StrictChallengeKeys bool
+	// SkipCleanUp turns CleanUp into a logged no-op, for disposable
+	// clusters that are torn down after issuance. Challenge records are
+	// left behind.
+	SkipCleanUp bool
+	// MinTTL and MaxTTL bound the TTL, in seconds, of every challenge
+	// record regardless of what an Issuer configures.
+	MinTTL int32
+	MaxTTL int32
+	// MaxConcurrentPresents caps how many Present operations run at the
+	// same time; zero means no limit.
+	MaxConcurrentPresents int32
+	// MaxConcurrentCleanUps caps how many CleanUp operations delete
+	// records at the same time; zero means no limit.
+	MaxConcurrentCleanUps int32
+	// ZoneScanStartPage is the page zone scans start at, for diagnosing
+	// large accounts. It is not meant for regular use.
+	ZoneScanStartPage int32
+	// LargeZoneRecords is the number of records above which a zone is
+	// warned about as costly to scan; zero disables the warning.
+	LargeZoneRecords int32
+	// LogFormat is "text" or "json".
+	LogFormat string
+	// LogLevel is the minimum level of log entries that are written, and
+	// LogMessageLevels overrides the levels of message categories.
+	LogLevel         string
+	LogMessageLevels map[string]string
+	// ConfigDefaults is the JSON form of the solver config defaults file
+	// named by CONFIG_FILE, if any.
+	ConfigDefaults []byte
+	// KubeAPICABundle holds extra PEM CA certificates to trust for the
+	// Kubernetes API, read from KUBE_API_CA_FILE.
+	KubeAPICABundle []byte
+	// BreakerFailures is how many bunny.net calls in a row must fail
+	// within BreakerWindow to open the circuit breaker for
+	// BreakerCooldown; zero disables the breaker.
+	BreakerFailures int32
+	BreakerWindow   time.Duration
+	BreakerCooldown time.Duration
+	// APIMaxIdleConnsPerHost, APIIdleConnTimeout and
+	// APITLSHandshakeTimeout tune the connection pool used for the
+	// bunny.net API.
+	APIMaxIdleConnsPerHost int32
+	APIIdleConnTimeout     time.Duration
+	APITLSHandshakeTimeout time.Duration
+	// NotifyURL receives a JSON POST after every successful Present and
+	// CleanUp, within NotifyTimeout. Nothing is sent when it is empty.
+	NotifyURL     string
+	NotifyTimeout time.Duration
+	// SelfTestZone and SelfTestSecret configure a self-test at startup
+	// that writes, reads back and deletes a temporary record in the zone
+	// with the access key in the Secret, given as namespace/name/key. Its
+	// outcome is only logged. No self-test runs when they are empty.
+	SelfTestZone   string
+	SelfTestSecret string
+	// SecretCacheNamespaces are the namespaces whose Secrets are watched
+	// by informers and read from their cache instead of the API server.
+	// The webhook needs RBAC to list and watch Secrets in each of them.
+	// Secrets are read directly when it is empty.
+	SecretCacheNamespaces []string
+
+	// parseErrors collects settings whose values could not be parsed.
+	parseErrors []string
+}
+
+const (
+	defaultMaxConcurrentPresents = 16
+	defaultMaxConcurrentCleanUps = 4
+	defaultLargeZoneRecords      = 5000
+)
+
+// DefaultSettings returns the Settings the webhook uses when none of its
+// environment variables are set.
+func DefaultSettings() Settings {
+	return Settings{
+		GroupName:              GroupName,
+		MetricsBindPolicy:      metricsBindRetry,
+		MinTTL:                 defaultMinTTL,
+		MaxTTL:                 defaultMaxTTL,
+		MaxConcurrentPresents:  defaultMaxConcurrentPresents,
+		MaxConcurrentCleanUps:  defaultMaxConcurrentCleanUps,
+		ZoneScanStartPage:      1,
+		LargeZoneRecords:       defaultLargeZoneRecords,
+		LogFormat:              logFormatText,
+		LogLevel:               levelInfo,
+		BreakerFailures:        defaultBreakerFailures,
+		BreakerWindow:          defaultBreakerWindow,
+		BreakerCooldown:        defaultBreakerCooldown,
+		APIMaxIdleConnsPerHost: defaultAPIMaxIdleConnsPerHost,
+		APIIdleConnTimeout:     defaultAPIIdleConnTimeout,
+		APITLSHandshakeTimeout: defaultAPITLSHandshakeTimeout,
+		NotifyTimeout:          defaultNotifyTimeout,
+	}
+}
+
+// SettingsFromEnv reads the Settings from the environment the way the
+// webhook binary does, falling back to DefaultSettings. Values that cannot
+// be parsed are reported by New.
+func SettingsFromEnv() Settings {
+	cfg := DefaultSettings()
+	cfg.MetricsAddress = os.Getenv("METRICS_BIND_ADDRESS")
+	cfg.NotifyURL = os.Getenv("NOTIFY_URL")
+	cfg.SelfTestZone = os.Getenv("SELF_TEST_ZONE")
+	cfg.SelfTestSecret = os.Getenv("SELF_TEST_SECRET")
+	cfg.SecretCacheNamespaces = splitList(os.Getenv("SECRET_CACHE_NAMESPACES"))
+	if v := os.Getenv("METRICS_BIND_POLICY"); v != "" {
+		cfg.MetricsBindPolicy = v
+	}
+	if v := os.Getenv("LOG_FORMAT"); v != "" {
+		cfg.LogFormat = v
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		cfg.LogLevel = v
+	}
+	if levels, err := parseMessageLevels(os.Getenv("LOG_MESSAGE_LEVELS")); err != nil {
+		cfg.parseErrors = append(cfg.parseErrors, fmt.Sprintf("LOG_MESSAGE_LEVELS: %v", err))
+	} else {
+		cfg.LogMessageLevels = levels
+	}
+	cfg.StrictChallengeKeys = cfg.envBool("STRICT_CHALLENGE_KEYS")
+	cfg.SkipCleanUp = cfg.envBool("SKIP_CLEANUP")
+	cfg.MinTTL = cfg.envInt32("MIN_RECORD_TTL", cfg.MinTTL)
+	cfg.MaxTTL = cfg.envInt32("MAX_RECORD_TTL", cfg.MaxTTL)
+	cfg.MaxConcurrentPresents = cfg.envInt32("MAX_CONCURRENT_PRESENTS", cfg.MaxConcurrentPresents)
+	cfg.MaxConcurrentCleanUps = cfg.envInt32("MAX_CONCURRENT_CLEANUPS", cfg.MaxConcurrentCleanUps)
+	cfg.ZoneScanStartPage = cfg.envInt32("ZONE_SCAN_START_PAGE", cfg.ZoneScanStartPage)
+	cfg.LargeZoneRecords = cfg.envInt32("LARGE_ZONE_RECORDS", cfg.LargeZoneRecords)
+	cfg.BreakerFailures = cfg.envInt32("CIRCUIT_BREAKER_FAILURES", cfg.BreakerFailures)
+	cfg.BreakerWindow = cfg.envDuration("CIRCUIT_BREAKER_WINDOW", cfg.BreakerWindow)
+	cfg.BreakerCooldown = cfg.envDuration("CIRCUIT_BREAKER_COOLDOWN", cfg.BreakerCooldown)
+	cfg.APIMaxIdleConnsPerHost = cfg.envInt32("API_MAX_IDLE_CONNS_PER_HOST", cfg.APIMaxIdleConnsPerHost)
+	cfg.APIIdleConnTimeout = cfg.envDuration("API_IDLE_CONN_TIMEOUT", cfg.APIIdleConnTimeout)
+	cfg.APITLSHandshakeTimeout = cfg.envDuration("API_TLS_HANDSHAKE_TIMEOUT", cfg.APITLSHandshakeTimeout)
+	cfg.NotifyTimeout = cfg.envDuration("NOTIFY_TIMEOUT", cfg.NotifyTimeout)
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		defaults, err := readConfigDefaults(path)
+		if err != nil {
+			cfg.parseErrors = append(cfg.parseErrors, fmt.Sprintf("CONFIG_FILE: %v", err))
+		}
+		cfg.ConfigDefaults = defaults
+	}
+	if path := os.Getenv("KUBE_API_CA_FILE"); path != "" {
+		bundle, err := readCABundle(path)
+		if err != nil {
+			cfg.parseErrors = append(cfg.parseErrors, fmt.Sprintf("KUBE_API_CA_FILE: %v", err))
+		}
+		cfg.KubeAPICABundle = bundle
+	}
+	return cfg
+}
+
+// readConfigDefaults reads a YAML or JSON file of solver config defaults,
+// typically mounted from a ConfigMap, and returns it as JSON. Unknown fields
+// are rejected so that typos do not go unnoticed.
+func readConfigDefaults(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := yaml.YAMLToJSON(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(defaults))
+	dec.DisallowUnknownFields()
+	var cfg bunnyConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if _, err := newRecordStrategy(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := validateZoneMinTTL(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := validatePropagationQuorum(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return defaults, nil
+}
+
+// splitList splits a comma-separated environment variable, dropping
+// surrounding whitespace and empty entries.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envInt32 parses the integer environment variable name, returning def when
+// it is unset.
+func (cfg *Settings) envInt32(name string, def int32) int32 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 32)
+	if err != nil {
+		cfg.parseErrors = append(cfg.parseErrors, fmt.Sprintf("%s: invalid integer %q", name, v))
+		return def
+	}
+	return int32(n)
+}
+
+// envDuration parses the duration environment variable name, such as
+// "30s", returning def when it is unset.
+func (cfg *Settings) envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		cfg.parseErrors = append(cfg.parseErrors, fmt.Sprintf("%s: invalid duration %q", name, v))
+		return def
+	}
+	return d
+}
+
+// envBool parses the boolean environment variable name, which defaults to
+// false when unset.
+func (cfg *Settings) envBool(name string) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		cfg.parseErrors = append(cfg.parseErrors, fmt.Sprintf("%s: invalid boolean %q", name, v))
+	}
+	return b
+}
+
+// validateStartupConfig checks every setting and reports all problems in a
+// single error, so they can be fixed in one go.
+func validateStartupConfig(cfg Settings) error {
+	problems := append([]string(nil), cfg.parseErrors...)
+	if cfg.GroupName == "" {
+		problems = append(problems, "GROUP_NAME must be specified")
+	}
+	if cfg.MetricsAddress != "" {
+		if err := validateListenAddress(cfg.MetricsAddress); err != nil {
+			problems = append(problems, fmt.Sprintf("METRICS_BIND_ADDRESS: %v", err))
+		}
+	}
+	switch cfg.MetricsBindPolicy {
+	case metricsBindRetry, metricsBindIgnore, metricsBindFail:
+	default:
+		problems = append(problems, fmt.Sprintf("METRICS_BIND_POLICY must be one of %s, %s or %s, got %q",
+			metricsBindRetry, metricsBindIgnore, metricsBindFail, cfg.MetricsBindPolicy))
+	}
+	if cfg.MinTTL < 1 {
+		problems = append(problems, fmt.Sprintf("MIN_RECORD_TTL must be positive, got %d", cfg.MinTTL))
+	}
+	if cfg.MaxTTL < cfg.MinTTL {
+		problems = append(problems, fmt.Sprintf("MAX_RECORD_TTL (%d) must not be below MIN_RECORD_TTL (%d)", cfg.MaxTTL, cfg.MinTTL))
+	}
+	if cfg.MaxConcurrentPresents < 0 {
+		problems = append(problems, fmt.Sprintf("MAX_CONCURRENT_PRESENTS must not be negative, got %d", cfg.MaxConcurrentPresents))
+	}
+	if cfg.MaxConcurrentCleanUps < 0 {
+		problems = append(problems, fmt.Sprintf("MAX_CONCURRENT_CLEANUPS must not be negative, got %d", cfg.MaxConcurrentCleanUps))
+	}
+	if cfg.ZoneScanStartPage < 1 {
+		problems = append(problems, fmt.Sprintf("ZONE_SCAN_START_PAGE must be positive, got %d", cfg.ZoneScanStartPage))
+	}
+	if cfg.LargeZoneRecords < 0 {
+		problems = append(problems, fmt.Sprintf("LARGE_ZONE_RECORDS must not be negative, got %d", cfg.LargeZoneRecords))
+	}
+	if cfg.BreakerFailures < 0 {
+		problems = append(problems, fmt.Sprintf("CIRCUIT_BREAKER_FAILURES must not be negative, got %d", cfg.BreakerFailures))
+	}
+	if cfg.BreakerFailures > 0 && (cfg.BreakerWindow <= 0 || cfg.BreakerCooldown <= 0) {
+		problems = append(problems, fmt.Sprintf("CIRCUIT_BREAKER_WINDOW (%s) and CIRCUIT_BREAKER_COOLDOWN (%s) must be positive",
+			cfg.BreakerWindow, cfg.BreakerCooldown))
+	}
+	if cfg.APIMaxIdleConnsPerHost < 0 {
+		problems = append(problems, fmt.Sprintf("API_MAX_IDLE_CONNS_PER_HOST must not be negative, got %d", cfg.APIMaxIdleConnsPerHost))
+	}
+	if cfg.APIIdleConnTimeout < 0 || cfg.APITLSHandshakeTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("API_IDLE_CONN_TIMEOUT (%s) and API_TLS_HANDSHAKE_TIMEOUT (%s) must not be negative",
+			cfg.APIIdleConnTimeout, cfg.APITLSHandshakeTimeout))
+	}
+	if cfg.NotifyURL != "" {
+		if err := validateNotifyURL(cfg.NotifyURL); err != nil {
+			problems = append(problems, fmt.Sprintf("NOTIFY_URL: %v", err))
+		}
+		if cfg.NotifyTimeout <= 0 {
+			problems = append(problems, fmt.Sprintf("NOTIFY_TIMEOUT must be positive, got %s", cfg.NotifyTimeout))
+		}
+	}
+	if (cfg.SelfTestZone == "") != (cfg.SelfTestSecret == "") {
+		problems = append(problems, "SELF_TEST_ZONE and SELF_TEST_SECRET must be set together")
+	} else if cfg.SelfTestSecret != "" {
+		if _, _, err := parseSecretKeyRef(cfg.SelfTestSecret); err != nil {
+			problems = append(problems, fmt.Sprintf("SELF_TEST_SECRET: %v", err))
+		}
+	}
+	for _, namespace := range cfg.SecretCacheNamespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			problems = append(problems, fmt.Sprintf("SECRET_CACHE_NAMESPACES: invalid namespace %q: %s", namespace, strings.Join(errs, ", ")))
+		}
+	}
+	if cfg.LogFormat != logFormatText && cfg.LogFormat != logFormatJSON {
+		problems = append(problems, fmt.Sprintf("LOG_FORMAT must be %s or %s, got %q", logFormatText, logFormatJSON, cfg.LogFormat))
+	}
+	if _, ok := levelRanks[cfg.LogLevel]; !ok {
+		problems = append(problems, fmt.Sprintf("LOG_LEVEL must be %s, %s, %s or %s, got %q",
+			levelDebug, levelInfo, levelWarning, levelError, cfg.LogLevel))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid startup configuration:\n  - %s", strings.Join(problems, "\n  - "))
+}
+
+func validateListenAddress(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
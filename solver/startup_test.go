@@ -0,0 +1,193 @@
+package solver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validStartupConfig returns a Settings that passes validation.
+func validStartupConfig() Settings {
+	return Settings{
+		GroupName:         "acme.example.com",
+		MetricsBindPolicy: metricsBindRetry,
+		MinTTL:            defaultMinTTL,
+		MaxTTL:            defaultMaxTTL,
+		ZoneScanStartPage: 1,
+		LogFormat:         logFormatText,
+		LogLevel:          levelInfo,
+	}
+}
+
+func TestValidateStartupConfig(t *testing.T) {
+	with := func(f func(*Settings)) Settings {
+		cfg := validStartupConfig()
+		f(&cfg)
+		return cfg
+	}
+
+	tests := []struct {
+		name     string
+		cfg      Settings
+		problems []string
+	}{
+		{"valid", validStartupConfig(), nil},
+		{"valid with metrics", with(func(c *Settings) { c.MetricsAddress = ":9402" }), nil},
+		{"missing group name", with(func(c *Settings) { c.GroupName = "" }), []string{"GROUP_NAME"}},
+		{"metrics address without port", with(func(c *Settings) { c.MetricsAddress = "localhost" }), []string{"METRICS_BIND_ADDRESS"}},
+		{"metrics address with bad port", with(func(c *Settings) { c.MetricsAddress = ":http2" }), []string{"METRICS_BIND_ADDRESS"}},
+		{"unknown metrics bind policy", with(func(c *Settings) { c.MetricsBindPolicy = "crash" }), []string{"METRICS_BIND_POLICY"}},
+		{"non-positive min TTL", with(func(c *Settings) { c.MinTTL = 0 }), []string{"MIN_RECORD_TTL"}},
+		{"max TTL below min TTL", with(func(c *Settings) { c.MinTTL, c.MaxTTL = 60, 30 }), []string{"MAX_RECORD_TTL"}},
+		{"negative present limit", with(func(c *Settings) { c.MaxConcurrentPresents = -1 }), []string{"MAX_CONCURRENT_PRESENTS"}},
+		{"negative clean up limit", with(func(c *Settings) { c.MaxConcurrentCleanUps = -1 }), []string{"MAX_CONCURRENT_CLEANUPS"}},
+		{"negative idle connections", with(func(c *Settings) { c.APIMaxIdleConnsPerHost = -1 }), []string{"API_MAX_IDLE_CONNS_PER_HOST"}},
+		{"zero zone scan start page", with(func(c *Settings) { c.ZoneScanStartPage = 0 }), []string{"ZONE_SCAN_START_PAGE"}},
+		{"negative large zone records", with(func(c *Settings) { c.LargeZoneRecords = -1 }), []string{"LARGE_ZONE_RECORDS"}},
+		{"self-test zone without secret", with(func(c *Settings) { c.SelfTestZone = "example.com" }), []string{"SELF_TEST_SECRET"}},
+		{"malformed self-test secret", with(func(c *Settings) {
+			c.SelfTestZone, c.SelfTestSecret = "example.com", "bunny-credentials"
+		}), []string{"SELF_TEST_SECRET"}},
+		{"invalid secret cache namespace", with(func(c *Settings) { c.SecretCacheNamespaces = []string{"default", "Cert_Manager"} }), []string{"SECRET_CACHE_NAMESPACES"}},
+		{"unknown log format", with(func(c *Settings) { c.LogFormat = "yaml" }), []string{"LOG_FORMAT"}},
+		{"notify URL without scheme", with(func(c *Settings) { c.NotifyURL, c.NotifyTimeout = "hooks.example.com/dns", time.Second }), []string{"NOTIFY_URL"}},
+		{"notify without timeout", with(func(c *Settings) { c.NotifyURL = "https://hooks.example.com/dns" }), []string{"NOTIFY_TIMEOUT"}},
+		{"unknown log level", with(func(c *Settings) { c.LogLevel = "verbose" }), []string{"LOG_LEVEL"}},
+		{"negative breaker failures", with(func(c *Settings) { c.BreakerFailures = -1 }), []string{"CIRCUIT_BREAKER_FAILURES"}},
+		{"breaker without cooldown", with(func(c *Settings) { c.BreakerFailures, c.BreakerWindow = 5, time.Minute }), []string{"CIRCUIT_BREAKER_COOLDOWN"}},
+		{"all problems reported", Settings{MetricsAddress: ":99999", MetricsBindPolicy: metricsBindRetry, MinTTL: 1, MaxTTL: 1}, []string{"GROUP_NAME", "METRICS_BIND_ADDRESS"}},
+		{"parse errors reported", with(func(c *Settings) { c.parseErrors = []string{"STRICT_CHALLENGE_KEYS: invalid boolean"} }), []string{"STRICT_CHALLENGE_KEYS"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStartupConfig(tt.cfg)
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, p := range tt.problems {
+				if !strings.Contains(err.Error(), p) {
+					t.Errorf("error %q does not mention %s", err, p)
+				}
+			}
+		})
+	}
+}
+
+func TestStartupConfigFromEnvBool(t *testing.T) {
+	t.Setenv("STRICT_CHALLENGE_KEYS", "true")
+	if cfg := SettingsFromEnv(); !cfg.StrictChallengeKeys || len(cfg.parseErrors) != 0 {
+		t.Errorf("got StrictChallengeKeys %v with errors %v", cfg.StrictChallengeKeys, cfg.parseErrors)
+	}
+	t.Setenv("STRICT_CHALLENGE_KEYS", "sometimes")
+	if cfg := SettingsFromEnv(); len(cfg.parseErrors) != 1 {
+		t.Errorf("got parse errors %v, want one", cfg.parseErrors)
+	}
+}
+
+func TestStartupConfigFromEnvDuration(t *testing.T) {
+	t.Setenv("CIRCUIT_BREAKER_COOLDOWN", "2m")
+	if cfg := SettingsFromEnv(); cfg.BreakerCooldown != 2*time.Minute || len(cfg.parseErrors) != 0 {
+		t.Errorf("got BreakerCooldown %s with errors %v", cfg.BreakerCooldown, cfg.parseErrors)
+	}
+	t.Setenv("CIRCUIT_BREAKER_COOLDOWN", "120")
+	if cfg := SettingsFromEnv(); len(cfg.parseErrors) != 1 {
+		t.Errorf("got parse errors %v, want one", cfg.parseErrors)
+	}
+}
+
+func TestStartupConfigFromEnvMessageLevels(t *testing.T) {
+	t.Setenv("LOG_MESSAGE_LEVELS", "recordPresent=info, zoneScan=warning")
+	cfg := SettingsFromEnv()
+	if len(cfg.parseErrors) != 0 || cfg.LogMessageLevels[msgRecordPresent] != levelInfo || cfg.LogMessageLevels[msgZoneScan] != levelWarning {
+		t.Errorf("got message levels %v with errors %v", cfg.LogMessageLevels, cfg.parseErrors)
+	}
+	for _, value := range []string{"recordPresent", "noSuchMessage=info", "recordPresent=loud"} {
+		t.Setenv("LOG_MESSAGE_LEVELS", value)
+		if cfg := SettingsFromEnv(); len(cfg.parseErrors) != 1 {
+			t.Errorf("LOG_MESSAGE_LEVELS=%s: got parse errors %v, want one", value, cfg.parseErrors)
+		}
+	}
+}
+
+func TestReadConfigDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"yaml", "ttl: 5m\nallMatchingZones: true\n", false},
+		{"json", `{"ttl": 300, "allMatchingZones": true}`, false},
+		{"empty", "", false},
+		{"unknown field", "ttl: 300\nallMatchingZone: true\n", true},
+		{"invalid value", "ttl: soon\n", true},
+		{"invalid strategy", "recordNameStrategy: cname\n", true},
+		{"not yaml", "ttl: [300\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "defaults.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := readConfigDefaults(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStartupConfigFromEnvConfigFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+	if cfg := SettingsFromEnv(); len(cfg.parseErrors) != 1 || !strings.Contains(cfg.parseErrors[0], "CONFIG_FILE") {
+		t.Errorf("got parse errors %v, want one for CONFIG_FILE", cfg.parseErrors)
+	}
+}
+
+func TestConfigDefaultsPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "defaults.yaml")
+	content := "ttl: 600\nallMatchingZones: true\nemptyZoneListRetries: 5\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defaults, err := readConfigDefaults(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	solver := newBunnySolver(Settings{MinTTL: defaultMinTTL, MaxTTL: 300, ConfigDefaults: defaults})
+
+	// The Issuer overrides the TTL and the retries, the file sets
+	// allMatchingZones, and updateStaleRecords keeps its built-in default.
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", `,"ttl":900,"emptyZoneListRetries":1`)
+	cfg, err := solver.loadConfig(ch.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TTL == nil || *cfg.TTL != 900 || !cfg.AllMatchingZones || cfg.UpdateStaleRecords {
+		t.Errorf("got %+v, want the Issuer's TTL and the file's allMatchingZones", cfg)
+	}
+	if *cfg.EmptyZoneListRetries != 1 {
+		t.Errorf("got %d empty zone list retries, want the Issuer's 1", *cfg.EmptyZoneListRetries)
+	}
+	// The webhook-wide TTL bounds still apply on top.
+	if ttl := solver.recordTTL(cfg); ttl != 300 {
+		t.Errorf("got TTL %d, want it clamped to 300", ttl)
+	}
+
+	// Optional fields must not be shared between decoded configs.
+	other, err := solver.loadConfig(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "").Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *other.EmptyZoneListRetries != 5 || *other.TTL != 600 {
+		t.Errorf("got retries %d and TTL %d, want the file's 5 and 600", *other.EmptyZoneListRetries, *other.TTL)
+	}
+}
@@ -0,0 +1,84 @@
+package solver
+
+import (
+	"context"
+	"os"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+const tracerName = "gitlab.com/digilol/cert-manager-webhook-bunny"
+
+// tracingEnabled reports whether an OTLP endpoint is configured through the
+// standard OpenTelemetry environment variables.
+func tracingEnabled(getenv func(string) string) bool {
+	return getenv("OTEL_TRACES_EXPORTER") != "none" &&
+		(getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "")
+}
+
+// setupTracing exports spans over OTLP/gRPC when an endpoint is configured.
+// Otherwise the global tracer provider stays a no-op and spans cost
+// nothing. The returned function flushes pending spans.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if !tracingEnabled(os.Getenv) {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String("cert-manager-webhook-bunny")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// traced runs f in a span named name, recording its result.
+func traced(ctx context.Context, name string, f func(context.Context) error, attrs ...attribute.KeyValue) error {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name)
+	defer span.End()
+	span.SetAttributes(attrs...)
+	err := f(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attribute.String("result", "error"))
+	} else {
+		span.SetAttributes(attribute.String("result", "ok"))
+	}
+	return err
+}
+
+// challengeAttributes describe the challenge on its Present or CleanUp span.
+func challengeAttributes(ch *v1alpha1.ChallengeRequest, recordName string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("zone", ch.ResolvedZone),
+		attribute.String("fqdn", ch.ResolvedFQDN),
+		attribute.String("record", recordName),
+	}
+}
+
+// tracedResolveZoneIds is resolveZoneIds in a "resolve zone" span.
+func (c *bunnySolver) tracedResolveZoneIds(ctx context.Context, client DNSZoneAPI, zoneName string, cfg bunnyConfig) (ids []int64, err error) {
+	err = traced(ctx, "resolve zone", func(context.Context) error {
+		ids, err = c.resolveZoneIds(ctx, client, zoneName, cfg)
+		return err
+	}, attribute.String("zone", zoneName))
+	return ids, err
+}
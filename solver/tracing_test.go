@@ -0,0 +1,107 @@
+package solver
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans makes the global tracer provider record spans for the rest of
+// the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	saved := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(saved) })
+	return recorder
+}
+
+// spanAttribute returns the value of the span attribute key, or "".
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestPresentAndCleanUpSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	solver := newTestSolver(newFakeDNSZoneAPI("example.com"))
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	var names []string
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range spans {
+		names = append(names, span.Name())
+		byName[span.Name()] = span
+	}
+	want := []string{"resolve zone", "check records", "write record", "Present", "resolve zone", "check records", "delete records", "CleanUp"}
+	if len(names) != len(want) {
+		t.Fatalf("spans = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("spans = %v, want %v", names, want)
+		}
+	}
+
+	present := byName["Present"]
+	if got := spanAttribute(present, "zone"); got != "example.com." {
+		t.Errorf("Present zone = %q", got)
+	}
+	if got := spanAttribute(present, "record"); got != "_acme-challenge" {
+		t.Errorf("Present record = %q", got)
+	}
+	if got := spanAttribute(present, "result"); got != "ok" {
+		t.Errorf("Present result = %q", got)
+	}
+	write := byName["write record"]
+	if write.Parent().SpanID() != present.SpanContext().SpanID() {
+		t.Error("write record span is not a child of the Present span")
+	}
+	if got := spanAttribute(write, "zone.id"); got != "1" {
+		t.Errorf("write record zone.id = %q", got)
+	}
+}
+
+func TestFailedPresentSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	solver := newTestSolver(&rejectedAPI{})
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err == nil {
+		t.Fatal("Present() error = nil for a rejected access key")
+	}
+	spans := recorder.Ended()
+	root := spans[len(spans)-1]
+	if root.Name() != "Present" || spanAttribute(root, "result") != "error" {
+		t.Errorf("last span %s has result %q, want a failed Present", root.Name(), spanAttribute(root, "result"))
+	}
+}
+
+func TestTracingEnabled(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4317"}, true},
+		{map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://collector:4317"}, true},
+		{map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4317", "OTEL_TRACES_EXPORTER": "none"}, false},
+	}
+	for _, tt := range tests {
+		if got := tracingEnabled(func(key string) string { return tt.env[key] }); got != tt.want {
+			t.Errorf("tracingEnabled(%v) = %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
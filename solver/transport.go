@@ -0,0 +1,35 @@
+package solver
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	defaultAPIMaxIdleConnsPerHost = 16
+	defaultAPIIdleConnTimeout     = 90 * time.Second
+	defaultAPITLSHandshakeTimeout = 10 * time.Second
+)
+
+// configureAPITransport tunes connection pooling for the bunny.net API.
+// bunny-go offers no way to pass an HTTP client and sends every request
+// through http.DefaultTransport, so that transport is already shared by all
+// cached clients and is tuned in place. Its default of two idle
+// connections per host makes bulk issuance open and close connections all
+// the time.
+func configureAPITransport(cfg Settings) {
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		tuneTransport(t, cfg)
+	}
+}
+
+// tuneTransport applies the startup config's pool settings to t before it
+// is used.
+func tuneTransport(t *http.Transport, cfg Settings) {
+	t.MaxIdleConnsPerHost = int(cfg.APIMaxIdleConnsPerHost)
+	if t.MaxIdleConns != 0 && t.MaxIdleConns < t.MaxIdleConnsPerHost {
+		t.MaxIdleConns = t.MaxIdleConnsPerHost
+	}
+	t.IdleConnTimeout = cfg.APIIdleConnTimeout
+	t.TLSHandshakeTimeout = cfg.APITLSHandshakeTimeout
+}
@@ -0,0 +1,43 @@
+package solver
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestConfigureAPITransport(t *testing.T) {
+	// Other tests may have connections in flight on http.DefaultTransport,
+	// so tune a copy of it.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	tuneTransport(transport, Settings{
+		APIMaxIdleConnsPerHost: 32,
+		APIIdleConnTimeout:     time.Minute,
+		APITLSHandshakeTimeout: 5 * time.Second,
+	})
+	if transport.MaxIdleConnsPerHost != 32 || transport.IdleConnTimeout != time.Minute || transport.TLSHandshakeTimeout != 5*time.Second {
+		t.Errorf("transport has %d idle connections per host, idle timeout %s and TLS handshake timeout %s",
+			transport.MaxIdleConnsPerHost, transport.IdleConnTimeout, transport.TLSHandshakeTimeout)
+	}
+	if transport.MaxIdleConns != 0 && transport.MaxIdleConns < 32 {
+		t.Errorf("MaxIdleConns %d caps the pool below the per-host limit", transport.MaxIdleConns)
+	}
+}
+
+// TestAPIClientsShareTransport pins down that bunny-go clients use
+// http.DefaultTransport, which configureAPITransport relies on.
+func TestAPIClientsShareTransport(t *testing.T) {
+	for _, key := range []string{"key-1", "key-2"} {
+		httpClient := reflect.ValueOf(bunny.NewClient(key)).Elem().FieldByName("httpClient")
+		if !httpClient.IsValid() {
+			t.Fatal("bunny.Client has no httpClient field anymore")
+		}
+		if transport := httpClient.FieldByName("Transport"); !transport.IsNil() {
+			t.Errorf("client for %s has its own transport, want http.DefaultTransport", key)
+		}
+	}
+}
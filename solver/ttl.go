@@ -0,0 +1,139 @@
+package solver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTTL    int32 = 120
+	defaultMinTTL int32 = 30
+	defaultMaxTTL int32 = 3600
+)
+
+// ttlJitterRand picks TTL jitter. It is seeded explicitly, as the global
+// source of math/rand is not before Go 1.20.
+var ttlJitterRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// jitterTTL moves ttl by a random amount of at most jitter seconds either
+// way.
+func jitterTTL(ttl, jitter int32) int32 {
+	ttlJitterRand.Lock()
+	offset := ttlJitterRand.Int63n(2*int64(jitter)+1) - int64(jitter)
+	ttlJitterRand.Unlock()
+	jittered := int64(ttl) + offset
+	if jittered < 1 {
+		return 1
+	}
+	if jittered > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(jittered)
+}
+
+// recordTTL returns the TTL for a challenge record: the Issuer's TTL, or the
+// default, moved by the Issuer's jitter and clamped to the webhook's floor
+// and ceiling.
+func (c *bunnySolver) recordTTL(cfg bunnyConfig) int32 {
+	ttl := defaultTTL
+	if cfg.TTL != nil {
+		ttl = int32(*cfg.TTL)
+	}
+	if cfg.TTLJitter != nil {
+		ttl = jitterTTL(ttl, int32(*cfg.TTLJitter))
+	}
+	minTTL, maxTTL := c.minTTL, c.maxTTL
+	if minTTL == 0 {
+		minTTL = defaultMinTTL
+	}
+	if maxTTL == 0 {
+		maxTTL = defaultMaxTTL
+	}
+	if ttl < minTTL {
+		rootLogger.warning("TTL is below the minimum, using the minimum", field("ttl", ttl), field("minTTL", minTTL))
+		return minTTL
+	}
+	if ttl > maxTTL {
+		rootLogger.warning("TTL is above the maximum, using the maximum", field("ttl", ttl), field("maxTTL", maxTTL))
+		return maxTTL
+	}
+	return ttl
+}
+
+const (
+	zoneMinTTLClamp = "clamp"
+	zoneMinTTLError = "error"
+)
+
+// validateZoneMinTTL checks the zoneMinTTLAction of cfg.
+func validateZoneMinTTL(cfg bunnyConfig) error {
+	switch cfg.ZoneMinTTLAction {
+	case "", zoneMinTTLClamp, zoneMinTTLError:
+		return nil
+	}
+	return fmt.Errorf("unknown zoneMinTTLAction %q, want %q or %q", cfg.ZoneMinTTLAction, zoneMinTTLClamp, zoneMinTTLError)
+}
+
+// challengeTTL returns the TTL for a challenge record in zone, see
+// recordTTL, checked against the zone's minimum TTL if the Issuer sets
+// one. bunny.net's API does not report a zone's minimum, and rejects a
+// record below it with an error that does not say so, so the minimum is
+// configured. A TTL below it is raised to it, or with zoneMinTTLAction
+// "error" refused.
+func (c *bunnySolver) challengeTTL(cfg bunnyConfig, zone string) (int32, error) {
+	ttl := c.recordTTL(cfg)
+	if cfg.ZoneMinTTL == nil || ttl >= int32(*cfg.ZoneMinTTL) {
+		return ttl, nil
+	}
+	zoneMin := int32(*cfg.ZoneMinTTL)
+	if cfg.ZoneMinTTLAction == zoneMinTTLError {
+		return 0, fmt.Errorf("TTL %d is below the minimum TTL %d of zone %s, raise ttl or use zoneMinTTLAction %q", ttl, zoneMin, zone, zoneMinTTLClamp)
+	}
+	rootLogger.warning("TTL is below the zone's minimum, using the zone's minimum", field("ttl", ttl), field("zoneMinTTL", zoneMin), field("zone", zone))
+	return zoneMin, nil
+}
+
+// explainTTLRejection names the TTL as the likely cause when bunny.net
+// rejected the TTL field of a record, which it does for a TTL below the
+// zone's minimum.
+func explainTTLRejection(err error, ttl int32, zone string) error {
+	if d, ok := apiErrorDetails(err); ok && strings.EqualFold(d.Field, "Ttl") {
+		return fmt.Errorf("bunny.net rejected TTL %d, it may be below the minimum TTL of zone %s, set zoneMinTTL: %w", ttl, zone, err)
+	}
+	return err
+}
+
+// ttlValue is a TTL in seconds. In the config it is either a number of
+// seconds or a duration string such as "2m".
+type ttlValue int32
+
+func (t *ttlValue) UnmarshalJSON(b []byte) error {
+	var seconds int64
+	if err := json.Unmarshal(b, &seconds); err != nil {
+		var s string
+		if json.Unmarshal(b, &s) != nil {
+			return fmt.Errorf("ttl must be a number of seconds or a duration string, got %s", b)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid ttl: %v", err)
+		}
+		if d%time.Second != 0 {
+			return fmt.Errorf("invalid ttl %q: must be a whole number of seconds", s)
+		}
+		seconds = int64(d / time.Second)
+	}
+	if seconds < 1 || seconds > math.MaxInt32 {
+		return fmt.Errorf("invalid ttl %s: must be between 1s and %ds", b, math.MaxInt32)
+	}
+	*t = ttlValue(seconds)
+	return nil
+}
@@ -0,0 +1,159 @@
+package solver
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestRecordTTL(t *testing.T) {
+	ttl := func(v ttlValue) *ttlValue { return &v }
+	tests := []struct {
+		name   string
+		minTTL int32
+		maxTTL int32
+		ttl    *ttlValue
+		want   int32
+	}{
+		{"default", 0, 0, nil, defaultTTL},
+		{"configured", 0, 0, ttl(300), 300},
+		{"below default floor", 0, 0, ttl(5), defaultMinTTL},
+		{"above default ceiling", 0, 0, ttl(86400), defaultMaxTTL},
+		{"below custom floor", 60, 600, ttl(30), 60},
+		{"above custom ceiling", 60, 600, ttl(900), 600},
+		{"default above custom ceiling", 10, 60, nil, 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			solver := &bunnySolver{minTTL: tt.minTTL, maxTTL: tt.maxTTL}
+			if got := solver.recordTTL(bunnyConfig{TTL: tt.ttl}); got != tt.want {
+				t.Errorf("got TTL %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordTTLJitter(t *testing.T) {
+	ttl := func(v ttlValue) *ttlValue { return &v }
+	tests := []struct {
+		name   string
+		minTTL int32
+		maxTTL int32
+		ttl    ttlValue
+		jitter ttlValue
+		low    int32
+		high   int32
+	}{
+		{"within band", 0, 0, 300, 30, 270, 330},
+		{"floor", 60, 3600, 70, 30, 60, 100},
+		{"ceiling", 60, 600, 590, 30, 560, 600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			solver := &bunnySolver{minTTL: tt.minTTL, maxTTL: tt.maxTTL}
+			cfg := bunnyConfig{TTL: ttl(tt.ttl), TTLJitter: ttl(tt.jitter)}
+			seen := map[int32]bool{}
+			for i := 0; i < 500; i++ {
+				got := solver.recordTTL(cfg)
+				if got < tt.low || got > tt.high {
+					t.Fatalf("got TTL %d, want it within [%d, %d]", got, tt.low, tt.high)
+				}
+				seen[got] = true
+			}
+			if len(seen) < 2 {
+				t.Errorf("every TTL was %v, want them spread out", seen)
+			}
+		})
+	}
+}
+
+func TestTTLValueUnmarshal(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    ttlValue
+		wantErr bool
+	}{
+		{`120`, 120, false},
+		{`"2m"`, 120, false},
+		{`"30s"`, 30, false},
+		{`"1h30m"`, 5400, false},
+		{`"1500ms"`, 0, true},
+		{`"soon"`, 0, true},
+		{`0`, 0, true},
+		{`-5`, 0, true},
+		{`"-1m"`, 0, true},
+		{`1.5`, 0, true},
+		{`true`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var cfg bunnyConfig
+			err := json.Unmarshal([]byte(`{"ttl":`+tt.json+`}`), &cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && *cfg.TTL != tt.want {
+				t.Errorf("got TTL %d, want %d", *cfg.TTL, tt.want)
+			}
+		})
+	}
+}
+
+// minTTLZoneAPI rejects records with a TTL below minTTL as bunny.net does,
+// naming only the field.
+type minTTLZoneAPI struct {
+	*fakeDNSZoneAPI
+	minTTL int32
+}
+
+func (m *minTTLZoneAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	if *opts.TTL < m.minTTL {
+		m.addCalls++
+		return nil, &bunny.APIError{
+			HTTPError: bunny.HTTPError{StatusCode: 400},
+			ErrorKey:  "validation_error", Field: "Ttl", Message: "The TTL is invalid.",
+		}
+	}
+	return m.fakeDNSZoneAPI.AddDNSRecord(ctx, zoneID, opts)
+}
+
+func TestPresentZoneMinTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantTTL int32
+		wantErr string
+	}{
+		{"clamped", `,"ttl":60,"zoneMinTTL":300`, 300, ""},
+		{"clamped explicitly", `,"ttl":60,"zoneMinTTL":"5m","zoneMinTTLAction":"clamp"`, 300, ""},
+		{"refused", `,"ttl":60,"zoneMinTTL":300,"zoneMinTTLAction":"error"`, 0, "TTL 60 is below the minimum TTL 300 of zone example.com."},
+		{"above minimum", `,"ttl":600,"zoneMinTTL":300,"zoneMinTTLAction":"error"`, 600, ""},
+		{"minimum unknown", `,"ttl":60`, 0, "bunny.net rejected TTL 60, it may be below the minimum TTL of zone example.com., set zoneMinTTL"},
+		{"unknown action", `,"zoneMinTTLAction":"raise"`, 0, `unknown zoneMinTTLAction "raise"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &minTTLZoneAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com"), minTTL: 300}
+			err := newTestSolver(api).Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+				if strings.Contains(tt.wantErr, "below the minimum TTL 300") && api.addCalls != 0 {
+					t.Errorf("got %d record adds, want none", api.addCalls)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			records := api.zones[0].Records
+			if len(records) != 1 || *records[0].TTL != tt.wantTTL {
+				t.Fatalf("got records %+v, want one with TTL %d", records, tt.wantTTL)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package solver
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// Build information, set when building with -ldflags, for example
+//
+//	-X gitlab.com/digilol/cert-manager-webhook-bunny/solver.Version=v1.2.0
+//
+// It is logged at startup, sent in the User-Agent of bunny.net API calls
+// and exported as the bunny_webhook_build_info metric.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// versionFlag prints the build information and exits.
+const versionFlag = "--version"
+
+// userAgent identifies the webhook and its version to bunny.net.
+func userAgent() string {
+	return "cert-manager-webhook-bunny/" + Version
+}
+
+func printVersion(out io.Writer) {
+	fmt.Fprintf(out, "cert-manager-webhook-bunny %s (commit %s, built %s, %s)\n", Version, Commit, BuildDate, runtime.Version())
+}
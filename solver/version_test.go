@@ -0,0 +1,31 @@
+package solver
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestVersionInfo(t *testing.T) {
+	if got, want := userAgent(), "cert-manager-webhook-bunny/"+Version; got != want {
+		t.Errorf("userAgent() = %q, want %q", got, want)
+	}
+
+	var out bytes.Buffer
+	printVersion(&out)
+	for _, want := range []string{Version, Commit, BuildDate, runtime.Version()} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("version output %q does not contain %q", out.String(), want)
+		}
+	}
+
+	if got := testutil.ToFloat64(buildInfo.WithLabelValues(Version, Commit, BuildDate, runtime.Version())); got != 1 {
+		t.Errorf("build info gauge = %v, want 1", got)
+	}
+	if n := testutil.CollectAndCount(buildInfo); n != 1 {
+		t.Errorf("build info has %d series, want 1", n)
+	}
+}
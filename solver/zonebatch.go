@@ -0,0 +1,89 @@
+package solver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// zoneReadBatcher coalesces the zone reads of presents that arrive close
+// together. The first present for a zone waits for the configured window,
+// presents arriving meanwhile join it, and one Get serves them all. This
+// goes beyond the zone cache, which only shares the zone ID lookup: a
+// certificate with many names in one zone otherwise reads the whole zone
+// once per name.
+type zoneReadBatcher struct {
+	mu      sync.Mutex
+	pending map[zoneReadKey]*zoneRead
+}
+
+// zoneReadKey scopes a batch to the client it reads with, like
+// zoneCacheKey.
+type zoneReadKey struct {
+	client DNSZoneAPI
+	zoneID int64
+}
+
+// zoneRead is a batched read. done is closed once records and err are set.
+type zoneRead struct {
+	done    chan struct{}
+	records []bunny.DNSRecord
+	err     error
+}
+
+// read returns the zone's records as fetched by a read shared with every
+// caller for the same key that arrives within window of the first. Callers
+// arriving once the read has started start a new batch, so they see the
+// records written in the meantime. A caller whose ctx is done stops
+// waiting. The read runs with the client of the caller that opened the
+// batch, bound to that caller's operation, so when it fails because that
+// operation was given up the others read the zone with their own fetch.
+func (b *zoneReadBatcher) read(ctx context.Context, key zoneReadKey, window time.Duration, fetch func() ([]bunny.DNSRecord, error)) ([]bunny.DNSRecord, error) {
+	b.mu.Lock()
+	r, ok := b.pending[key]
+	if !ok {
+		r = &zoneRead{done: make(chan struct{})}
+		if b.pending == nil {
+			b.pending = map[zoneReadKey]*zoneRead{}
+		}
+		b.pending[key] = r
+	}
+	b.mu.Unlock()
+
+	if !ok {
+		_ = sleepContext(ctx, window)
+		b.mu.Lock()
+		delete(b.pending, key)
+		b.mu.Unlock()
+		r.records, r.err = fetch()
+		close(r.done)
+	}
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if r.err != nil {
+		if ok && isContextError(r.err) && ctx.Err() == nil {
+			return fetch()
+		}
+		return nil, r.err
+	}
+	// Callers trim the slice as they delete records; give each its own.
+	return append([]bunny.DNSRecord(nil), r.records...), nil
+}
+
+// batchedZoneRecords is zoneRecords, sharing the read with other presents
+// in the zone when cfg.ZoneReadWindow is set.
+func (c *bunnySolver) batchedZoneRecords(ctx context.Context, client DNSZoneAPI, cfg bunnyConfig, zoneID int64) ([]bunny.DNSRecord, error) {
+	window := cfg.ZoneReadWindow.Duration
+	if window <= 0 {
+		return c.zoneRecords(client, zoneID)
+	}
+	key := zoneReadKey{client: unwrap(client), zoneID: zoneID}
+	return c.zoneReads.read(ctx, key, window, func() ([]bunny.DNSRecord, error) {
+		return c.zoneRecords(client, zoneID)
+	})
+}
@@ -0,0 +1,94 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestConcurrentPresentsShareZoneRead(t *testing.T) {
+	api := &lockedAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI("example.com")}
+	solver := newTestSolver(api)
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fqdn := fmt.Sprintf("_acme-challenge.san-%d.example.com.", i)
+			errs <- solver.Present(newChallenge(fqdn, "example.com.", "key", `,"zoneReadWindow":"100ms"`))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if api.getCalls != 1 {
+		t.Errorf("Get called %d times for %d concurrent presents, want 1", api.getCalls, n)
+	}
+	if got := len(api.txtValues(1)); got != n {
+		t.Errorf("got %d TXT records, want %d", got, n)
+	}
+}
+
+func TestZoneReadBatchEndsWithRead(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	cfg := `,"zoneReadWindow":"1ms"`
+	for _, fqdn := range []string{"_acme-challenge.a.example.com.", "_acme-challenge.b.example.com."} {
+		if err := solver.Present(newChallenge(fqdn, "example.com.", "key", cfg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if api.getCalls != 2 {
+		t.Errorf("Get called %d times for presents one after the other, want 2", api.getCalls)
+	}
+}
+
+func TestZoneReadBatchSurvivesCancelledOpener(t *testing.T) {
+	var b zoneReadBatcher
+	key := zoneReadKey{zoneID: 1}
+	records := []bunny.DNSRecord{txtRecord(10, "_acme-challenge", "key")}
+	// Each fetch is bound to its caller's operation, as the instrumented
+	// client is.
+	fetchFor := func(ctx context.Context) func() ([]bunny.DNSRecord, error) {
+		return func() ([]bunny.DNSRecord, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return records, nil
+		}
+	}
+	opener, cancel := context.WithCancel(context.Background())
+	openerErr := make(chan error, 1)
+	go func() {
+		_, err := b.read(opener, key, 100*time.Millisecond, fetchFor(opener))
+		openerErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	waiter := make(chan error, 1)
+	go func() {
+		got, err := b.read(context.Background(), key, 100*time.Millisecond, fetchFor(context.Background()))
+		if err == nil && len(got) != 1 {
+			err = fmt.Errorf("got %d records, want 1", len(got))
+		}
+		waiter <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-openerErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("opener got %v, want its own cancellation", err)
+	}
+	if err := <-waiter; err != nil {
+		t.Errorf("waiter got %v after the opener was cancelled, want the zone's records", err)
+	}
+}
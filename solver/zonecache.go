@@ -0,0 +1,200 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+const defaultZoneCacheTTL = 10 * time.Minute
+
+// zoneCache remembers resolved zone IDs so that presenting several records
+// in one zone does not list every zone of the account each time. Lookups of
+// the same zone that run at once, as for the names of a multi-SAN
+// certificate, share a single scan.
+type zoneCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	entries  map[zoneCacheKey]zoneCacheEntry
+	inflight map[zoneCacheKey]*zoneLookup
+}
+
+// zoneLookup is a scan in progress. done is closed once ids and err are set.
+type zoneLookup struct {
+	done chan struct{}
+	ids  []int64
+	err  error
+}
+
+// zoneCacheKey scopes an entry to the client it was resolved with. Clients
+// are cached per credential Secret, so this keeps accounts apart and drops
+// entries once an access key is rotated.
+type zoneCacheKey struct {
+	client DNSZoneAPI
+	zone   string
+	all    bool
+}
+
+// newZoneCacheKey returns the key for zoneName resolved with client.
+func newZoneCacheKey(client DNSZoneAPI, zoneName string, cfg bunnyConfig) zoneCacheKey {
+	return zoneCacheKey{client: unwrap(client), zone: zoneName, all: cfg.AllMatchingZones}
+}
+
+type zoneCacheEntry struct {
+	ids     []int64
+	expires time.Time
+}
+
+func (zc *zoneCache) get(key zoneCacheKey) ([]int64, bool) {
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	entry, ok := zc.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return copyIDs(entry.ids), true
+}
+
+// resolve returns the cached IDs for key, or calls scan to find them. While
+// a scan for key runs, other callers wait for its result instead of
+// starting their own, unless their ctx is done first. A scan that failed
+// because its caller's operation was given up says nothing to the others,
+// which scan again instead. Every caller gets its own copy of the IDs, so
+// none can change what the cache or other callers hold.
+func (zc *zoneCache) resolve(ctx context.Context, key zoneCacheKey, scan func() ([]int64, error)) ([]int64, error) {
+	zc.mu.Lock()
+	if entry, ok := zc.entries[key]; ok && time.Now().Before(entry.expires) {
+		zc.mu.Unlock()
+		return copyIDs(entry.ids), nil
+	}
+	if l, ok := zc.inflight[key]; ok {
+		zc.mu.Unlock()
+		select {
+		case <-l.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if isContextError(l.err) && ctx.Err() == nil {
+			return zc.resolve(ctx, key, scan)
+		}
+		return copyIDs(l.ids), l.err
+	}
+	l := &zoneLookup{done: make(chan struct{}), err: errZoneScanAborted}
+	if zc.inflight == nil {
+		zc.inflight = map[zoneCacheKey]*zoneLookup{}
+	}
+	zc.inflight[key] = l
+	zc.mu.Unlock()
+
+	// The lookup is finished in a deferred call so that waiters are
+	// released even if scan panics.
+	defer func() {
+		zc.mu.Lock()
+		delete(zc.inflight, key)
+		if l.err == nil {
+			zc.putLocked(key, l.ids)
+		}
+		zc.mu.Unlock()
+		close(l.done)
+	}()
+	ids, err := scan()
+	l.ids, l.err = copyIDs(ids), err
+	return ids, err
+}
+
+// errZoneScanAborted is returned to callers waiting for a scan that did not
+// return.
+var errZoneScanAborted = errors.New("zone lookup aborted")
+
+func copyIDs(ids []int64) []int64 {
+	if ids == nil {
+		return nil
+	}
+	return append([]int64(nil), ids...)
+}
+
+// remove drops the entry for key if it still holds ids, so that the next
+// resolve scans again. An entry another caller has since replaced is kept.
+func (zc *zoneCache) remove(key zoneCacheKey, ids []int64) {
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	if entry, ok := zc.entries[key]; ok && equalIDs(entry.ids, ids) {
+		delete(zc.entries, key)
+	}
+}
+
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (zc *zoneCache) put(key zoneCacheKey, ids []int64) {
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	zc.putLocked(key, ids)
+}
+
+func (zc *zoneCache) putLocked(key zoneCacheKey, ids []int64) {
+	if zc.entries == nil {
+		zc.entries = map[zoneCacheKey]zoneCacheEntry{}
+	}
+	ttl := zc.ttl
+	if ttl == 0 {
+		ttl = defaultZoneCacheTTL
+	}
+	zc.entries[key] = zoneCacheEntry{ids: copyIDs(ids), expires: time.Now().Add(ttl)}
+}
+
+// zoneGoneError is returned when bunny.net does not know a zone ID that
+// was resolved, because the zone was deleted since. It reads and unwraps
+// as the API's error.
+type zoneGoneError struct {
+	zoneID int64
+	err    error
+}
+
+func (e *zoneGoneError) Error() string {
+	return e.err.Error()
+}
+
+func (e *zoneGoneError) Unwrap() error {
+	return e.err
+}
+
+// inResolvedZones resolves the challenge's zone and calls f with its IDs.
+// If the IDs came from the zone cache and f fails because bunny.net no
+// longer knows one of them, the zone was most likely deleted and created
+// again with a new ID: the entry is dropped and the zone resolved once
+// more for a second call of f, rather than failing until the entry
+// expires.
+func (c *bunnySolver) inResolvedZones(ctx context.Context, client DNSZoneAPI, ch *v1alpha1.ChallengeRequest, cfg bunnyConfig, f func(zoneIDs []int64) error) error {
+	key := newZoneCacheKey(client, ch.ResolvedZone, cfg)
+	_, cached := c.zones.get(key)
+	zoneIDs, err := c.tracedResolveZoneIds(ctx, client, ch.ResolvedZone, cfg)
+	if err != nil {
+		return err
+	}
+	err = f(zoneIDs)
+	var gone *zoneGoneError
+	if !cached || cfg.DisableZoneCache || !errors.As(err, &gone) {
+		return err
+	}
+	challengeLogger(ch).warning("cached zone ID is no longer known to bunny.net, resolving the zone again",
+		field("zone", ch.ResolvedZone), field("zoneID", gone.zoneID))
+	c.zones.remove(key, zoneIDs)
+	zoneIDs, err = c.tracedResolveZoneIds(ctx, client, ch.ResolvedZone, cfg)
+	if err != nil {
+		return err
+	}
+	return f(zoneIDs)
+}
@@ -0,0 +1,308 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestResolveZoneIdsCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       bunnyConfig
+		wantLists int
+	}{
+		{"cached", bunnyConfig{}, 1},
+		{"cache disabled", bunnyConfig{DisableZoneCache: true}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			solver := &bunnySolver{}
+			for i := 0; i < 3; i++ {
+				ids, err := solver.resolveZoneIds(context.Background(), api, "example.com.", tt.cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(ids) != 1 || ids[0] != 1 {
+					t.Fatalf("got zone IDs %v, want [1]", ids)
+				}
+			}
+			if api.listCalls != tt.wantLists {
+				t.Errorf("List called %d times, want %d", api.listCalls, tt.wantLists)
+			}
+		})
+	}
+}
+
+func TestZoneCacheScopedToClient(t *testing.T) {
+	first, second := newFakeDNSZoneAPI("example.com"), newFakeDNSZoneAPI("other.com", "example.com")
+	solver := &bunnySolver{}
+	if _, err := solver.resolveZoneIds(context.Background(), first, "example.com.", bunnyConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := solver.resolveZoneIds(context.Background(), second, "example.com.", bunnyConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("got zone IDs %v from another account's cache entry, want [2]", ids)
+	}
+}
+
+func TestZoneCacheExpiry(t *testing.T) {
+	zc := &zoneCache{ttl: time.Millisecond}
+	key := zoneCacheKey{zone: "example.com."}
+	zc.put(key, []int64{1})
+	if _, ok := zc.get(key); !ok {
+		t.Fatal("expected fresh entry to be cached")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := zc.get(key); ok {
+		t.Error("expected expired entry to be dropped")
+	}
+}
+
+// lockedAPI serializes calls into the fake so it can be shared by
+// concurrent presents. List is slowed down so that concurrent scans
+// overlap.
+type lockedAPI struct {
+	mu sync.Mutex
+	*fakeDNSZoneAPI
+}
+
+func (l *lockedAPI) Get(ctx context.Context, id int64) (*bunny.DNSZone, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fakeDNSZoneAPI.Get(ctx, id)
+}
+
+func (l *lockedAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	time.Sleep(time.Millisecond)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fakeDNSZoneAPI.List(ctx, opts)
+}
+
+func (l *lockedAPI) AddDNSRecord(ctx context.Context, zoneID int64, opts *bunny.AddOrUpdateDNSRecordOptions) (*bunny.DNSRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fakeDNSZoneAPI.AddDNSRecord(ctx, zoneID, opts)
+}
+
+// newMultiSANAccount returns an account of 30 zones, 10 pages, with
+// example.com listed last.
+func newMultiSANAccount() *lockedAPI {
+	var domains []string
+	for i := 1; i < 30; i++ {
+		domains = append(domains, fmt.Sprintf("zone-%d.com", i))
+	}
+	return &lockedAPI{fakeDNSZoneAPI: newFakeDNSZoneAPI(append(domains, "example.com")...)}
+}
+
+// presentBurst presents n challenges in example.com at once, like
+// cert-manager does for a certificate with n names in one zone.
+func presentBurst(solver *bunnySolver, n int) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fqdn := fmt.Sprintf("_acme-challenge.san-%d.example.com.", i)
+			errs <- solver.Present(newChallenge(fqdn, "example.com.", "key", ""))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestConcurrentPresentsShareZoneScan(t *testing.T) {
+	api := newMultiSANAccount()
+	if err := presentBurst(newTestSolver(api), 20); err != nil {
+		t.Fatal(err)
+	}
+	if api.listCalls != 10 {
+		t.Errorf("List called %d times for 20 concurrent presents, want one scan of 10 pages", api.listCalls)
+	}
+	if got := len(api.txtValues(30)); got != 20 {
+		t.Errorf("got %d TXT records, want 20", got)
+	}
+}
+
+// BenchmarkMultiSANPresent measures the API calls of presenting all names
+// of a 50 name certificate in one zone. Zone lists per burst stay at the
+// page count instead of growing with the number of names.
+func BenchmarkMultiSANPresent(b *testing.B) {
+	var lists, gets int
+	for i := 0; i < b.N; i++ {
+		api := newMultiSANAccount()
+		if err := presentBurst(newTestSolver(api), 50); err != nil {
+			b.Fatal(err)
+		}
+		lists += api.listCalls
+		gets += api.getCalls
+	}
+	b.ReportMetric(float64(lists)/float64(b.N), "lists/burst")
+	b.ReportMetric(float64(gets)/float64(b.N), "gets/burst")
+}
+
+// TestZoneCacheConcurrentResolve resolves overlapping and distinct zones
+// from many goroutines, some of which scribble over the IDs they get back,
+// while entries expire underneath them. Run it with -race.
+func TestZoneCacheConcurrentResolve(t *testing.T) {
+	api := newMultiSANAccount()
+	solver := newTestSolver(api)
+	solver.zones.ttl = time.Millisecond
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Zones 1 to 5 are resolved by many goroutines at once, the
+			// rest by one each.
+			n := int64(i%5 + 1)
+			if i%2 == 1 {
+				n = int64(i%29 + 1)
+			}
+			zone := fmt.Sprintf("zone-%d.com.", n)
+			if n == 30 {
+				zone = "example.com."
+			}
+			ids, err := solver.resolveZoneIds(context.Background(), api, zone, bunnyConfig{})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if len(ids) != 1 || ids[0] != n {
+				errs <- fmt.Errorf("%s resolved to %v, want [%d]", zone, ids, n)
+				return
+			}
+			ids[0] = -1
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestZoneCacheReleasesWaitersOfPanickingScan(t *testing.T) {
+	var zc zoneCache
+	key := zoneCacheKey{zone: "example.com."}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		zc.resolve(context.Background(), key, func() ([]int64, error) {
+			close(started)
+			<-release
+			panic("scan failed")
+		})
+	}()
+	<-started
+	result := make(chan error)
+	go func() {
+		_, err := zc.resolve(context.Background(), key, func() ([]int64, error) { return []int64{1}, nil })
+		result <- err
+	}()
+	// Give the second lookup time to start waiting for the first.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	select {
+	case err := <-result:
+		if err != errZoneScanAborted && err != nil {
+			t.Errorf("waiter got %v, want %v or a fresh result", err, errZoneScanAborted)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter of a panicking scan was never released")
+	}
+	ids, err := zc.resolve(context.Background(), key, func() ([]int64, error) { return []int64{7}, nil })
+	if err != nil || len(ids) != 1 || ids[0] != 7 {
+		t.Errorf("lookup after the panic got %v, %v, want a fresh scan", ids, err)
+	}
+}
+
+func TestStaleCachedZoneResolvedAgain(t *testing.T) {
+	api := newFakeDNSZoneAPI("example.com")
+	solver := newTestSolver(api)
+	if err := solver.Present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", "")); err != nil {
+		t.Fatal(err)
+	}
+	// The zone was deleted and created again, so the cached ID 1 is stale.
+	id, domain := int64(2), "example.com"
+	api.zones = []*bunny.DNSZone{{ID: &id, Domain: &domain}}
+	ch := newChallenge("_acme-challenge.example.com.", "example.com.", "other-key", "")
+	if err := solver.Present(ch); err != nil {
+		t.Fatalf("Present with a stale cached zone ID returned %v", err)
+	}
+	if got := api.txtValues(2); len(got) != 1 || got[0] != "other-key" {
+		t.Errorf("got TXT values %v in the new zone, want [other-key]", got)
+	}
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.txtValues(2); len(got) != 0 {
+		t.Errorf("got TXT values %v after CleanUp, want none", got)
+	}
+	if api.listCalls != 2 {
+		t.Errorf("List called %d times, want 2", api.listCalls)
+	}
+}
+
+func TestZoneCacheWaiterStopsWithItsContext(t *testing.T) {
+	var zc zoneCache
+	key := zoneCacheKey{zone: "example.com."}
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	go zc.resolve(context.Background(), key, func() ([]int64, error) {
+		close(started)
+		<-release
+		return []int64{1}, nil
+	})
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := zc.resolve(ctx, key, func() ([]int64, error) { return []int64{1}, nil }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiter got %v, want its own deadline", err)
+	}
+}
+
+func TestZoneCacheWaiterScansAgainAfterCancelledLeader(t *testing.T) {
+	var zc zoneCache
+	key := zoneCacheKey{zone: "example.com."}
+	started, release := make(chan struct{}), make(chan struct{})
+	go zc.resolve(context.Background(), key, func() ([]int64, error) {
+		close(started)
+		<-release
+		return nil, context.Canceled
+	})
+	<-started
+	result := make(chan error, 1)
+	go func() {
+		ids, err := zc.resolve(context.Background(), key, func() ([]int64, error) { return []int64{7}, nil })
+		if err == nil && (len(ids) != 1 || ids[0] != 7) {
+			err = fmt.Errorf("got zone IDs %v, want [7]", ids)
+		}
+		result <- err
+	}()
+	// Give the second lookup time to start waiting for the first.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("waiter of a cancelled scan got %v, want a scan of its own", err)
+	}
+}
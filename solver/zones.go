@@ -0,0 +1,145 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// zonePageSize is how many zones are requested per page.
+const zonePageSize = 3
+
+const (
+	// maxConsecutiveFailedZonePages ends a scan that skips failed pages
+	// once this many pages in a row failed, as the API is then more likely
+	// down than hiccuping.
+	maxConsecutiveFailedZonePages = 3
+	// failedZonePageRetries is how often a skipped page is fetched again.
+	failedZonePageRetries = 2
+)
+
+// zoneIterator walks the zones of an account page by page. A zone is
+// returned once even if the API lists it on several pages, as it can when
+// zones are added or removed while the pages are fetched.
+type zoneIterator struct {
+	client DNSZoneAPI
+	page   int32
+	done   bool
+	seen   map[int64]bool
+}
+
+// listAllZones returns an iterator over the account's zones starting at page
+// start, or at the first page when start is zero.
+func listAllZones(client DNSZoneAPI, start int32) *zoneIterator {
+	if start < 1 {
+		start = 1
+	}
+	return &zoneIterator{client: client, page: start, seen: map[int64]bool{}}
+}
+
+// next returns the zones of the next page, leaving out zones without an ID
+// or domain and zones already returned. It returns ok false once all pages
+// have been returned.
+//
+// The listing ends when the API says there are no more items, when the
+// reported total has been reached or, if the response has neither, at the
+// first empty page. An empty page always ends it, so that a response
+// claiming more items forever cannot keep the scan going.
+func (it *zoneIterator) next(ctx context.Context) (zones []*bunny.DNSZone, ok bool, err error) {
+	if it.done {
+		return nil, false, nil
+	}
+	resp, err := it.client.List(ctx, &bunny.PaginationOptions{
+		Page:    it.page,
+		PerPage: zonePageSize,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if resp == nil || len(resp.Items) == 0 {
+		it.done = true
+		return nil, true, nil
+	}
+	switch {
+	case resp.HasMoreItems != nil:
+		it.done = !*resp.HasMoreItems
+	case resp.TotalItems != nil:
+		it.done = int64(it.page)*zonePageSize >= int64(*resp.TotalItems)
+	}
+	it.page++
+	return it.unseen(resp.Items), true, nil
+}
+
+// refetch returns the zones of page again, for a page that failed to load
+// earlier, in the same way as next.
+func (it *zoneIterator) refetch(ctx context.Context, page int32) ([]*bunny.DNSZone, error) {
+	resp, err := it.client.List(ctx, &bunny.PaginationOptions{
+		Page:    page,
+		PerPage: zonePageSize,
+	})
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	return it.unseen(resp.Items), nil
+}
+
+// unseen returns the complete zones among items that were not returned
+// before and marks them as returned.
+func (it *zoneIterator) unseen(items []*bunny.DNSZone) []*bunny.DNSZone {
+	var zones []*bunny.DNSZone
+	for _, z := range items {
+		if z == nil || z.ID == nil || z.Domain == nil {
+			continue
+		}
+		if it.seen[*z.ID] {
+			rootLogger.debug("zone listed again on a later page, skipping it", field("zone", *z.Domain), field("zoneID", *z.ID))
+			continue
+		}
+		it.seen[*z.ID] = true
+		zones = append(zones, z)
+	}
+	return zones
+}
+
+// skip moves past the page whose fetch just failed.
+func (it *zoneIterator) skip() {
+	it.page++
+}
+
+// retryFailedZonePages fetches the pages that failed during a scan again,
+// passing their zones to match until it reports that the scan can stop.
+// It fails if some pages still cannot be loaded, since the zone may be on
+// one of them.
+func retryFailedZonePages(it *zoneIterator, zoneName string, failed map[int32]error, match func([]*bunny.DNSZone) bool) error {
+	for attempt := 0; attempt < failedZonePageRetries && len(failed) > 0; attempt++ {
+		for _, page := range failedPages(failed) {
+			zones, err := it.refetch(context.Background(), page)
+			if err != nil {
+				failed[page] = err
+				continue
+			}
+			delete(failed, page)
+			if match(zones) {
+				return nil
+			}
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	pages := failedPages(failed)
+	rootLogger.warning("zone pages failed to load", field("zone", zoneName), field("pages", fmt.Sprint(pages)))
+	return fmt.Errorf("zone pages %v failed to load while looking for %s: %w", pages, zoneName, failed[pages[len(pages)-1]])
+}
+
+// failedPages returns the page numbers of failed in order.
+func failedPages(failed map[int32]error) []int32 {
+	pages := make([]int32, 0, len(failed))
+	for page := range failed {
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+	return pages
+}
@@ -0,0 +1,332 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"testing"
+
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// pagedAPI answers List with a fixed sequence of responses, one per page.
+type pagedAPI struct {
+	DNSZoneAPI
+	pages []*bunny.DNSZones
+	calls []int32
+}
+
+func (p *pagedAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	p.calls = append(p.calls, opts.Page)
+	if int(opts.Page) > len(p.pages) {
+		return nil, errors.New("page out of range")
+	}
+	return p.pages[opts.Page-1], nil
+}
+
+// flakyPageAPI fails List for a page as often as failures says before
+// answering it from pagedAPI.
+type flakyPageAPI struct {
+	*pagedAPI
+	failures map[int32]int
+}
+
+func (f *flakyPageAPI) List(ctx context.Context, opts *bunny.PaginationOptions) (*bunny.DNSZones, error) {
+	if f.failures[opts.Page] > 0 {
+		f.failures[opts.Page]--
+		f.calls = append(f.calls, opts.Page)
+		return nil, &bunny.HTTPError{StatusCode: 500}
+	}
+	return f.pagedAPI.List(ctx, opts)
+}
+
+// zonePage returns a page of zones whose IDs are derived from their
+// domains, so that a zone keeps its ID on every page it is listed on.
+func zonePage(hasMore *bool, total *int32, domains ...string) *bunny.DNSZones {
+	page := &bunny.DNSZones{HasMoreItems: hasMore, TotalItems: total}
+	for _, d := range domains {
+		id, domain := testZoneID(d), d
+		page.Items = append(page.Items, &bunny.DNSZone{ID: &id, Domain: &domain})
+	}
+	return page
+}
+
+func testZoneID(domain string) int64 {
+	h := fnv.New32a()
+	h.Write([]byte(domain))
+	return int64(h.Sum32())
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+func int32Ptr(n int32) *int32 { return &n }
+
+func TestListAllZones(t *testing.T) {
+	tests := []struct {
+		name      string
+		pages     []*bunny.DNSZones
+		wantZones int
+		wantPages int
+	}{
+		{"single page", []*bunny.DNSZones{zonePage(boolPtr(false), nil, "a.com")}, 1, 1},
+		{"has more items", []*bunny.DNSZones{
+			zonePage(boolPtr(true), nil, "a.com", "b.com", "c.com"),
+			zonePage(boolPtr(false), nil, "d.com"),
+		}, 4, 2},
+		{"total items without has more", []*bunny.DNSZones{
+			zonePage(nil, int32Ptr(4), "a.com", "b.com", "c.com"),
+			zonePage(nil, int32Ptr(4), "d.com"),
+		}, 4, 2},
+		{"neither has more nor total", []*bunny.DNSZones{
+			zonePage(nil, nil, "a.com", "b.com", "c.com"),
+			zonePage(nil, nil),
+		}, 3, 2},
+		{"empty page despite has more", []*bunny.DNSZones{
+			zonePage(boolPtr(true), nil, "a.com", "b.com", "c.com"),
+			zonePage(boolPtr(true), nil),
+		}, 3, 2},
+		{"nil response", []*bunny.DNSZones{nil}, 0, 1},
+		{"empty account", []*bunny.DNSZones{zonePage(boolPtr(false), int32Ptr(0))}, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &pagedAPI{pages: tt.pages}
+			it := listAllZones(api, 0)
+			var zones int
+			for {
+				page, ok, err := it.next(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					break
+				}
+				zones += len(page)
+			}
+			if zones != tt.wantZones {
+				t.Errorf("got %d zones, want %d", zones, tt.wantZones)
+			}
+			if len(api.calls) != tt.wantPages {
+				t.Errorf("requested pages %v, want %d pages", api.calls, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestListAllZonesSkipsIncompleteZones(t *testing.T) {
+	page := zonePage(boolPtr(false), nil, "a.com")
+	page.Items = append(page.Items, nil, &bunny.DNSZone{Domain: page.Items[0].Domain}, &bunny.DNSZone{ID: page.Items[0].ID})
+	zones, ok, err := listAllZones(&pagedAPI{pages: []*bunny.DNSZones{page}}, 1).next(context.Background())
+	if err != nil || !ok {
+		t.Fatalf("got ok %v, error %v", ok, err)
+	}
+	if len(zones) != 1 {
+		t.Errorf("got %d zones, want the one complete zone", len(zones))
+	}
+}
+
+func TestListAllZonesSkipsRepeatedZones(t *testing.T) {
+	// Zones added while paging push c.com and example.com onto the next
+	// pages as well.
+	api := &pagedAPI{pages: []*bunny.DNSZones{
+		zonePage(boolPtr(true), nil, "a.com", "b.com", "c.com"),
+		zonePage(boolPtr(true), nil, "c.com", "example.com", "d.com"),
+		zonePage(boolPtr(false), nil, "example.com", "e.com"),
+	}}
+	it := listAllZones(api, 1)
+	var domains []string
+	for {
+		zones, ok, err := it.next(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		for _, z := range zones {
+			domains = append(domains, *z.Domain)
+		}
+	}
+	want := "a.com b.com c.com example.com d.com e.com"
+	if got := strings.Join(domains, " "); got != want {
+		t.Errorf("got zones %s, want %s", got, want)
+	}
+}
+
+func TestScanZonesDeduplicatesMatches(t *testing.T) {
+	pages := func() []*bunny.DNSZones {
+		return []*bunny.DNSZones{
+			zonePage(boolPtr(true), nil, "a.com", "example.com", "c.com"),
+			zonePage(boolPtr(true), nil, "example.com", "d.com", "e.com"),
+			zonePage(boolPtr(false), nil, "f.com", "example.com"),
+		}
+	}
+	t.Run("all matching zones", func(t *testing.T) {
+		solver := &bunnySolver{}
+		ids, _, err := solver.scanZones(&pagedAPI{pages: pages()}, "example.com.", bunnyConfig{AllMatchingZones: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 1 || ids[0] != testZoneID("example.com") {
+			t.Errorf("got ids %v, want example.com once", ids)
+		}
+	})
+	t.Run("retried failed page", func(t *testing.T) {
+		api := &flakyPageAPI{pagedAPI: &pagedAPI{pages: pages()}, failures: map[int32]int{2: 1}}
+		solver := &bunnySolver{}
+		ids, scan, err := solver.scanZones(api, "example.com.", bunnyConfig{AllMatchingZones: true, SkipFailedZonePages: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 1 {
+			t.Errorf("got ids %v, want example.com once", ids)
+		}
+		if scan.zones != 6 {
+			t.Errorf("scanned %d zones, want the 6 distinct ones", scan.zones)
+		}
+	})
+}
+
+func TestListAllZonesStartPage(t *testing.T) {
+	api := &pagedAPI{pages: []*bunny.DNSZones{
+		zonePage(boolPtr(true), nil, "a.com", "b.com", "c.com"),
+		zonePage(boolPtr(false), nil, "d.com"),
+	}}
+	it := listAllZones(api, 2)
+	if _, _, err := it.next(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := it.next(context.Background()); ok {
+		t.Error("iterator continued past the last page")
+	}
+	if len(api.calls) != 1 || api.calls[0] != 2 {
+		t.Errorf("requested pages %v, want [2]", api.calls)
+	}
+}
+
+func TestListAllZonesError(t *testing.T) {
+	it := listAllZones(&pagedAPI{}, 1)
+	if _, ok, err := it.next(context.Background()); err == nil || ok {
+		t.Errorf("got ok %v, error %v, want an error", ok, err)
+	}
+}
+
+func TestListZoneIdsPagination(t *testing.T) {
+	tests := []struct {
+		name      string
+		pages     []*bunny.DNSZones
+		wantID    int64
+		wantCalls int
+	}{
+		{"target on first page", []*bunny.DNSZones{
+			zonePage(boolPtr(true), nil, "a.com", "example.com", "c.com"),
+			zonePage(boolPtr(false), nil, "d.com"),
+		}, testZoneID("example.com"), 1},
+		{"target on last page", []*bunny.DNSZones{
+			zonePage(boolPtr(true), nil, "a.com", "b.com", "c.com"),
+			zonePage(boolPtr(true), nil, "d.com", "e.com", "f.com"),
+			zonePage(boolPtr(false), nil, "g.com", "example.com"),
+		}, testZoneID("example.com"), 3},
+		{"target absent across pages", []*bunny.DNSZones{
+			zonePage(boolPtr(true), nil, "a.com", "b.com", "c.com"),
+			zonePage(boolPtr(false), nil, "d.com"),
+		}, 0, 2},
+		{"exactly one full page without has more", []*bunny.DNSZones{
+			zonePage(nil, int32Ptr(zonePageSize), "a.com", "b.com", "c.com"),
+		}, 0, 1},
+		{"single page with has more nil", []*bunny.DNSZones{
+			zonePage(nil, int32Ptr(2), "a.com", "example.com"),
+		}, testZoneID("example.com"), 1},
+		{"single page with has more false", []*bunny.DNSZones{
+			zonePage(boolPtr(false), nil, "a.com", "b.com", "c.com"),
+		}, 0, 1},
+		{"empty account", []*bunny.DNSZones{zonePage(boolPtr(false), int32Ptr(0))}, 0, 1},
+	}
+	noRetries := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &pagedAPI{pages: tt.pages}
+			solver := &bunnySolver{}
+			ids, err := solver.listZoneIds(context.Background(), api, "example.com.", bunnyConfig{EmptyZoneListRetries: &noRetries})
+			if tt.wantID == 0 {
+				if !errors.Is(err, errZoneNotFound) {
+					t.Errorf("got ids %v, error %v, want errZoneNotFound", ids, err)
+				}
+			} else if err != nil || len(ids) != 1 || ids[0] != tt.wantID {
+				t.Errorf("got ids %v, error %v, want [%d]", ids, err, tt.wantID)
+			}
+			if len(api.calls) != tt.wantCalls {
+				t.Errorf("requested pages %v, want %d List calls", api.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestScanZonesSkipFailedPages(t *testing.T) {
+	pages := func() []*bunny.DNSZones {
+		return []*bunny.DNSZones{
+			zonePage(boolPtr(true), nil, "a.com", "b.com", "c.com"),
+			zonePage(boolPtr(true), nil, "d.com", "example.com", "f.com"),
+			zonePage(boolPtr(false), nil, "g.com", "other.com"),
+		}
+	}
+	tests := []struct {
+		name      string
+		target    string
+		skip      bool
+		failures  map[int32]int
+		wantErr   bool
+		wantCalls []int32
+	}{
+		{"failed page aborts without the option", "other.com.", false, map[int32]int{2: 1}, true, []int32{1, 2}},
+		{"target after a failed page", "other.com.", true, map[int32]int{2: 1}, false, []int32{1, 2, 3}},
+		{"target on a page that recovers", "example.com.", true, map[int32]int{2: 1}, false, []int32{1, 2, 3, 2}},
+		{"target on a page that keeps failing", "example.com.", true, map[int32]int{2: 3}, true, []int32{1, 2, 3, 2, 2}},
+		{"consecutive failures end the scan", "other.com.", true, map[int32]int{1: 1, 2: 1, 3: 1}, true, []int32{1, 2, 3}},
+	}
+	noRetries := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &flakyPageAPI{pagedAPI: &pagedAPI{pages: pages()}, failures: tt.failures}
+			solver := &bunnySolver{}
+			cfg := bunnyConfig{SkipFailedZonePages: tt.skip, EmptyZoneListRetries: &noRetries}
+			ids, err := solver.listZoneIds(context.Background(), api, tt.target, cfg)
+			if tt.wantErr {
+				if err == nil || errors.Is(err, errZoneNotFound) {
+					t.Errorf("got ids %v, error %v, want a page error", ids, err)
+				}
+			} else if err != nil || len(ids) != 1 {
+				t.Errorf("got ids %v, error %v, want one zone", ids, err)
+			}
+			if fmt.Sprint(api.calls) != fmt.Sprint(tt.wantCalls) {
+				t.Errorf("requested pages %v, want %v", api.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestZoneNotFoundErrorCountsVisibleZones(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages []*bunny.DNSZones
+		want  string
+	}{
+		{"other zones", []*bunny.DNSZones{
+			zonePage(boolPtr(true), nil, "a.com", "b.com", "c.com"),
+			zonePage(boolPtr(false), nil, "d.com"),
+		}, "not among the 4 zones the access key sees"},
+		{"no zones", []*bunny.DNSZones{zonePage(boolPtr(false), int32Ptr(0))}, "the access key sees no zones at all"},
+	}
+	noRetries := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			solver := &bunnySolver{}
+			_, err := solver.listZoneIds(context.Background(), &pagedAPI{pages: tt.pages}, "example.com.", bunnyConfig{EmptyZoneListRetries: &noRetries})
+			if !errors.Is(err, errZoneNotFound) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want errZoneNotFound saying %q", err, tt.want)
+			}
+		})
+	}
+}
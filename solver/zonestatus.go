@@ -0,0 +1,31 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+)
+
+// checkZoneActive fails when bunny.net reports that the zone is not set up
+// to serve, so that Present stops with a clear error instead of writing a
+// record the CA will never see. bunny.net's zone API has no enabled flag;
+// a zone serves once bunny.net has detected that the domain's nameservers
+// point at it, which it reports as NameserversDetected. A zone that does
+// not report the field passes.
+func checkZoneActive(client DNSZoneAPI, zoneID int64) error {
+	zone, err := client.Get(context.Background(), zoneID)
+	if err != nil {
+		return fmt.Errorf("error checking whether the zone is active: %w", err)
+	}
+	if zone.NameserversDetected == nil || *zone.NameserversDetected {
+		return nil
+	}
+	domain := fmt.Sprint(zoneID)
+	if zone.Domain != nil {
+		domain = fmt.Sprintf("%s (ID %d)", *zone.Domain, zoneID)
+	}
+	msg := fmt.Sprintf("zone %s is not active in bunny.net: its nameservers have not been detected", domain)
+	if zone.NameserversNextCheck != nil {
+		msg += ", next check at " + *zone.NameserversNextCheck
+	}
+	return fmt.Errorf("%s; point the domain's NS records at bunny.net, or turn off requireActiveZone", msg)
+}
@@ -0,0 +1,50 @@
+package solver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPresentRequiresActiveZone(t *testing.T) {
+	detected := func(v bool) *bool { return &v }
+	nextCheck := "2026-10-14T12:00:00"
+	tests := []struct {
+		name     string
+		detected *bool
+		config   string
+		wantErr  bool
+	}{
+		{"nameservers not detected", detected(false), `,"requireActiveZone":true`, true},
+		{"nameservers detected", detected(true), `,"requireActiveZone":true`, false},
+		{"not reported", nil, `,"requireActiveZone":true`, false},
+		{"check off", detected(false), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeDNSZoneAPI("example.com")
+			api.zones[0].NameserversDetected = tt.detected
+			api.zones[0].NameserversNextCheck = &nextCheck
+			err := newTestSolver(api).present(newChallenge("_acme-challenge.example.com.", "example.com.", "key", tt.config))
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if api.addCalls != 1 {
+					t.Errorf("made %d add calls, want 1", api.addCalls)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Present succeeded in an inactive zone")
+			}
+			for _, want := range []string{"example.com (ID 1) is not active", "next check at " + nextCheck, "requireActiveZone"} {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+			if api.addCalls != 0 {
+				t.Errorf("made %d add calls in an inactive zone, want none", api.addCalls)
+			}
+		})
+	}
+}